@@ -2,4 +2,9 @@ module bot-cli
 
 go 1.21.6
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require (
+	github.com/TheOrionAI/botcall-sdk-go v0.0.0
+	github.com/gorilla/websocket v1.5.3
+)
+
+replace github.com/TheOrionAI/botcall-sdk-go => ../../sdk-go
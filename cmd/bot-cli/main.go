@@ -9,6 +9,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -18,7 +21,11 @@ var (
 	agentID        = flag.String("agent-id", "orion", "Your bot's unique ID")
 	discoveryURL   = flag.String("discovery", "http://localhost:8080", "BotCall discovery server URL")
 	endpointAddr   = flag.String("endpoint", "localhost:9000", "Local HTTP endpoint (ip:port)")
-	useLocaltunnel = flag.Bool("lt", false, "Use localtunnel to expose endpoint publicly")
+	useLocaltunnel = flag.Bool("lt", false, "Expose the endpoint publicly through a discovery server tunnel")
+	useRelay       = flag.Bool("relay", false, "Take calls over an outbound socket to discovery (no inbound port needed)")
+	displayName    = flag.String("name", "", "Display name shown on the bot's card")
+	description    = flag.String("description", "", "One-line description shown on the bot's card")
+	regKey         = flag.String("registration-key", os.Getenv("BOTCALL_REGISTRATION_KEY"), "Secret proving ownership of the agent ID (issued by discovery if empty)")
 )
 
 func main() {
@@ -44,10 +51,43 @@ func main() {
 		// keep public as full URL
 	}
 
-	// Start localtunnel if requested
+	// HTTP server for incoming calls
+	http.HandleFunc("/call", handleIncomingCall)
+	http.HandleFunc("/health", handleHealth)
+	// Always return OK for OPTIONS/preflight
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "agent": *agentID})
+	})
+
+	// Go offline in discovery right away on Ctrl+C
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+		if err := deregisterFromDiscovery(); err != nil {
+			log.Printf("⚠️  Deregister failed: %v", err)
+		}
+		os.Exit(0)
+	}()
+
+	if *useRelay {
+		log.Printf("   Humans can dial: %s/v1/lookup/%s", *discoveryURL, *agentID)
+		runRelay(http.DefaultServeMux)
+	}
+
+	// Tunnel through discovery if requested; the tunnel registers the
+	// public URL it is given and its socket keeps the bot online
 	if *useLocaltunnel {
-		publicEndpoint = startLocaltunnelSimple(listenAddr)
-		log.Printf("🌐 Public URL: %s", publicEndpoint)
+		go runRelay(localProxy(listenAddr))
+		log.Printf("📞 Listening for calls on %s", listenAddr)
+		log.Fatal(http.ListenAndServe(listenAddr, nil))
 	}
 
 	// Register with discovery
@@ -66,47 +106,24 @@ func main() {
 		}
 	}()
 
-	// HTTP server for incoming calls
-	http.HandleFunc("/call", handleIncomingCall)
-	http.HandleFunc("/health", handleHealth)
-	// Always return OK for OPTIONS/preflight
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "agent": *agentID})
-	})
-
 	log.Fatal(http.ListenAndServe(listenAddr, nil))
 }
 
-func startLocaltunnelSimple(endpoint string) string {
-	port := "9000"
-	// Extract port from endpoint
-	for i := len(endpoint) - 1; i >= 0; i-- {
-		if endpoint[i] == ':' {
-			port = endpoint[i+1:]
-			break
-		}
-	}
-
-	log.Printf("🔧 Start localtunnel: npx localtunnel --port %s", port)
-	log.Printf("   (run in another terminal, then update --endpoint URL)")
-
-	// Return placeholder - user will run lt manually
-	return "https://YOUR-LT-URL.loca.lt"
-}
-
 func registerWithDiscovery(publicEndpoint string) error {
+	// Without a public endpoint, calls come over the relay socket
+	mode := "direct"
+	if publicEndpoint == "" {
+		mode = "relay"
+	}
 	reqBody, _ := json.Marshal(map[string]interface{}{
-		"agent_id":    *agentID,
-		"endpoint":    publicEndpoint,
-		"mode":        "direct",
-		"attestation": "test-attestation",
+		"agent_id":         *agentID,
+		"endpoint":         publicEndpoint,
+		"mode":             mode,
+		"attestation":      "test-attestation",
+		"registration_key": *regKey,
+		"display_name":     *displayName,
+		"description":      *description,
+		"modes":            []string{"text"}, // replies are text, even on calls
 	})
 
 	resp, err := http.Post(*discoveryURL+"/v1/register", "application/json", bytes.NewReader(reqBody))
@@ -120,18 +137,48 @@ func registerWithDiscovery(publicEndpoint string) error {
 	}
 
 	var result struct {
-		Confirmed bool   `json:"confirmed"`
-		URL       string `json:"url"`
-		Status    string `json:"status"`
+		Confirmed       bool   `json:"confirmed"`
+		URL             string `json:"url"`
+		Status          string `json:"status"`
+		RegistrationKey string `json:"registration_key"`
 	}
 	json.NewDecoder(resp.Body).Decode(&result)
 
+	if result.RegistrationKey != "" && result.RegistrationKey != *regKey {
+		*regKey = result.RegistrationKey
+		// The key is a secret: it goes to stdout once, never to the log
+		fmt.Printf("BOTCALL_REGISTRATION_KEY=%s\n", result.RegistrationKey)
+		log.Printf("🔑 Registration key issued and printed to stdout")
+		log.Printf("   Set BOTCALL_REGISTRATION_KEY to keep %s across restarts", *agentID)
+	}
+
 	if result.Confirmed {
 		log.Printf("✅ Registered: %s", result.Status)
 	}
 	return nil
 }
 
+func deregisterFromDiscovery() error {
+	req, err := http.NewRequest(http.MethodDelete, *discoveryURL+"/v1/register/"+*agentID, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*regKey)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("discovery returned %d", resp.StatusCode)
+	}
+	log.Printf("👋 Deregistered %s", *agentID)
+	return nil
+}
+
 type CallRequest struct {
 	HumanID     string `json:"human_id"`
 	Attestation string `json:"attestation"`
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheOrionAI/botcall-sdk-go/relayproto"
+	"github.com/gorilla/websocket"
+)
+
+// runRelay keeps an outbound socket to discovery open and serves calls
+// over it, reconnecting with backoff. It never returns. With --lt the
+// socket is a tunnel: the bot registers the public URL discovery assigns
+// to it as its endpoint.
+func runRelay(handler http.Handler) {
+	// Discovery only accepts the socket of a registered bot, and the tunnel
+	// URL comes over that socket, so the ID is claimed in relay mode first:
+	// humans reach the bot through the socket, never at the local address
+	endpoint := ""
+	onTunnel := func(url string) {
+		if !*useLocaltunnel || url == endpoint {
+			return
+		}
+		endpoint = url
+		log.Printf("🌐 Public URL: %s", url)
+		if err := registerWithDiscovery(url); err != nil {
+			log.Printf("⚠️  Register tunnel URL failed: %v", err)
+		}
+	}
+
+	backoff := time.Second
+	for {
+		err := registerWithDiscovery(endpoint)
+		if err == nil {
+			err = serveRelay(handler, onTunnel)
+			backoff = time.Second
+		}
+		log.Printf("⚠️  Relay dropped: %v; retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+// localProxy forwards tunnelled requests to the bot's own listener
+func localProxy(listenAddr string) http.Handler {
+	return httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: listenAddr})
+}
+
+func serveRelay(handler http.Handler, onTunnel func(url string)) error {
+	wsURL := strings.Replace(strings.TrimSuffix(*discoveryURL, "/"), "http", "ws", 1) +
+		"/v1/ws?role=bot&agent=" + url.QueryEscape(*agentID)
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+*regKey)
+
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("discovery returned %d", resp.StatusCode)
+		}
+		return err
+	}
+	defer conn.Close()
+	log.Printf("🔌 Connected to discovery; humans reach %s through it", *agentID)
+
+	var writeMu sync.Mutex
+	send := func(v interface{}) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		conn.WriteJSON(v)
+	}
+
+	for {
+		var frame relayproto.Frame
+		if err := conn.ReadJSON(&frame); err != nil {
+			return err
+		}
+
+		switch frame.Type {
+		case "tunnel":
+			onTunnel(frame.URL)
+
+		case "request":
+			go func(frame relayproto.Frame) {
+				send(relayproto.ServeRequest(handler, frame))
+			}(frame)
+
+		case "call":
+			log.Printf("📲 Relayed call from: %s", frame.HumanID)
+			send(relayproto.Frame{Type: "accept", CallID: frame.CallID, Mode: "text"})
+			send(relayproto.Frame{
+				Type:   "text",
+				CallID: frame.CallID,
+				Text:   fmt.Sprintf("Hello %s! I'm %s. How can I help you today?", frame.HumanID, *agentID),
+			})
+
+		case "text":
+			log.Printf("💬 Received: %s", frame.Text)
+			send(relayproto.Frame{
+				Type:   "text",
+				CallID: frame.CallID,
+				Text:   fmt.Sprintf("Echo: %s", frame.Text),
+			})
+
+		case "hangup":
+			log.Printf("📴 Call %s ended (%s)", frame.CallID, frame.Reason)
+		}
+	}
+}
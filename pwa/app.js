@@ -19,6 +19,12 @@ class BotCallPWA {
     this.speechSynthesis = window.speechSynthesis;
     this.speechRecognition = null;
     this.elements = {};
+    this.recentBots = JSON.parse(localStorage.getItem('recentBots') || '[]');
+    this.presence = {};
+    this.presenceSource = null;
+    // A stable ID lets sticky bots send us back to the same instance
+    this.humanId = localStorage.getItem('humanId') || 'human-' + Math.random().toString(36).substr(2, 8);
+    localStorage.setItem('humanId', this.humanId);
     this.init();
   }
 
@@ -28,6 +34,8 @@ class BotCallPWA {
     this.initSpeechRecognition();
     
     localStorage.setItem('discoveryUrl', this.discoveryUrl);
+    this.renderRecentBots();
+    this.watchPresence();
   }
 
   cacheElements() {
@@ -50,6 +58,11 @@ class BotCallPWA {
       voiceWaves: document.getElementById('voiceWaves'),
       voiceStatus: document.getElementById('voiceStatus'),
       chatArea: document.getElementById('textMode'),
+      botAvatar: document.getElementById('botAvatar'),
+      botName: document.getElementById('botName'),
+      botDescription: document.getElementById('botDescription'),
+      botTags: document.getElementById('botTags'),
+      recentBots: document.getElementById('recentBots'),
       modeOptions: document.querySelectorAll('.mode-option')
     };
 
@@ -67,7 +80,9 @@ class BotCallPWA {
       if (e.key === 'Enter') this.sendTextMessage();
     });
     this.elements.modeOptions?.forEach(opt => {
-      opt.addEventListener('click', () => this.switchMode(opt.dataset.mode));
+      opt.addEventListener('click', () => {
+        if (!opt.classList.contains('unsupported')) this.switchMode(opt.dataset.mode);
+      });
     });
   }
 
@@ -89,6 +104,51 @@ class BotCallPWA {
     };
   }
 
+  // watchPresence follows the recent bots' status over Server-Sent Events;
+  // EventSource reconnects on its own if the stream drops
+  watchPresence() {
+    this.presenceSource?.close();
+    this.presenceSource = null;
+    if (!this.recentBots.length || !window.EventSource) return;
+
+    const base = this.discoveryUrl.replace(/\/+$/, '');
+    const agents = this.recentBots.map(encodeURIComponent).join(',');
+    this.presenceSource = new EventSource(`${base}/v1/presence/stream?agents=${agents}`);
+    this.presenceSource.addEventListener('presence', (event) => {
+      const msg = JSON.parse(event.data);
+      this.presence[msg.agent_id] = msg;
+      this.renderRecentBots();
+    });
+  }
+
+  rememberBot(botId) {
+    this.recentBots = [botId, ...this.recentBots.filter(id => id !== botId)].slice(0, 5);
+    localStorage.setItem('recentBots', JSON.stringify(this.recentBots));
+    this.renderRecentBots();
+    this.watchPresence();
+  }
+
+  renderRecentBots() {
+    const list = this.elements.recentBots;
+    if (!list) return;
+    list.replaceChildren();
+    this.recentBots.forEach(botId => {
+      const msg = this.presence[botId];
+      const status = msg?.status || 'unknown';
+      const chip = document.createElement('span');
+      chip.className = 'recent-bot';
+      // e.g. "dnd: Stargazing" or "in_call"
+      chip.title = msg ? [msg.presence, msg.status_message].filter(Boolean).join(': ') : status;
+      const dot = document.createElement('span');
+      dot.className = `presence-dot ${status}`;
+      chip.append(dot, botId);
+      chip.addEventListener('click', () => {
+        if (this.elements.botId) this.elements.botId.value = botId;
+      });
+      list.appendChild(chip);
+    });
+  }
+
   async connect() {
     this.botId = this.elements.botId?.value.trim();
     if (!this.botId) {
@@ -96,28 +156,42 @@ class BotCallPWA {
       return;
     }
 
-    if (this.elements.discoveryUrl?.value) {
+    if (this.elements.discoveryUrl?.value && this.elements.discoveryUrl.value.trim() !== this.discoveryUrl) {
       this.discoveryUrl = this.elements.discoveryUrl.value.trim();
       localStorage.setItem('discoveryUrl', this.discoveryUrl);
+      this.watchPresence();
     }
 
     this.setConnectionStatus('connecting', 'Connecting...');
     if (this.elements.connectBtn) this.elements.connectBtn.disabled = true;
 
     try {
-      const response = await fetch(`${this.discoveryUrl}/v1/lookup/${this.botId}`);
+      // One ID follows this call from lookup through discovery to the bot,
+      // so their logs can be matched up
+      this.requestId = crypto.randomUUID?.() || Math.random().toString(16).slice(2) + Date.now().toString(16);
+      // ...and one W3C trace, so a traced discovery and bot join up their spans
+      this.traceparent = `00-${this.randomHex(16)}-${this.randomHex(8)}-01`;
+      const response = await fetch(`${this.discoveryUrl}/v1/lookup/${this.botId}?human=${encodeURIComponent(this.humanId)}`, {
+        headers: { 'X-Request-ID': this.requestId, 'traceparent': this.traceparent }
+      });
       if (!response.ok) throw new Error(`Bot not found: ${response.status}`);
 
       const botInfo = await response.json();
 
       if (botInfo.status === 'online') {
+        this.rememberBot(this.botId);
         this.elements.connectCard?.classList.add('hidden');
         this.elements.callCard?.classList.remove('hidden');
-        if (this.elements.activeBotId) this.elements.activeBotId.textContent = this.botId;
+        if (this.elements.activeBotId) this.elements.activeBotId.textContent = botInfo.display_name || this.botId;
+        this.renderBotCard(botInfo);
         this.setConnectionStatus('online', 'Connected');
 
         await this.startCall(botInfo);
         this.startCallTimer();
+      } else if (botInfo.status === 'degraded') {
+        throw new Error('Bot is not responding right now, try later');
+      } else if (botInfo.status === 'busy') {
+        throw new Error(botInfo.message || 'Bot is busy, try later');
       } else {
         throw new Error('Bot is offline');
       }
@@ -127,18 +201,54 @@ class BotCallPWA {
     }
   }
 
+  renderBotCard(botInfo) {
+    const { botAvatar, botName, botDescription, botTags } = this.elements;
+    if (botName) botName.textContent = botInfo.display_name || this.botId;
+    if (botDescription) botDescription.textContent = botInfo.description || '';
+    if (botAvatar) {
+      botAvatar.classList.toggle('hidden', !botInfo.avatar_url);
+      if (botInfo.avatar_url) botAvatar.src = botInfo.avatar_url;
+    }
+    if (botTags) {
+      botTags.replaceChildren();
+      const labels = [...(botInfo.tags || []), ...(botInfo.languages || [])];
+      labels.forEach(label => {
+        const tag = document.createElement('span');
+        tag.className = 'bot-tag';
+        tag.textContent = label;
+        botTags.appendChild(tag);
+      });
+    }
+
+    // Offer only the modes the bot takes; no list means any
+    const modes = botInfo.modes?.length ? botInfo.modes : ['voice', 'text'];
+    this.elements.modeOptions?.forEach(opt => {
+      opt.classList.toggle('unsupported', !modes.includes(opt.dataset.mode));
+    });
+    if (!modes.includes(this.currentMode)) {
+      this.switchMode(modes.includes('text') ? 'text' : 'voice');
+    }
+  }
+
   async startCall(botInfo) {
     this.callActive = true;
-    
-    // Try to POST to the bot's /call endpoint
+    this.callId = null;
+    this.iceServers = null;
+    this.botInfo = botInfo;
+
+    // Ring the bot through the discovery server's signaling socket; bots
+    // without one fall back to a direct POST to their /call endpoint
+    this.connectWebSocket();
+  }
+
+  async startDirectCall(botInfo) {
     try {
-      const humanId = 'human-' + Math.random().toString(36).substr(2, 8);
       const endpoint = botInfo.endpoint || `${this.discoveryUrl.replace(/\/+$/, '')}/call`;
       
       const callResp = await fetch(endpoint, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ human_id: humanId, attestation: '' })
+        headers: { 'Content-Type': 'application/json', 'X-Request-ID': this.requestId, 'traceparent': this.traceparent },
+        body: JSON.stringify({ human_id: this.humanId, attestation: '' })
       });
 
       if (callResp.ok) {
@@ -147,35 +257,176 @@ class BotCallPWA {
           this.addMessage('bot', callResult.message);
           this.speak(callResult.message);
         }
-        this.connectWebSocket();
+        this.iceServers = callResult.ice_servers;
+        if (callResult.socket) this.connectCallSocket(endpoint, callResult.call_id);
       } else {
         this.switchMode('text');
       }
     } catch (e) {
-      console.log('Direct call failed, using text mode:', e);
+      console.log(`Direct call failed (request ${this.requestId}), using text mode:`, e);
+      this.switchMode('text');
+    }
+  }
+
+  // Direct calls talk over the bot's own socket, with the same text, hangup
+  // and WebRTC messages as signaling, so it replaces the signaling socket
+  connectCallSocket(endpoint, callId) {
+    this.callId = callId;
+    if (this.websocket) {
+      this.websocket.onmessage = null;
+      this.websocket.close();
+    }
+    const url = `${endpoint.replace(/^http/, 'ws').replace(/\/+$/, '')}/ws?call_id=${encodeURIComponent(callId)}`;
+    this.websocket = new WebSocket(url);
+    this.websocket.onmessage = (event) => this.handleSignal(JSON.parse(event.data));
+    this.websocket.onerror = (e) => console.log('Call socket error:', e);
+    // Voice goes over WebRTC, negotiated on the same socket
+    if (this.currentMode === 'voice') {
+      this.websocket.onopen = () => this.startWebRTC();
+    } else {
       this.switchMode('text');
     }
   }
 
+  randomHex(bytes) {
+    const buf = crypto.getRandomValues(new Uint8Array(bytes));
+    return Array.from(buf, b => b.toString(16).padStart(2, '0')).join('');
+  }
+
   connectWebSocket() {
     try {
-      const wsUrl = this.discoveryUrl.replace('http', 'ws') + '?agent=' + this.botId;
-      this.websocket = new WebSocket(wsUrl);
-      
-      this.websocket.onmessage = (event) => {
-        const data = JSON.parse(event.data);
-        if (data.type === 'text') {
-          this.addMessage('bot', data.text);
-          this.speak(data.text);
-        }
-      };
+      const base = this.discoveryUrl.replace(/^http/, 'ws').replace(/\/+$/, '');
+      this.websocket = new WebSocket(`${base}/v1/ws?agent=${encodeURIComponent(this.botId)}`);
 
+      this.websocket.onopen = () => {
+        this.signal({ type: 'call', human_id: this.humanId, mode: this.currentMode, traceparent: this.traceparent });
+      };
+      this.websocket.onmessage = (event) => this.handleSignal(JSON.parse(event.data));
       this.websocket.onerror = (e) => console.log('WS error:', e);
     } catch (e) {
       console.log('WebSocket not available:', e);
+      this.startDirectCall(this.botInfo);
     }
   }
 
+  signal(msg) {
+    if (this.websocket?.readyState === WebSocket.OPEN) {
+      this.websocket.send(JSON.stringify(msg));
+    }
+  }
+
+  async handleSignal(data) {
+    switch (data.type) {
+      case 'ringing':
+        this.callId = data.call_id;
+        this.setConnectionStatus('connecting', 'Ringing...');
+        break;
+      case 'accept':
+        this.setConnectionStatus('online', 'Connected');
+        if (data.mode === 'text') {
+          this.switchMode('text');
+        } else {
+          await this.startWebRTC();
+        }
+        break;
+      case 'answer':
+        await this.peerConnection?.setRemoteDescription({ type: 'answer', sdp: data.sdp });
+        break;
+      case 'offer':
+        await this.acceptOffer(data.sdp);
+        break;
+      case 'candidate':
+        if (data.candidate) await this.peerConnection?.addIceCandidate(data.candidate);
+        break;
+      case 'text':
+        this.addMessage('bot', data.text);
+        this.speak(data.text);
+        break;
+      case 'reject':
+        this.setConnectionStatus('offline', 'Call declined');
+        break;
+      case 'hangup':
+        if (data.reason === 'bot_unavailable') {
+          // The bot has no signaling socket; try reaching it directly
+          this.startDirectCall(this.botInfo);
+          break;
+        }
+        if (data.reason === 'busy') {
+          // The bot went busy between lookup and ringing
+          this.endCall('busy, try later');
+          break;
+        }
+        if (data.call_id === this.callId) this.endCall(data.reason);
+        break;
+    }
+  }
+
+  // TURN credentials go only to parties to a call: direct calls get the
+  // bot's own servers with the call, signaled calls show their call_id
+  async fetchIceServers() {
+    if (this.iceServers?.length) return this.iceServers;
+    try {
+      const base = this.discoveryUrl.replace(/\/+$/, '');
+      const call = this.callId ? `&call_id=${encodeURIComponent(this.callId)}` : '';
+      const resp = await fetch(`${base}/v1/ice-servers?user=${encodeURIComponent(this.humanId)}${call}`);
+      if (resp.ok) {
+        const { ice_servers: iceServers } = await resp.json();
+        if (iceServers?.length) return iceServers;
+      }
+    } catch (e) {
+      console.log('ICE servers unavailable, using public STUN:', e);
+    }
+    return [{ urls: 'stun:stun.l.google.com:19302' }];
+  }
+
+  async createPeerConnection() {
+    const iceServers = await this.fetchIceServers();
+    this.peerConnection = new RTCPeerConnection({ iceServers });
+
+    this.peerConnection.onicecandidate = (event) => {
+      if (event.candidate) {
+        this.signal({ type: 'candidate', call_id: this.callId, candidate: event.candidate.toJSON() });
+      }
+    };
+    // Chat rides a data channel once connected; the bot opens the same
+    // pre-negotiated channel on its side
+    this.textChannel = this.peerConnection.createDataChannel('text', { negotiated: true, id: 0 });
+    this.textChannel.onmessage = (event) => {
+      this.addMessage('bot', event.data);
+      this.speak(event.data);
+    };
+    this.peerConnection.ontrack = (event) => {
+      const audio = new Audio();
+      audio.srcObject = event.streams[0];
+      audio.play().catch(e => console.log('Remote audio blocked:', e));
+    };
+
+    if (this.currentMode === 'voice') {
+      try {
+        this.localStream = await navigator.mediaDevices.getUserMedia({ audio: true });
+        this.localStream.getTracks().forEach(t => this.peerConnection.addTrack(t, this.localStream));
+      } catch (e) {
+        console.log('Microphone unavailable, using text mode:', e);
+        this.switchMode('text');
+      }
+    }
+  }
+
+  async startWebRTC() {
+    await this.createPeerConnection();
+    const offer = await this.peerConnection.createOffer({ offerToReceiveAudio: true });
+    await this.peerConnection.setLocalDescription(offer);
+    this.signal({ type: 'offer', call_id: this.callId, sdp: offer.sdp });
+  }
+
+  async acceptOffer(sdp) {
+    if (!this.peerConnection) await this.createPeerConnection();
+    await this.peerConnection.setRemoteDescription({ type: 'offer', sdp });
+    const answer = await this.peerConnection.createAnswer();
+    await this.peerConnection.setLocalDescription(answer);
+    this.signal({ type: 'answer', call_id: this.callId, sdp: answer.sdp });
+  }
+
   switchMode(mode) {
     this.currentMode = mode;
     
@@ -201,8 +452,10 @@ class BotCallPWA {
 
     this.addMessage('human', message);
     
-    if (this.websocket?.readyState === WebSocket.OPEN) {
-      this.websocket.send(JSON.stringify({ type: 'text', text: message }));
+    if (this.textChannel?.readyState === 'open') {
+      this.textChannel.send(message);
+    } else {
+      this.signal({ type: 'text', call_id: this.callId, text: message });
     }
 
     if (this.elements.messageInput) {
@@ -254,16 +507,24 @@ class BotCallPWA {
   }
 
   hangup() {
+    if (this.callId) this.signal({ type: 'hangup', call_id: this.callId });
+    this.endCall('normal');
+  }
+
+  endCall(reason) {
     this.callActive = false;
+    this.callId = null;
     this.websocket?.close();
     this.peerConnection?.close();
+    this.peerConnection = null;
+    this.textChannel = null;
     this.localStream?.getTracks().forEach(t => t.stop());
     this.speechRecognition?.stop();
     
     this.elements.callCard?.classList.add('hidden');
     this.elements.connectCard?.classList.remove('hidden');
     this.elements.connectBtn?.removeAttribute('disabled');
-    this.setConnectionStatus('offline', 'Offline');
+    this.setConnectionStatus('offline', reason === 'normal' ? 'Offline' : `Call ended (${reason})`);
   }
 }
 
@@ -0,0 +1,227 @@
+package botcall
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Audio codecs
+const (
+	CodecPCM  = "pcm"  // signed 16-bit samples, interleaved by channel
+	CodecOpus = "opus" // one Opus packet per frame, as carried over RTP
+)
+
+// AudioFormat describes the frames of a stream
+type AudioFormat struct {
+	Codec      string
+	SampleRate int // Hz
+	Channels   int
+}
+
+// WebRTCAudio is the format browsers send and expect: Opus at 48 kHz,
+// negotiated as stereo
+var WebRTCAudio = AudioFormat{Codec: CodecOpus, SampleRate: 48000, Channels: 2}
+
+// AudioFrame is one chunk of audio, typically 20 ms
+type AudioFrame struct {
+	Format AudioFormat
+	// PCM holds the samples of CodecPCM frames
+	PCM []int16
+	// Opus holds the packet of CodecOpus frames
+	Opus []byte
+	// Timestamp is the frame's offset from the start of the stream
+	Timestamp time.Duration
+	// Duration is how much audio the frame holds
+	Duration time.Duration
+}
+
+// pcmDuration is how long samples of format play for
+func pcmDuration(samples int, format AudioFormat) time.Duration {
+	if format.SampleRate <= 0 || format.Channels <= 0 {
+		return 0
+	}
+	return time.Duration(samples/format.Channels) * time.Second / time.Duration(format.SampleRate)
+}
+
+// AudioSource produces frames in order; ReadFrame returns io.EOF after
+// the last
+type AudioSource interface {
+	ReadFrame() (AudioFrame, error)
+}
+
+// AudioSink consumes frames in order
+type AudioSink interface {
+	WriteFrame(AudioFrame) error
+}
+
+// ErrStreamClosed is returned when writing to a closed AudioStream
+var ErrStreamClosed = errors.New("botcall: audio stream closed")
+
+// audioBuffer is how many frames a stream holds, a second of 20 ms frames
+const audioBuffer = 50
+
+// AudioStream is a bounded queue of frames between a producer and a
+// consumer, such as the call's transport and the bot. WriteFrame blocks
+// while the queue is full, so a source that outpaces its reader is held
+// back; Offer drops the frame instead, for live audio that cannot wait.
+// Closing the stream ends reads once the queued frames are drained.
+type AudioStream struct {
+	format    AudioFormat
+	frames    chan AudioFrame
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	dropped   int
+}
+
+// NewAudioStream returns an empty stream of format
+func NewAudioStream(format AudioFormat) *AudioStream {
+	return &AudioStream{
+		format: format,
+		frames: make(chan AudioFrame, audioBuffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// Format is the format the stream's frames are expected in
+func (s *AudioStream) Format() AudioFormat {
+	return s.format
+}
+
+// WriteFrame queues frame, waiting for room
+func (s *AudioStream) WriteFrame(frame AudioFrame) error {
+	select {
+	case <-s.done:
+		return ErrStreamClosed
+	default:
+	}
+	select {
+	case s.frames <- frame:
+		return nil
+	case <-s.done:
+		return ErrStreamClosed
+	}
+}
+
+// Offer queues frame if there is room and reports whether it did
+func (s *AudioStream) Offer(frame AudioFrame) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.frames <- frame:
+		return true
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+		return false
+	}
+}
+
+// Dropped counts the frames Offer could not queue
+func (s *AudioStream) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// ReadFrame returns the next frame, waiting for one; io.EOF once the
+// stream is closed and drained
+func (s *AudioStream) ReadFrame() (AudioFrame, error) {
+	select {
+	case frame := <-s.frames:
+		return frame, nil
+	case <-s.done:
+	}
+	// Closed; hand out what is left
+	select {
+	case frame := <-s.frames:
+		return frame, nil
+	default:
+		return AudioFrame{}, io.EOF
+	}
+}
+
+// Close ends the stream; blocked writers fail and readers drain
+func (s *AudioStream) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// CopyAudio moves frames from src to dst until src is exhausted, either
+// side fails or ctx is cancelled. It returns nil at the end of src.
+func CopyAudio(ctx context.Context, dst AudioSink, src AudioSource) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := dst.WriteFrame(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// PaceAudio holds back src's frames until their timestamps come due, so
+// a recording plays into a call in real time rather than all at once
+func PaceAudio(src AudioSource) AudioSource {
+	return &pacedSource{src: src}
+}
+
+type pacedSource struct {
+	src   AudioSource
+	start time.Time
+}
+
+func (p *pacedSource) ReadFrame() (AudioFrame, error) {
+	frame, err := p.src.ReadFrame()
+	if err != nil {
+		return frame, err
+	}
+	if p.start.IsZero() {
+		p.start = time.Now().Add(-frame.Timestamp)
+	}
+	if wait := time.Until(p.start.Add(frame.Timestamp)); wait > 0 {
+		time.Sleep(wait)
+	}
+	return frame, nil
+}
+
+// OnAudio sets a handler that takes each frame the human sends and
+// returns the frames to play back, if any, in its place on AudioOut. The
+// handler then owns AudioIn; do not read it yourself as well.
+func (c *Client) OnAudio(handler func(call *Call, frame AudioFrame) []AudioFrame) {
+	c.onAudioHandler = handler
+}
+
+// pumpAudio feeds a call's incoming audio through the OnAudio handler
+// until the call ends
+func (c *Client) pumpAudio(call *Call) {
+	handler := c.onAudioHandler
+	if handler == nil {
+		return
+	}
+	for {
+		frame, err := call.audioIn.ReadFrame()
+		if err != nil {
+			return
+		}
+		for _, out := range handler(call, frame) {
+			if call.audioOut.WriteFrame(out) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package botcall
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testPCM = AudioFormat{Codec: CodecPCM, SampleRate: 16000, Channels: 2}
+
+// writeWAV records samples of testPCM to a file and returns its path
+func writeWAV(t *testing.T, samples []int16) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.wav")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sink, err := NewWAVSink(file, testPCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteFrame(AudioFrame{Format: testPCM, PCM: samples}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ramp is 3.5 frames of distinct stereo samples at 20 ms
+func ramp() []int16 {
+	samples := make([]int16, 2*320*7/2)
+	for i := range samples {
+		samples[i] = int16(i*37 - 20000)
+	}
+	return samples
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	want := ramp()
+	data, err := os.ReadFile(writeWAV(t, want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Readers must skip chunks they do not know, between fmt and data
+	list := append([]byte("LIST\x05\x00\x00\x00extra\x00"), data[36:]...)
+	data = append(data[:36:36], list...)
+
+	src, err := NewWAVSource(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Format() != testPCM {
+		t.Fatalf("Format = %+v, want %+v", src.Format(), testPCM)
+	}
+	var got []int16
+	for i := 0; ; i++ {
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			if i != 4 {
+				t.Errorf("%d frames, want 4", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.Timestamp != time.Duration(i)*20*time.Millisecond {
+			t.Errorf("Frame %d at %v", i, frame.Timestamp)
+		}
+		if wantDur := 20 * time.Millisecond; i < 3 && frame.Duration != wantDur || i == 3 && frame.Duration != wantDur/2 {
+			t.Errorf("Frame %d lasts %v", i, frame.Duration)
+		}
+		got = append(got, frame.PCM...)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Read %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Sample %d = %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWAVSourceRejectsOtherFormats(t *testing.T) {
+	data, _ := os.ReadFile(writeWAV(t, ramp()))
+	data[34] = 8 // 8-bit samples
+	if _, err := NewWAVSource(bytes.NewReader(data), 0); err == nil {
+		t.Error("Expected 8-bit WAV to be rejected")
+	}
+	if _, err := NewWAVSink(nil, WebRTCAudio); err == nil {
+		t.Error("Expected a WAV sink for Opus to be refused")
+	}
+}
+
+func TestAudioStreamBackpressure(t *testing.T) {
+	s := NewAudioStream(testPCM)
+	for i := 0; i < audioBuffer; i++ {
+		if err := s.WriteFrame(AudioFrame{Timestamp: time.Duration(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	written := make(chan error, 1)
+	go func() { written <- s.WriteFrame(AudioFrame{Timestamp: audioBuffer}) }()
+	select {
+	case <-written:
+		t.Fatal("WriteFrame did not wait for room")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if s.Offer(AudioFrame{}) || s.Dropped() != 1 {
+		t.Errorf("Offer on a full stream: dropped %d, want 1", s.Dropped())
+	}
+
+	if frame, _ := s.ReadFrame(); frame.Timestamp != 0 {
+		t.Errorf("First frame read is %v", frame.Timestamp)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	if err := s.WriteFrame(AudioFrame{}); err != ErrStreamClosed {
+		t.Errorf("WriteFrame after Close: %v", err)
+	}
+	n := 0
+	for {
+		if _, err := s.ReadFrame(); err == io.EOF {
+			break
+		}
+		n++
+	}
+	if n != audioBuffer {
+		t.Errorf("Drained %d frames after Close, want %d", n, audioBuffer)
+	}
+}
+
+func TestLocalCallEchoesRecording(t *testing.T) {
+	c := NewClient("echo", "")
+	c.OnAudio(func(call *Call, frame AudioFrame) []AudioFrame {
+		return []AudioFrame{frame}
+	})
+	call, err := c.LocalCall("tester", testPCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ended := make(chan string, 1)
+	call.OnEnd(func(reason string) { ended <- reason })
+
+	in, err := os.Open(writeWAV(t, ramp()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	src, err := NewWAVSource(in, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		CopyAudio(context.Background(), call.AudioIn(), src)
+		call.AudioIn().Close()
+	}()
+
+	outPath := filepath.Join(t.TempDir(), "out.wav")
+	out, err := os.Create(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	sink, err := NewWAVSink(out, testPCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CopyAudio(context.Background(), sink, call.AudioOut()); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	select {
+	case reason := <-ended:
+		if reason != HangupLocal {
+			t.Errorf("Call ended with %q", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Local call never ended")
+	}
+	want, _ := os.ReadFile(writeWAV(t, ramp()))
+	got, _ := os.ReadFile(outPath)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Echoed WAV differs: %d bytes, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,436 @@
+package botcall
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Reasons a call ends with. Humans and bots may hang up with reasons of
+// their own; these are the ones the SDK and discovery use.
+const (
+	HangupNormal       = "normal"       // either party hung up
+	HangupDisconnected = "disconnected" // the human's or the bot's socket dropped
+	HangupTimeout      = "timeout"      // the human never opened the call socket
+	HangupShutdown     = "shutdown"     // the client was closed
+	HangupLocal        = "local"        // a LocalCall's handlers returned
+)
+
+// ErrCallEnded is returned when sending on a call that has ended
+var ErrCallEnded = errors.New("botcall: call has ended")
+
+const (
+	// callBuffer is how many unread human messages a call holds before
+	// dropping new ones
+	callBuffer = 64
+	// callSocketWait is how long a direct call waits for the human to open
+	// its socket before it ends
+	callSocketWait = 30 * time.Second
+)
+
+// Call represents an incoming call from a human. Relayed calls talk over
+// the client's relay socket; direct calls over a WebSocket the human opens
+// to the bot's /call/ws after placing the call.
+type Call struct {
+	CallID    string
+	HumanID   string
+	StartedAt time.Time
+	// RequestID is the X-Request-ID of the /call request that placed the
+	// call; empty for calls rung over signaling
+	RequestID string
+	client    *Client
+
+	relayed  bool
+	local    bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	received chan string
+	audioIn  *AudioStream
+	audioOut *AudioStream
+	// connected is closed once the peer connection first connects
+	connected     chan struct{}
+	connectedOnce sync.Once
+	// signals queues the human's WebRTC frames for the call's own
+	// goroutine, started on the first one
+	signals    chan relayFrame
+	signalOnce sync.Once
+
+	mu   sync.Mutex
+	conn *websocket.Conn // direct calls, once the human connects
+	// pending is what was sent before conn; on a direct call that ended
+	// first it ends with the hangup, kept for a human who connects late
+	pending []relayFrame
+	peer    *peer // voice calls, once the human's offer is answered
+	ended   bool
+	reason  string
+	onEnd   []func(reason string)
+	writeMu sync.Mutex // serializes writes to conn
+}
+
+func newCall(c *Client, id, humanID string, relayed bool, format AudioFormat) *Call {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Call{
+		CallID:    id,
+		HumanID:   humanID,
+		StartedAt: time.Now(),
+		client:    c,
+		relayed:   relayed,
+		ctx:       ctx,
+		cancel:    cancel,
+		received:  make(chan string, callBuffer),
+		audioIn:   NewAudioStream(format),
+		audioOut:  NewAudioStream(format),
+		connected: make(chan struct{}),
+	}
+}
+
+func newCallID() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return "call-" + hex.EncodeToString(b[:])
+}
+
+// Context is cancelled when the call ends
+func (call *Call) Context() context.Context {
+	return call.ctx
+}
+
+// Receive returns the human's chat messages, in order, and is closed when
+// the call ends. Messages go to the client's OnText handler instead when
+// one is set. A reader that falls far behind loses messages.
+func (call *Call) Receive() <-chan string {
+	return call.received
+}
+
+// AudioIn carries the human's audio to the bot, in the format of its
+// Format: on WebRTC calls, the Opus packets as they arrive. It is closed
+// when the call ends. Live audio that the bot does not keep up with is
+// dropped rather than delaying the call.
+func (call *Call) AudioIn() *AudioStream {
+	return call.audioIn
+}
+
+// AudioOut carries the bot's audio to the human, as Opus frames on WebRTC
+// calls. Writes block while the transport catches up, which plays them in
+// real time. Until WebRTC is up the latest few seconds are held, so a
+// greeting said in OnCall is heard; on text calls they are never played.
+func (call *Call) AudioOut() *AudioStream {
+	return call.audioOut
+}
+
+// SendText sends a chat message to the human, over the WebRTC data
+// channel once it is open. On a direct call it is otherwise queued until
+// the human's socket connects.
+func (call *Call) SendText(text string) error {
+	if call.sendDataChannel(text) {
+		return nil
+	}
+	return call.send(relayFrame{Type: "text", Text: text})
+}
+
+// Hangup ends the call, telling the human why; an empty reason is
+// HangupNormal
+func (call *Call) Hangup(reason string) error {
+	if reason == "" {
+		reason = HangupNormal
+	}
+	err := call.send(relayFrame{Type: "hangup", Reason: reason})
+	if errors.Is(err, ErrCallEnded) {
+		return err
+	}
+	call.end(reason)
+	return err
+}
+
+// OnEnd adds a hook run with the reason once the call ends, whichever side
+// ends it. Hooks added after the end run at once.
+func (call *Call) OnEnd(hook func(reason string)) {
+	call.mu.Lock()
+	if call.ended {
+		reason := call.reason
+		call.mu.Unlock()
+		hook(reason)
+		return
+	}
+	call.onEnd = append(call.onEnd, hook)
+	call.mu.Unlock()
+}
+
+// send writes frame to the human over the call's transport
+func (call *Call) send(frame relayFrame) error {
+	frame.CallID = call.CallID
+	call.mu.Lock()
+	if call.ended {
+		call.mu.Unlock()
+		return ErrCallEnded
+	}
+	if call.local {
+		call.mu.Unlock()
+		return nil
+	}
+	if call.relayed {
+		call.mu.Unlock()
+		return call.client.sendRelay(frame)
+	}
+	conn := call.conn
+	if conn == nil {
+		defer call.mu.Unlock()
+		if len(call.pending) >= callBuffer {
+			return errors.New("botcall: too many messages queued for the human's socket")
+		}
+		call.pending = append(call.pending, frame)
+		return nil
+	}
+	call.mu.Unlock()
+
+	call.writeMu.Lock()
+	defer call.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return conn.WriteJSON(frame)
+}
+
+// deliver hands a message from the human to the application
+func (call *Call) deliver(text string) {
+	if handler := call.client.onTextHandler; handler != nil {
+		go handler(call, text)
+		return
+	}
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	if call.ended {
+		return
+	}
+	select {
+	case call.received <- text:
+	default:
+		call.client.logger().Warn("call receive buffer full; dropping message", "call_id", call.CallID)
+	}
+}
+
+// end finishes the call once: it is forgotten by the client, its context
+// cancelled, Receive closed and the OnEnd hooks run. A direct call whose
+// human has not connected yet is forgotten only after callSocketWait, so
+// that a late socket still hears how it ended.
+func (call *Call) end(reason string) {
+	call.mu.Lock()
+	if call.ended {
+		call.mu.Unlock()
+		return
+	}
+	call.ended = true
+	call.reason = reason
+	close(call.received)
+	hooks, conn, p := call.onEnd, call.conn, call.peer
+	call.onEnd = nil
+	linger := !call.relayed && !call.local && conn == nil
+	if linger {
+		if n := len(call.pending); n == 0 || call.pending[n-1].Type != "hangup" {
+			call.pending = append(call.pending, relayFrame{Type: "hangup", CallID: call.CallID, Reason: reason})
+		}
+	} else {
+		call.pending = nil
+	}
+	call.mu.Unlock()
+
+	c := call.client
+	if linger {
+		time.AfterFunc(callSocketWait, func() { c.forgetCall(call) })
+	} else {
+		c.forgetCall(call)
+	}
+
+	call.cancel()
+	call.audioIn.Close()
+	call.audioOut.Close()
+	if conn != nil {
+		conn.Close()
+	}
+	if p != nil {
+		p.pc.Close()
+	}
+	c.logger().Info("call ended", "call_id", call.CallID, "reason", reason)
+	for _, hook := range hooks {
+		hook(reason)
+	}
+}
+
+// LocalCall runs a call with no human on the line through the OnCall and
+// OnAudio handlers, so a bot can be tested on recorded audio without a
+// browser: feed AudioIn, say from a WAVSource, and read what the bot says
+// from AudioOut, say into a WAVSink. The call ends with HangupLocal once
+// both handlers return; for handlers that read until AudioIn ends, that
+// is after you close it. Texts the bot sends are discarded.
+func (c *Client) LocalCall(humanID string, format AudioFormat) (*Call, error) {
+	call := newCall(c, newCallID(), humanID, false, format)
+	call.local = true
+	if !c.beginCall() {
+		return nil, ErrClosed
+	}
+	c.trackCall(call)
+	go func() {
+		pumped := make(chan struct{})
+		go func() {
+			c.pumpAudio(call)
+			close(pumped)
+		}()
+		c.dispatchCall(context.Background(), call)
+		<-pumped
+		call.end(HangupLocal)
+	}()
+	return call, nil
+}
+
+// trackCall makes call reachable by ID for its transport
+func (c *Client) trackCall(call *Call) {
+	c.mu.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]*Call)
+	}
+	c.calls[call.CallID] = call
+	c.mu.Unlock()
+}
+
+// forgetCall makes call unreachable by ID
+func (c *Client) forgetCall(call *Call) {
+	c.mu.Lock()
+	if c.calls[call.CallID] == call {
+		delete(c.calls, call.CallID)
+	}
+	c.mu.Unlock()
+}
+
+func (c *Client) call(id string) *Call {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.calls[id]
+}
+
+// liveCalls returns the calls not yet ended
+func (c *Client) liveCalls() []*Call {
+	c.mu.RLock()
+	calls := make([]*Call, 0, len(c.calls))
+	for _, call := range c.calls {
+		calls = append(calls, call)
+	}
+	c.mu.RUnlock()
+
+	live := calls[:0]
+	for _, call := range calls {
+		call.mu.Lock()
+		if !call.ended {
+			live = append(live, call)
+		}
+		call.mu.Unlock()
+	}
+	return live
+}
+
+// hangupAll ends every call, telling the humans why
+func (c *Client) hangupAll(reason string) {
+	for _, call := range c.liveCalls() {
+		call.Hangup(reason)
+	}
+}
+
+var callUpgrader = websocket.Upgrader{
+	// The call ID is the capability; browsers call from the PWA's origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleCallSocket serves /call/ws?call_id=<id>, the socket a human opens
+// after placing a direct call. It carries the same text, hangup and WebRTC
+// frames as signaling.
+func (c *Client) handleCallSocket(w http.ResponseWriter, r *http.Request) {
+	call := c.call(r.URL.Query().Get("call_id"))
+	if call == nil || call.relayed {
+		http.Error(w, "Unknown call", http.StatusNotFound)
+		return
+	}
+	call.mu.Lock()
+	taken := call.conn != nil || (call.ended && call.pending == nil)
+	call.mu.Unlock()
+	if taken {
+		http.Error(w, "Call already connected", http.StatusConflict)
+		return
+	}
+
+	conn, err := callUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	// Flush what the bot said before the human connected, ahead of anything
+	// sent from now on
+	call.writeMu.Lock()
+	call.mu.Lock()
+	if call.conn != nil || (call.ended && call.pending == nil) {
+		call.mu.Unlock()
+		call.writeMu.Unlock()
+		conn.Close()
+		return
+	}
+	ended := call.ended
+	if !ended {
+		call.conn = conn
+	}
+	pending := call.pending
+	call.pending = nil
+	call.mu.Unlock()
+	for _, frame := range pending {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := conn.WriteJSON(frame); err != nil {
+			break
+		}
+	}
+	call.writeMu.Unlock()
+	if ended {
+		// The call ended before the human got here; pending closed with
+		// the hangup
+		conn.Close()
+		return
+	}
+
+	for {
+		var frame relayFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			call.end(HangupDisconnected)
+			return
+		}
+		switch frame.Type {
+		case "text":
+			call.deliver(frame.Text)
+		case "offer", "candidate":
+			call.queueSignal(frame)
+		case "hangup":
+			reason := frame.Reason
+			if reason == "" {
+				reason = HangupNormal
+			}
+			call.end(reason)
+			return
+		}
+	}
+}
+
+// awaitCallSocket ends a direct call whose human never connects
+func (c *Client) awaitCallSocket(call *Call) {
+	timer := time.NewTimer(callSocketWait)
+	defer timer.Stop()
+	select {
+	case <-call.ctx.Done():
+		return
+	case <-timer.C:
+	}
+	call.mu.Lock()
+	connected := call.conn != nil
+	call.mu.Unlock()
+	if !connected {
+		call.end(HangupTimeout)
+	}
+}
@@ -0,0 +1,208 @@
+package botcall
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// expectFrame reads the next frame and checks its type
+func expectFrame(t *testing.T, conn *websocket.Conn, typ string) relayFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var frame relayFrame
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("Reading %s frame: %v", typ, err)
+	}
+	if frame.Type != typ {
+		t.Fatalf("Got frame %+v, want type %q", frame, typ)
+	}
+	return frame
+}
+
+func nextText(t *testing.T, call *Call) string {
+	t.Helper()
+	select {
+	case text, ok := <-call.Receive():
+		if !ok {
+			t.Fatal("Receive closed early")
+		}
+		return text
+	case <-time.After(2 * time.Second):
+		t.Fatal("No message received")
+		return ""
+	}
+}
+
+func TestDirectCallSession(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	calls := make(chan *Call, 1)
+	c.OnCall(func(call *Call) {
+		// Said before the human connects, so it waits in the queue
+		call.SendText("hello")
+		calls <- call
+	})
+	serve(t, context.Background(), d, c)
+	defer c.Close()
+
+	base := "http://" + d.endpoint("orion") + "/call"
+	resp, err := http.Post(base, "application/json", strings.NewReader(`{"human_id":"ann"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var placed struct {
+		CallID string `json:"call_id"`
+		Socket bool   `json:"socket"`
+	}
+	json.NewDecoder(resp.Body).Decode(&placed)
+	resp.Body.Close()
+	if !placed.Socket || placed.CallID == "" {
+		t.Fatalf("/call answered %+v, want a call socket", placed)
+	}
+	call := <-calls
+	ended := make(chan string, 1)
+	call.OnEnd(func(reason string) { ended <- reason })
+
+	human, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(base, "http")+"/ws?call_id="+placed.CallID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer human.Close()
+	if frame := expectFrame(t, human, "text"); frame.Text != "hello" || frame.CallID != placed.CallID {
+		t.Errorf("Queued greeting arrived as %+v", frame)
+	}
+
+	human.WriteJSON(relayFrame{Type: "text", CallID: placed.CallID, Text: "hi"})
+	if got := nextText(t, call); got != "hi" {
+		t.Errorf("Received %q, want hi", got)
+	}
+
+	if err := call.Hangup("goodbye"); err != nil {
+		t.Fatal(err)
+	}
+	if frame := expectFrame(t, human, "hangup"); frame.Reason != "goodbye" {
+		t.Errorf("Human got hangup %+v, want reason goodbye", frame)
+	}
+	if reason := <-ended; reason != "goodbye" {
+		t.Errorf("OnEnd got %q", reason)
+	}
+	if call.Context().Err() == nil {
+		t.Error("Context still live after hangup")
+	}
+	if _, ok := <-call.Receive(); ok {
+		t.Error("Receive still open after hangup")
+	}
+	if err := call.SendText("anyone?"); err != ErrCallEnded {
+		t.Errorf("SendText after hangup: %v, want ErrCallEnded", err)
+	}
+}
+
+func TestDirectHangupBeforeSocket(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	ended := make(chan struct{})
+	c.OnCall(func(call *Call) {
+		call.SendText("sorry")
+		call.Hangup("busy")
+		close(ended)
+	})
+	serve(t, context.Background(), d, c)
+	defer c.Close()
+
+	base := "http://" + d.endpoint("orion") + "/call"
+	resp, err := http.Post(base, "application/json", strings.NewReader(`{"human_id":"ann"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var placed struct {
+		CallID string `json:"call_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&placed)
+	resp.Body.Close()
+	<-ended
+
+	// The human connects after the bot hung up and still hears why
+	socket := "ws" + strings.TrimPrefix(base, "http") + "/ws?call_id=" + placed.CallID
+	human, _, err := websocket.DefaultDialer.Dial(socket, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer human.Close()
+	if frame := expectFrame(t, human, "text"); frame.Text != "sorry" {
+		t.Errorf("Queued text arrived as %+v", frame)
+	}
+	if frame := expectFrame(t, human, "hangup"); frame.Reason != "busy" {
+		t.Errorf("Hangup arrived as %+v", frame)
+	}
+	if _, _, err := human.ReadMessage(); err == nil {
+		t.Error("Socket left open after the hangup")
+	}
+
+	// Only once
+	if _, resp, err := websocket.DefaultDialer.Dial(socket, nil); err == nil || resp == nil || resp.StatusCode != http.StatusConflict {
+		t.Errorf("Second socket for an ended call: %v", err)
+	}
+}
+
+func TestCallSocketRejectsUnknownCall(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	serve(t, context.Background(), d, c)
+	defer c.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws://"+d.endpoint("orion")+"/call/ws?call_id=call-guess", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Dial for unknown call: %v, want 404", err)
+	}
+}
+
+func TestRelayedCallSession(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	calls := make(chan *Call, 1)
+	c.OnCall(func(call *Call) { calls <- call })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.ServeRelay(ctx)
+
+	var hub *websocket.Conn
+	select {
+	case hub = <-d.relays:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Bot never opened its relay socket")
+	}
+	defer hub.Close()
+
+	hub.WriteJSON(relayFrame{Type: "call", CallID: "call-1", HumanID: "ann"})
+	expectFrame(t, hub, "accept")
+	call := <-calls
+	ended := make(chan string, 1)
+	call.OnEnd(func(reason string) { ended <- reason })
+
+	hub.WriteJSON(relayFrame{Type: "text", CallID: "call-1", Text: "hi"})
+	if got := nextText(t, call); got != "hi" {
+		t.Errorf("Received %q, want hi", got)
+	}
+	call.SendText("hello ann")
+	if frame := expectFrame(t, hub, "text"); frame.Text != "hello ann" || frame.CallID != "call-1" {
+		t.Errorf("Bot's text arrived as %+v", frame)
+	}
+
+	// The human hangs up through discovery
+	hub.WriteJSON(relayFrame{Type: "hangup", CallID: "call-1", Reason: HangupDisconnected})
+	select {
+	case reason := <-ended:
+		if reason != HangupDisconnected {
+			t.Errorf("OnEnd got %q", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Call did not end on the human's hangup")
+	}
+	<-call.Context().Done()
+}
@@ -5,53 +5,123 @@ package botcall
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Client handles bot registration and call acceptance
 type Client struct {
-	AgentID         string
-	DiscoveryURL    string
+	AgentID          string
+	DiscoveryURL     string
 	AttestationToken string
-	Endpoint        string
-	
-	// Internal state
-	httpClient      *http.Client
-	wsConn          *websocket.Conn
-	registered      bool
-	onCallHandler   func(*Call)
-	onAudioHandler  func([]byte) []byte
-	mu              sync.RWMutex
-}
+	Endpoint         string
+	// RegistrationKey proves ownership of AgentID to discovery. Set a
+	// stable secret to keep the ID across restarts; if empty, the key the
+	// server issues on first registration is kept for this process.
+	RegistrationKey string
+	// Mode is ModeDirect (the default) or ModeRelay; ServeRelay sets it
+	Mode string
+	// Profile is shown to humans on the bot's card; sent on every registration
+	Profile Profile
+
+	// InstanceID lets several replicas share AgentID, each with its own
+	// Endpoint; lookups spread humans across them. Replicas without one
+	// replace each other.
+	InstanceID string
+	// Weight is this instance's share of calls under BalanceRoundRobin
+	Weight int
+	// Balance picks how lookups choose among instances: BalanceLeastCalls
+	// (the server default), BalanceRoundRobin or BalanceSticky
+	Balance string
+	// ActiveCalls, if set, reports the calls this instance is on with each
+	// keepalive, so least-calls balancing and capacity see direct calls
+	ActiveCalls func() int
+	// Logger receives the client's logs; nil means slog.Default()
+	Logger *slog.Logger
+	// TracerProvider records the client's OpenTelemetry spans; nil means
+	// the global provider
+	TracerProvider trace.TracerProvider
+	// WebRTCAPI builds the peer connections of voice calls, for custom
+	// network or codec settings; nil uses pion's defaults
+	WebRTCAPI *webrtc.API
 
-// Call represents an incoming call from a human
-type Call struct {
-	CallID     string
-	HumanID    string
-	StartedAt  time.Time
-	client     *Client
+	// Internal state
+	httpClient     *http.Client
+	wsConn         *websocket.Conn
+	wsMu           sync.Mutex // serializes writes to wsConn
+	registered     bool
+	onCallHandler  func(*Call)
+	onTextHandler  func(*Call, string)
+	onAudioHandler func(*Call, AudioFrame) []AudioFrame
+	onRegistration func(RegistrationEvent)
+	calls          map[string]*Call // live calls by ID
+	iceServers     []ICEServer
+	iceExpires     time.Time
+	mux            *http.ServeMux
+	muxOnce        sync.Once
+	server         *http.Server   // set by Serve
+	draining       bool           // set by Shutdown; new calls are refused
+	inflight       sync.WaitGroup // OnCall handlers still running
+	mu             sync.RWMutex
+	closed         chan struct{}
+	closeOnce      sync.Once
 }
 
 // RegisterRequest sent to discovery server
 type RegisterRequest struct {
-	AgentID     string `json:"agent_id"`
-	Endpoint    string `json:"endpoint"`
-	Mode        string `json:"mode"`
-	Attestation string `json:"attestation"`
+	AgentID         string `json:"agent_id"`
+	Endpoint        string `json:"endpoint"`
+	Mode            string `json:"mode"`
+	Attestation     string `json:"attestation"`
+	RegistrationKey string `json:"registration_key,omitempty"`
+	InstanceID      string `json:"instance_id,omitempty"`
+	Weight          int    `json:"weight,omitempty"`
+	ActiveCalls     int    `json:"active_calls,omitempty"`
+	Balance         string `json:"balance,omitempty"`
+	Profile
+}
+
+// Load-balancing policies for agents running several instances
+const (
+	BalanceLeastCalls = "least_calls"
+	BalanceRoundRobin = "round_robin"
+	BalanceSticky     = "sticky" // the same human reaches the same instance
+)
+
+// Profile describes the bot to the humans who call it. All fields are
+// optional.
+type Profile struct {
+	DisplayName string `json:"display_name,omitempty"`
+	Description string `json:"description,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	// Modes are the call modes accepted: "voice", "text" and/or "video"
+	Modes              []string `json:"modes,omitempty"`
+	Languages          []string `json:"languages,omitempty"` // BCP 47, e.g. en-US
+	Codecs             []string `json:"codecs,omitempty"`
+	MaxConcurrentCalls int      `json:"max_concurrent_calls,omitempty"`
+	Tags               []string `json:"tags,omitempty"`
 }
 
 // RegisterResponse from discovery server
 type RegisterResponse struct {
-	Confirmed bool   `json:"confirmed"`
-	URL       string `json:"url,omitempty"`
-	Status    string `json:"status"`
+	Confirmed       bool   `json:"confirmed"`
+	URL             string `json:"url,omitempty"`
+	Status          string `json:"status"`
+	RegistrationKey string `json:"registration_key,omitempty"`
 }
 
 // NewClient creates a BotCall client
@@ -61,6 +131,7 @@ func NewClient(agentID, attestationToken string) *Client {
 		DiscoveryURL:     "http://localhost:8080", // Default
 		AttestationToken: attestationToken,
 		httpClient:       &http.Client{Timeout: 10 * time.Second},
+		closed:           make(chan struct{}),
 	}
 }
 
@@ -72,18 +143,51 @@ func (c *Client) SetDiscoveryURL(url string) *Client {
 
 // Connect registers with discovery and starts listening
 func (c *Client) Connect() error {
+	ctx, span := c.tracer().Start(context.Background(), "botcall.Connect",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("botcall.agent_id", c.AgentID)))
+	err := c.register(ctx)
+	endSpan(span, err)
+	return err
+}
+
+func (c *Client) register(ctx context.Context) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	// Shutdown cuts off a registration in flight, so a keepalive cannot
+	// land after it has deregistered
+	ctx, cancel := c.closing(ctx)
+	defer cancel()
+
 	// Determine our endpoint (public IP:port)
 	// For now, use 0.0.0.0:9000 and let user configure
-	if c.Endpoint == "" {
+	c.mu.Lock()
+	mode := c.Mode
+	if mode == "" {
+		mode = ModeDirect
+	}
+	if c.Endpoint == "" && mode == ModeDirect {
 		c.Endpoint = "0.0.0.0:9000"
 	}
+	c.mu.Unlock()
 
 	// Register with discovery server
+	c.mu.RLock()
 	req := RegisterRequest{
-		AgentID:     c.AgentID,
-		Endpoint:    c.Endpoint,
-		Mode:        "direct",
-		Attestation: c.AttestationToken,
+		AgentID:         c.AgentID,
+		Endpoint:        c.Endpoint,
+		Mode:            mode,
+		Attestation:     c.AttestationToken,
+		RegistrationKey: c.RegistrationKey,
+		InstanceID:      c.InstanceID,
+		Weight:          c.Weight,
+		Balance:         c.Balance,
+		Profile:         c.Profile,
+	}
+	c.mu.RUnlock()
+	if c.ActiveCalls != nil {
+		req.ActiveCalls = c.ActiveCalls()
 	}
 
 	body, err := json.Marshal(req)
@@ -91,18 +195,26 @@ func (c *Client) Connect() error {
 		return fmt.Errorf("marshal register request: %w", err)
 	}
 
-	resp, err := c.httpClient.Post(
-		c.DiscoveryURL+"/v1/register",
-		"application/json",
-		bytes.NewBuffer(body),
-	)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.DiscoveryURL+"/v1/register", bytes.NewBuffer(body))
 	if err != nil {
+		return fmt.Errorf("build register request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	tagRequest(httpReq)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		if c.isClosed() {
+			// Discovery may have taken it before the cut
+			c.withdraw()
+			return ErrClosed
+		}
 		return fmt.Errorf("register with discovery: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("discovery returned %d", resp.StatusCode)
+		return discoveryError(resp)
 	}
 
 	var result RegisterResponse
@@ -111,14 +223,128 @@ func (c *Client) Connect() error {
 	}
 
 	if !result.Confirmed {
-		return fmt.Errorf("registration rejected")
+		return ErrRejected
 	}
 
+	// Checked under the lock Shutdown reads registered with: either it
+	// sees this registration and deregisters, or it is undone here
 	c.mu.Lock()
+	if c.isClosed() {
+		if result.RegistrationKey != "" {
+			c.RegistrationKey = result.RegistrationKey
+		}
+		c.mu.Unlock()
+		c.withdraw()
+		return ErrClosed
+	}
 	c.registered = true
+	if result.RegistrationKey != "" {
+		c.RegistrationKey = result.RegistrationKey
+		c.logger().Info("discovery issued a registration key; set Client.RegistrationKey to keep the ID across restarts", "agent_id", c.AgentID)
+	}
 	c.mu.Unlock()
 
-	log.Printf("[BotCall] Registered as %s at %s", c.AgentID, c.Endpoint)
+	c.logger().Info("registered", "agent_id", c.AgentID, "mode", mode, "endpoint", req.Endpoint)
+	return nil
+}
+
+// isClosed reports whether Shutdown or Close has been called
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// closing derives a context from ctx that is also cancelled when the
+// client is closed
+func (c *Client) closing(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// withdraw deregisters a registration that raced Shutdown or Close
+func (c *Client) withdraw() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Deregister(ctx); err != nil {
+		c.logger().Warn("deregister after close failed", "agent_id", c.AgentID, "err", err)
+	}
+}
+
+// DiscoveryError is a non-200 answer from discovery, with the structured
+// error code when the server sent one
+type DiscoveryError struct {
+	StatusCode int
+	Code       string // e.g. "not_owner"; empty for unstructured errors
+	Message    string
+}
+
+func (e *DiscoveryError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("discovery returned %d: %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("discovery returned %d", e.StatusCode)
+}
+
+// discoveryError turns a non-200 discovery response into a *DiscoveryError
+func discoveryError(resp *http.Response) error {
+	var apiErr struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&apiErr)
+	return &DiscoveryError{StatusCode: resp.StatusCode, Code: apiErr.Error, Message: apiErr.Message}
+}
+
+// Deregister tells discovery the bot is going away, so humans see it
+// offline immediately instead of dialing a dead endpoint. With InstanceID
+// set only this instance leaves; the agent stays online on the others.
+func (c *Client) Deregister(ctx context.Context) error {
+	target := c.DiscoveryURL + "/v1/register/" + url.PathEscape(c.AgentID)
+	if c.InstanceID != "" {
+		target += "?instance=" + url.QueryEscape(c.InstanceID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, target, nil)
+	if err != nil {
+		return fmt.Errorf("build deregister request: %w", err)
+	}
+	tagRequest(req)
+
+	c.mu.RLock()
+	if c.RegistrationKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.RegistrationKey)
+	}
+	if c.AttestationToken != "" {
+		req.Header.Set("X-BotCall-Attestation", c.AttestationToken)
+	}
+	c.mu.RUnlock()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("deregister with discovery: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Already gone is as good as deregistered
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return discoveryError(resp)
+	}
+
+	c.mu.Lock()
+	c.registered = false
+	c.mu.Unlock()
+
+	c.logger().Info("deregistered", "agent_id", c.AgentID)
 	return nil
 }
 
@@ -127,33 +353,40 @@ func (c *Client) OnCall(handler func(*Call)) {
 	c.onCallHandler = handler
 }
 
-// HandleIncoming starts HTTP server for incoming calls
+// HandleIncoming listens on addr and serves calls until Shutdown.
+//
+// The handler argument is ignored and kept only so existing callers
+// compile; pass nil. To serve other routes next to calls, mount Handler
+// in your own router and call Serve.
 func (c *Client) HandleIncoming(addr string, handler func(http.ResponseWriter, *http.Request)) error {
+	c.mu.Lock()
 	if addr != "" {
 		c.Endpoint = addr
 	}
-
-	http.HandleFunc("/call", c.handleCall)
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		json.NewEncoder(w).Encode(map[string]string{
-			"status":  "ok",
-			"agent":   c.AgentID,
-			"version": "0.1.0",
-		})
-	})
-
-	// Re-register with actual endpoint
-	if err := c.Connect(); err != nil {
-		return err
+	addr = c.Endpoint
+	c.mu.Unlock()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
 	}
-
-	log.Printf("[BotCall] Listening for calls on %s", c.Endpoint)
-	return http.ListenAndServe(c.Endpoint, nil)
+	return c.Serve(context.Background(), ln)
 }
 
 // handleCall processes incoming call requests
 func (c *Client) handleCall(w http.ResponseWriter, r *http.Request) {
+	id := requestID(r)
+	w.Header().Set(RequestIDHeader, id)
+	logger := c.logger().With("request_id", id)
+
+	// Continue the caller's trace: the PWA's, or discovery's for relayed calls
+	ctx := propagator.Extract(withRequestID(r.Context(), id), propagation.HeaderCarrier(r.Header))
+	ctx, span := c.tracer().Start(ctx, "botcall.handleCall",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("botcall.request_id", id)))
+	defer span.End()
+
 	if r.Method != http.MethodPost {
+		span.SetStatus(codes.Error, "method not allowed")
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -163,57 +396,92 @@ func (c *Client) handleCall(w http.ResponseWriter, r *http.Request) {
 		HumanID     string `json:"human_id"`
 		Attestation string `json:"attestation"`
 	}
-	
+
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		endSpan(span, err)
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-
-	call := &Call{
-		CallID:    fmt.Sprintf("call-%d", time.Now().Unix()),
-		HumanID:   req.HumanID,
-		StartedAt: time.Now(),
-		client:    c,
+	if !c.beginCall() {
+		span.SetStatus(codes.Error, "shutting down")
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
 	}
 
-	log.Printf("[BotCall] Incoming call from %s", req.HumanID)
+	call := newCall(c, newCallID(), req.HumanID, false, WebRTCAudio)
+	call.RequestID = id
+	c.trackCall(call)
+	go c.awaitCallSocket(call)
+	go call.playAudio()
+	go c.pumpAudio(call)
+
+	logger.Info("incoming call", "call_id", call.CallID, "human_id", req.HumanID)
+	span.SetAttributes(
+		attribute.String("botcall.call_id", call.CallID),
+		attribute.String("botcall.human_id", call.HumanID),
+	)
+
+	resp := map[string]interface{}{
+		"status":  "accepted",
+		"call_id": call.CallID,
+		"webrtc":  true, // Signal to use WebRTC
+		"socket":  true, // chat and hangups go over /call/ws?call_id=
+	}
+	// Hand the caller the same STUN/TURN servers the bot will use
+	if servers, err := c.ICEServers(ctx); err == nil {
+		resp["ice_servers"] = servers
+	} else {
+		logger.Warn("no ICE servers for call", "err", err)
+	}
 
 	// Respond immediately, handle call asynchronously
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "accepted",
-		"call_id":   call.CallID,
-		"webrtc":    true, // Signal to use WebRTC
-	})
+	json.NewEncoder(w).Encode(resp)
 
 	// Trigger handler in goroutine
-	if c.onCallHandler != nil {
-		go c.onCallHandler(call)
-	}
+	go c.dispatchCall(ctx, call)
 }
 
-// StartKeepalive pings discovery server periodically
+// StartKeepalive runs KeepRegistered in the background until Close
 func (c *Client) StartKeepalive(interval time.Duration) {
-	ticker := time.NewTicker(interval)
 	go func() {
-		for range ticker.C {
-			if err := c.Connect(); err != nil {
-				log.Printf("[BotCall] Keepalive failed: %v", err)
-			}
+		if err := c.KeepRegistered(context.Background(), interval); err != nil {
+			c.logger().Error("keepalive stopped", "agent_id", c.AgentID, "err", err)
 		}
 	}()
 }
 
-// Close cleans up resources
+// Close stops the keepalive, deregisters from discovery so the bot shows
+// offline right away, and cleans up resources. Unlike Shutdown it does not
+// wait for calls to finish.
 func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	c.mu.Lock()
+	c.draining = true
+	srv := c.server
+	c.mu.Unlock()
+	if srv != nil {
+		srv.Close()
+	}
+
+	var err error
+	if c.IsRegistered() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = c.Deregister(ctx)
+		cancel()
+	}
+	c.hangupAll(HangupShutdown)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	
+
 	c.registered = false
 	if c.wsConn != nil {
-		return c.wsConn.Close()
+		if cerr := c.wsConn.Close(); err == nil {
+			err = cerr
+		}
 	}
-	return nil
+	return err
 }
 
 // IsRegistered returns registration status
@@ -225,5 +493,7 @@ func (c *Client) IsRegistered() bool {
 
 // GetPublicEndpoint returns the current endpoint
 func (c *Client) GetPublicEndpoint() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.Endpoint
 }
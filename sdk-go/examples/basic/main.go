@@ -4,8 +4,13 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/TheOrionAI/botcall-sdk-go"
@@ -44,8 +49,16 @@ func main() {
 	// Optionally start keepalive to stay registered
 	go bot.StartKeepalive(4 * time.Minute)
 
-	// This blocks forever, handling HTTP requests
-	if err := bot.HandleIncoming(":9000", nil); err != nil {
+	// On Ctrl+C, go offline in discovery right away and let calls finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", ":9000")
+	if err != nil {
+		log.Fatalf("Listen: %v", err)
+	}
+	// This blocks until Ctrl+C, handling HTTP requests
+	if err := bot.Serve(ctx, ln); err != nil && !errors.Is(err, context.Canceled) {
 		log.Fatalf("Server error: %v", err)
 	}
 }
@@ -9,7 +9,7 @@ import (
 	"os"
 	"strings"
 
-	botcall "github.com/theorionai/botcall/sdk-go"
+	botcall "github.com/TheOrionAI/botcall-sdk-go"
 )
 
 func main() {
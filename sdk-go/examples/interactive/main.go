@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/TheOrionAI/botcall-sdk-go"
@@ -36,6 +37,7 @@ func main() {
 	bot.Endpoint = listenAddr
 
 	// Call Tracking
+	var mu sync.Mutex
 	activeCalls := make(map[string]*botcall.Call)
 
 	// Handle incoming calls
@@ -45,16 +47,25 @@ func main() {
 		log.Printf("   Started: %s", call.StartedAt.Format("15:04:05"))
 		log.Printf("\n💬 Type your response or press Enter to hangup:")
 		
+		mu.Lock()
 		activeCalls[call.CallID] = call
+		mu.Unlock()
+		call.OnEnd(func(reason string) {
+			log.Printf("📴 Call %s ended (%s)", call.CallID, reason)
+			mu.Lock()
+			delete(activeCalls, call.CallID)
+			mu.Unlock()
+		})
 
-		// In a real implementation:
+		call.SendText("Hi, this is " + agentID + ". How can I help?")
+		for text := range call.Receive() {
+			log.Printf("👤 %s: %s", call.HumanID, text)
+		}
+
+		// Still to come:
 		// - Accept WebRTC offer
 		// - Set up Opus stream
-		// - Play TTS greeting
-		// - Receive audio from human
-		// - Stream to STT
-		// - Generate AI response
-		// - Stream TTS response
+		// - Stream to STT and TTS
 	})
 
 	// Start keepalive in background
@@ -76,6 +87,9 @@ func main() {
 			switch line {
 			case "quit", "exit", "q":
 				log.Println("👋 Shutting down...")
+				if err := bot.Close(); err != nil {
+					log.Printf("⚠️  Deregister failed: %v", err)
+				}
 				os.Exit(0)
 				
 			case "status":
@@ -86,20 +100,24 @@ func main() {
 				}
 				
 			case "":
-				// Empty line - could hangup active call
-				if len(activeCalls) > 0 {
-					log.Println("📴 Hanging up active calls...")
-					activeCalls = make(map[string]*botcall.Call)
+				// Empty line - hang up active calls
+				for _, call := range snapshot(&mu, activeCalls) {
+					log.Printf("📴 Hanging up %s...", call.CallID)
+					call.Hangup("goodbye")
 				}
 				
 			default:
 				// Send as response
-				if len(activeCalls) > 0 {
-					log.Printf("🗣️  Response: %s", line)
-					// TODO: Actually send audio via WebRTC
-				} else {
+				calls := snapshot(&mu, activeCalls)
+				if len(calls) == 0 {
 					log.Println("⚠️  No active calls to respond to")
 				}
+				for _, call := range calls {
+					log.Printf("🗣️  Response: %s", line)
+					if err := call.SendText(line); err != nil {
+						log.Printf("⚠️  Send to %s failed: %v", call.CallID, err)
+					}
+				}
 			}
 		}
 	}()
@@ -112,6 +130,17 @@ func main() {
 	}
 }
 
+// snapshot copies the active calls so they can be used without the lock
+func snapshot(mu *sync.Mutex, calls map[string]*botcall.Call) []*botcall.Call {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make([]*botcall.Call, 0, len(calls))
+	for _, call := range calls {
+		out = append(out, call)
+	}
+	return out
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,79 @@
+package botcall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ICEServer is one STUN or TURN server, in the shape RTCPeerConnection
+// and pion's webrtc.ICEServer expect
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+const (
+	// iceRefreshMargin renews TURN credentials this long before they expire
+	iceRefreshMargin = time.Minute
+	// iceCacheSTUN is how long a STUN-only list is reused
+	iceCacheSTUN = 10 * time.Minute
+)
+
+// ICEServers returns the STUN servers and short-lived TURN credentials
+// discovery hands out, fetching new ones shortly before they expire. TURN
+// is only issued to the agent's owner, so the client proves ownership as
+// it does when registering.
+func (c *Client) ICEServers(ctx context.Context) ([]ICEServer, error) {
+	c.mu.RLock()
+	servers, expires := c.iceServers, c.iceExpires
+	c.mu.RUnlock()
+	if servers != nil && time.Now().Before(expires.Add(-iceRefreshMargin)) {
+		return servers, nil
+	}
+
+	id := url.QueryEscape(c.AgentID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.DiscoveryURL+"/v1/ice-servers?user="+id+"&agent="+id, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build ice-servers request: %w", err)
+	}
+	c.mu.RLock()
+	if c.RegistrationKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.RegistrationKey)
+	}
+	if c.AttestationToken != "" {
+		req.Header.Set("X-BotCall-Attestation", c.AttestationToken)
+	}
+	c.mu.RUnlock()
+	tagRequest(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch ice servers: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, discoveryError(resp)
+	}
+
+	var result struct {
+		ICEServers []ICEServer `json:"ice_servers"`
+		Expires    *time.Time  `json:"expires"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode ice servers: %w", err)
+	}
+
+	expires = time.Now().Add(iceCacheSTUN)
+	if result.Expires != nil {
+		expires = *result.Expires
+	}
+	c.mu.Lock()
+	c.iceServers, c.iceExpires = result.ICEServers, expires
+	c.mu.Unlock()
+	return result.ICEServers, nil
+}
@@ -0,0 +1,62 @@
+package botcall
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestICEServersCache(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	c.RegistrationKey = "s3cret"
+	ctx := context.Background()
+	fetches := func() int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.iceFetches
+	}
+
+	// STUN-only lists are reused for a while
+	for i := 0; i < 2; i++ {
+		servers, err := c.ICEServers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(servers) != 1 || servers[0].URLs[0] != "stun:stun.example.com:3478" {
+			t.Fatalf("ICEServers = %+v", servers)
+		}
+	}
+	if n := fetches(); n != 1 {
+		t.Errorf("%d fetches for two calls, want 1", n)
+	}
+	d.mu.Lock()
+	req := d.iceRequest
+	d.mu.Unlock()
+	if req.URL.Query().Get("agent") != "orion" || req.Header.Get("Authorization") != "Bearer s3cret" {
+		t.Errorf("ice-servers requested as %s with %v; TURN needs the owner's key", req.URL, req.Header)
+	}
+
+	// Credentials close to expiry are renewed before use
+	d.mu.Lock()
+	d.iceExpires = time.Now().Add(iceRefreshMargin / 2)
+	d.mu.Unlock()
+	c.mu.Lock()
+	c.iceExpires = time.Now()
+	c.mu.Unlock()
+	c.ICEServers(ctx)
+	c.ICEServers(ctx)
+	if n := fetches(); n != 3 {
+		t.Errorf("%d fetches with credentials about to expire, want 3", n)
+	}
+
+	// Fresh credentials are kept until shortly before they expire
+	d.mu.Lock()
+	d.iceExpires = time.Now().Add(time.Hour)
+	d.mu.Unlock()
+	c.ICEServers(ctx)
+	c.ICEServers(ctx)
+	if n := fetches(); n != 4 {
+		t.Errorf("%d fetches with fresh credentials, want 4", n)
+	}
+}
@@ -0,0 +1,68 @@
+package botcall
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// RequestIDHeader ties one call's log lines together across the PWA,
+// discovery and the bot. The bot echoes the ID it was called with, passes
+// it on to the discovery requests made while serving the call, and tags
+// its other discovery requests with a fresh one.
+const RequestIDHeader = "X-Request-ID"
+
+// SetLogger sets the logger the client writes to
+func (c *Client) SetLogger(logger *slog.Logger) *Client {
+	c.Logger = logger
+	return c
+}
+
+// logger returns Client.Logger, or slog.Default() when it is unset
+func (c *Client) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
+// newRequestID returns a random 128-bit ID in hex
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestID returns the ID r was sent with, or a new one when the caller
+// did not send a usable ID
+func requestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > 128 {
+		return newRequestID()
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return newRequestID()
+		}
+	}
+	return id
+}
+
+type requestIDKey struct{}
+
+// withRequestID marks ctx as serving the request with the given ID
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// setRequestID tags an outgoing request with the ID of the request its
+// context is serving, or a fresh one
+func setRequestID(req *http.Request) {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	if id == "" {
+		id = newRequestID()
+	}
+	req.Header.Set(RequestIDHeader, id)
+}
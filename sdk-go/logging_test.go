@@ -0,0 +1,77 @@
+package botcall
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a bytes.Buffer safe for a logger and a test to share
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestIDAndLogger(t *testing.T) {
+	d := newFakeDiscovery(t)
+	var logs syncBuffer
+	c := newTestClient(d, "orion").SetLogger(slog.New(slog.NewJSONHandler(&logs, nil)))
+	calls := make(chan *Call, 1)
+	c.OnCall(func(call *Call) { calls <- call })
+	serve(t, context.Background(), d, c)
+	defer c.Close()
+
+	call := func(id string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, "http://"+d.endpoint("orion")+"/call", strings.NewReader(`{"human_id":"ann"}`))
+		req.Header.Set(RequestIDHeader, id)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// The caller's ID is echoed, kept on the call, passed on to discovery
+	// and logged through the injected logger
+	resp := call("req-42")
+	if got := resp.Header.Get(RequestIDHeader); got != "req-42" {
+		t.Errorf("Echoed %s %q", RequestIDHeader, got)
+	}
+	if got := (<-calls).RequestID; got != "req-42" {
+		t.Errorf("Call.RequestID = %q", got)
+	}
+	d.mu.Lock()
+	ice := d.iceRequest
+	d.mu.Unlock()
+	if ice == nil || ice.Header.Get(RequestIDHeader) != "req-42" {
+		t.Error("ICE servers fetched for the call without its request ID")
+	}
+	if out := logs.String(); !strings.Contains(out, `"msg":"incoming call"`) || !strings.Contains(out, `"request_id":"req-42"`) {
+		t.Errorf("Logger got %s", out)
+	}
+
+	// IDs too long to log are replaced
+	resp = call(strings.Repeat("x", 200))
+	if got := resp.Header.Get(RequestIDHeader); len(got) != 32 || strings.Contains(got, "x") {
+		t.Errorf("Oversized ID answered with %q", got)
+	}
+	<-calls
+}
@@ -0,0 +1,69 @@
+package botcall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Presence states a bot may set. Discovery shows in_call on its own while
+// the bot's calls fill Profile.MaxConcurrentCalls, and offline once it
+// stops registering.
+const (
+	PresenceAvailable = "available"
+	PresenceBusy      = "busy"
+	PresenceDND       = "dnd"
+)
+
+// presenceRequest is the body of PUT /v1/presence/{id}
+type presenceRequest struct {
+	State     string `json:"state"`
+	Message   string `json:"message,omitempty"`
+	ExpiresIn int64  `json:"expires_in,omitempty"`
+}
+
+// SetPresence shows humans state and an optional status message, e.g.
+// SetPresence(ctx, PresenceBusy, "In a meeting", 30*time.Minute). Busy and
+// dnd bots are not rung; lookups tell callers to try later. A ttl above
+// zero reverts to available when it lapses; zero keeps the state until
+// the next SetPresence. Keepalives do not reset it.
+func (c *Client) SetPresence(ctx context.Context, state, message string, ttl time.Duration) error {
+	body, err := json.Marshal(presenceRequest{
+		State:     state,
+		Message:   message,
+		ExpiresIn: int64((ttl + time.Second - 1) / time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal presence request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		c.DiscoveryURL+"/v1/presence/"+url.PathEscape(c.AgentID), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build presence request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tagRequest(req)
+
+	c.mu.RLock()
+	if c.RegistrationKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.RegistrationKey)
+	}
+	if c.AttestationToken != "" {
+		req.Header.Set("X-BotCall-Attestation", c.AttestationToken)
+	}
+	c.mu.RUnlock()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("set presence: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return discoveryError(resp)
+	}
+	return nil
+}
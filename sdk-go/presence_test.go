@@ -0,0 +1,39 @@
+package botcall
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetPresence(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	c.RegistrationKey = "s3cret"
+	ctx := context.Background()
+
+	// The TTL rounds up to whole seconds
+	if err := c.SetPresence(ctx, PresenceBusy, "In a meeting", 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	got, auth := d.presence["orion"], d.presenceAuth
+	d.mu.Unlock()
+	if got != (presenceRequest{State: PresenceBusy, Message: "In a meeting", ExpiresIn: 2}) {
+		t.Errorf("PUT body = %+v", got)
+	}
+	if auth != "Bearer s3cret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	// No TTL leaves expires_in out
+	if err := c.SetPresence(ctx, PresenceAvailable, "", 0); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	got = d.presence["orion"]
+	d.mu.Unlock()
+	if got != (presenceRequest{State: PresenceAvailable}) {
+		t.Errorf("PUT body = %+v", got)
+	}
+}
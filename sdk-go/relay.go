@@ -0,0 +1,231 @@
+package botcall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TheOrionAI/botcall-sdk-go/relayproto"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Registration modes
+const (
+	ModeDirect = "direct" // humans reach the bot's endpoint directly
+	ModeRelay  = "relay"  // discovery proxies calls over an outbound socket
+)
+
+const (
+	relayMinBackoff = time.Second
+	relayMaxBackoff = 30 * time.Second
+)
+
+// ErrNoRelay is returned when sending on a call that has no relay socket
+var ErrNoRelay = errors.New("botcall: call has no relay connection")
+
+// relayFrame is any frame on the relay socket, in its shared wire format
+type relayFrame = relayproto.Frame
+
+// OnText sets the handler for chat messages humans send on relayed calls
+func (c *Client) OnText(handler func(call *Call, text string)) {
+	c.onTextHandler = handler
+}
+
+// ServeRelay registers in relay mode and keeps an outbound WebSocket to
+// discovery open, so humans can call a bot with no inbound port. Discovery
+// forwards /call requests and chat over the socket. ServeRelay reconnects
+// with jittered backoff until ctx is cancelled or the client is closed.
+func (c *Client) ServeRelay(ctx context.Context) error {
+	c.mu.Lock()
+	c.Mode = ModeRelay
+	c.mu.Unlock()
+
+	failures := 0
+	for {
+		err := c.Connect()
+		if err == nil {
+			var conn *websocket.Conn
+			conn, err = c.dialRelay(ctx)
+			if err == nil {
+				c.logger().Info("relay connected", "agent_id", c.AgentID)
+				failures = 0
+				err = c.readRelay(ctx, conn)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closed:
+			return nil
+		default:
+		}
+
+		failures++
+		backoff := backoffDelay(failures, relayMinBackoff, relayMaxBackoff)
+		c.logger().Warn("relay dropped; retrying", "agent_id", c.AgentID, "err", err, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closed:
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// relayURL is the bot socket on the discovery server
+func (c *Client) relayURL() string {
+	base := strings.TrimSuffix(c.DiscoveryURL, "/")
+	switch {
+	case strings.HasPrefix(base, "https://"):
+		base = "wss://" + strings.TrimPrefix(base, "https://")
+	case strings.HasPrefix(base, "http://"):
+		base = "ws://" + strings.TrimPrefix(base, "http://")
+	}
+	return base + "/v1/ws?role=bot&agent=" + url.QueryEscape(c.AgentID)
+}
+
+func (c *Client) dialRelay(ctx context.Context) (_ *websocket.Conn, err error) {
+	ctx, span := c.tracer().Start(ctx, "botcall.relay.dial", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
+
+	header := http.Header{}
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+	c.mu.RLock()
+	if c.RegistrationKey != "" {
+		header.Set("Authorization", "Bearer "+c.RegistrationKey)
+	}
+	if c.AttestationToken != "" {
+		header.Set("X-BotCall-Attestation", c.AttestationToken)
+	}
+	c.mu.RUnlock()
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, c.relayURL(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			defer resp.Body.Close()
+			return nil, discoveryError(resp)
+		}
+		return nil, fmt.Errorf("dial relay: %w", err)
+	}
+
+	c.mu.Lock()
+	c.wsConn = conn
+	c.mu.Unlock()
+	return conn, nil
+}
+
+// readRelay handles frames until the socket closes
+func (c *Client) readRelay(ctx context.Context, conn *websocket.Conn) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer func() {
+		conn.Close()
+		c.mu.Lock()
+		if c.wsConn == conn {
+			c.wsConn = nil
+		}
+		c.mu.Unlock()
+		for _, call := range c.liveCalls() {
+			if call.relayed {
+				call.end(HangupDisconnected)
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var frame relayFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			c.logger().Warn("ignoring bad relay frame", "err", err)
+			continue
+		}
+
+		switch frame.Type {
+		case "request":
+			go c.serveRelayRequest(frame)
+		case "call":
+			c.acceptRelayCall(frame)
+		case "text":
+			if call := c.call(frame.CallID); call != nil && call.relayed {
+				call.deliver(frame.Text)
+			}
+		case "offer", "candidate":
+			if call := c.call(frame.CallID); call != nil && call.relayed {
+				call.queueSignal(frame)
+			}
+		case "hangup":
+			if call := c.call(frame.CallID); call != nil && call.relayed {
+				reason := frame.Reason
+				if reason == "" {
+					reason = HangupNormal
+				}
+				call.end(reason)
+			}
+		case "error":
+			c.logger().Error("relay error", "reason", frame.Reason)
+		}
+	}
+}
+
+// serveRelayRequest runs a forwarded HTTP request through the bot's own
+// handlers and sends the response back
+func (c *Client) serveRelayRequest(frame relayFrame) {
+	resp := relayproto.ServeRequest(c.Handler(), frame)
+	if err := c.sendRelay(resp); err != nil {
+		c.logger().Warn("relay response failed", "request_id", frame.Header.Get(RequestIDHeader), "err", err)
+	}
+}
+
+// acceptRelayCall answers a call rung through discovery
+func (c *Client) acceptRelayCall(frame relayFrame) {
+	if !c.beginCall() {
+		c.sendRelay(relayFrame{Type: "reject", CallID: frame.CallID, Reason: "shutting_down"})
+		return
+	}
+	call := newCall(c, frame.CallID, frame.HumanID, true, WebRTCAudio)
+	c.trackCall(call)
+	go call.playAudio()
+	go c.pumpAudio(call)
+
+	c.logger().Info("incoming relayed call", "call_id", call.CallID, "human_id", call.HumanID, "mode", frame.Mode)
+	// Humans calling by voice or video get voice: they offer WebRTC next
+	mode := "text"
+	if frame.Mode == "voice" || frame.Mode == "video" {
+		mode = "voice"
+	}
+	if err := c.sendRelay(relayFrame{Type: "accept", CallID: call.CallID, Mode: mode}); err != nil {
+		c.logger().Warn("accept failed", "call_id", call.CallID, "err", err)
+		call.end(HangupDisconnected)
+		c.inflight.Done()
+		return
+	}
+	// The handler's span joins the trace the human started
+	go c.dispatchCall(propagator.Extract(context.Background(), frameCarrier{&frame}), call)
+}
+
+// sendRelay writes one frame to the relay socket
+func (c *Client) sendRelay(v interface{}) error {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+
+	c.mu.RLock()
+	conn := c.wsConn
+	c.mu.RUnlock()
+	if conn == nil {
+		return ErrNoRelay
+	}
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return conn.WriteJSON(v)
+}
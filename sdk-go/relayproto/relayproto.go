@@ -0,0 +1,115 @@
+// Package relayproto holds the frames bots and discovery exchange over the
+// relay socket. The SDK and bot-cli both speak it from here, so the two
+// cannot drift apart.
+package relayproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// MaxBodySize is the largest body discovery relays in either direction.
+// A larger response would exceed the frames discovery reads from bots and
+// cost the bot its socket, so ServeRequest answers 502 in its place.
+const MaxBodySize = 1 << 20
+
+// ErrBodyTooLarge is returned by ResponseWriter.Write past MaxBodySize
+var ErrBodyTooLarge = errors.New("relayproto: response body exceeds 1 MiB")
+
+// Frame is any frame discovery sends on the relay socket, and the call
+// signaling a bot sends back. Requests carry HTTP exchanges; the rest is
+// call signaling, presence and tunnel setup.
+type Frame struct {
+	Type      string      `json:"type"`
+	CallID    string      `json:"call_id,omitempty"`
+	HumanID   string      `json:"human_id,omitempty"`
+	Mode      string      `json:"mode,omitempty"`
+	Text      string      `json:"text,omitempty"`
+	Reason    string      `json:"reason,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Method    string      `json:"method,omitempty"`
+	Path      string      `json:"path,omitempty"`
+	Header    http.Header `json:"header,omitempty"`
+	Body      []byte      `json:"body,omitempty"`
+	// URL is the public address of a tunnel, on "tunnel" frames
+	URL string `json:"url,omitempty"`
+	// WebRTC session description and ICE candidate, on "offer", "answer"
+	// and "candidate" frames
+	SDP       string          `json:"sdp,omitempty"`
+	Candidate json.RawMessage `json:"candidate,omitempty"`
+	// Trace context of the hub's setup span, on "call" frames
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+}
+
+// Response answers a relayed request
+type Response struct {
+	Type      string      `json:"type"` // always "response"
+	RequestID string      `json:"request_id"`
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header,omitempty"`
+	Body      []byte      `json:"body,omitempty"`
+}
+
+// ServeRequest runs a forwarded HTTP request through handler and returns
+// the response to send back
+func ServeRequest(handler http.Handler, frame Frame) Response {
+	resp := Response{Type: "response", RequestID: frame.RequestID}
+	req, err := http.NewRequest(frame.Method, frame.Path, bytes.NewReader(frame.Body))
+	if err != nil {
+		resp.Status = http.StatusBadRequest
+		return resp
+	}
+	req.Header = frame.Header
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+
+	rw := NewResponseWriter()
+	handler.ServeHTTP(rw, req)
+	if rw.tooLarge {
+		resp.Status = http.StatusBadGateway
+		resp.Header = http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+		resp.Body = []byte(ErrBodyTooLarge.Error() + "\n")
+		return resp
+	}
+	rw.WriteHeader(http.StatusOK)
+	resp.Status = rw.status
+	resp.Header = rw.header
+	resp.Body = rw.body.Bytes()
+	return resp
+}
+
+// ResponseWriter buffers a handler's response for the relay socket
+type ResponseWriter struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+// NewResponseWriter returns an empty ResponseWriter
+func NewResponseWriter() *ResponseWriter {
+	return &ResponseWriter{header: http.Header{}}
+}
+
+func (w *ResponseWriter) Header() http.Header { return w.header }
+
+// WriteHeader keeps the first status written
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write buffers p, failing once the body would pass MaxBodySize
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.tooLarge || w.body.Len()+len(p) > MaxBodySize {
+		w.tooLarge = true
+		return 0, ErrBodyTooLarge
+	}
+	return w.body.Write(p)
+}
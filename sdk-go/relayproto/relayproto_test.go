@@ -0,0 +1,61 @@
+package relayproto
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestServeRequest(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/call" || r.Header.Get("X-Request-ID") != "req-1" {
+			t.Errorf("Handler got %s %s %v", r.Method, r.URL.Path, r.Header)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status":"accepted"}`))
+	})
+	resp := ServeRequest(handler, Frame{
+		Type:      "request",
+		RequestID: "r1",
+		Method:    http.MethodPost,
+		Path:      "/call",
+		Header:    http.Header{"X-Request-Id": {"req-1"}},
+		Body:      []byte(`{}`),
+	})
+	if resp.Type != "response" || resp.RequestID != "r1" || resp.Status != http.StatusAccepted {
+		t.Errorf("Response %+v", resp)
+	}
+	if string(resp.Body) != `{"status":"accepted"}` || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Response body %q, header %v", resp.Body, resp.Header)
+	}
+
+	// Handlers that write nothing answer 200; bad requests answer 400
+	if resp := ServeRequest(http.NotFoundHandler(), Frame{Method: "GET", Path: "/x"}); resp.Status != http.StatusNotFound {
+		t.Errorf("Not found answered %d", resp.Status)
+	}
+	if resp := ServeRequest(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), Frame{Method: "GET", Path: "/"}); resp.Status != http.StatusOK {
+		t.Errorf("Silent handler answered %d", resp.Status)
+	}
+	if resp := ServeRequest(http.NotFoundHandler(), Frame{Method: "BAD METHOD", Path: "/"}); resp.Status != http.StatusBadRequest {
+		t.Errorf("Bad method answered %d", resp.Status)
+	}
+
+	// Bodies discovery could not take back answer 502 instead
+	big := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, MaxBodySize))
+		if _, err := w.Write([]byte("x")); err != ErrBodyTooLarge {
+			t.Errorf("Write past the limit = %v", err)
+		}
+	})
+	if resp := ServeRequest(big, Frame{Method: "GET", Path: "/"}); resp.Status != http.StatusBadGateway || len(resp.Body) > 100 {
+		t.Errorf("Oversized response answered %d with %d bytes", resp.Status, len(resp.Body))
+	}
+
+	// Tunnel frames carry their URL
+	var frame Frame
+	json.Unmarshal([]byte(`{"type":"tunnel","url":"https://d.example/t/orion"}`), &frame)
+	if frame.URL != "https://d.example/t/orion" {
+		t.Errorf("Tunnel frame decoded as %+v", frame)
+	}
+}
@@ -0,0 +1,177 @@
+package botcall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrClosed is returned when registering a client that has been shut down
+var ErrClosed = errors.New("botcall: client closed")
+
+// serveDrainTimeout bounds the graceful shutdown Serve runs when its
+// context is cancelled
+const serveDrainTimeout = 30 * time.Second
+
+// Handler serves the bot's /call, /call/ws and /health endpoints. Mount
+// it in an existing router, or let Serve run it; each client has its own
+// mux, so several bots can share a process.
+func (c *Client) Handler() http.Handler {
+	c.muxOnce.Do(func() {
+		c.mux = http.NewServeMux()
+		c.mux.HandleFunc("/call", c.handleCall)
+		c.mux.HandleFunc("/call/ws", c.handleCallSocket)
+		c.mux.HandleFunc("/health", c.handleHealth)
+	})
+	return c.mux
+}
+
+// Serve registers with discovery and accepts calls on ln until ctx is
+// cancelled or Shutdown is called. An empty Endpoint is taken from the
+// listener's address. Cancelling ctx shuts down as Shutdown does, giving
+// calls up to 30 seconds to finish, and returns ctx.Err(); after Shutdown,
+// Serve returns nil.
+func (c *Client) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           c.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	c.mu.Lock()
+	if c.server != nil {
+		c.mu.Unlock()
+		ln.Close()
+		return fmt.Errorf("botcall: client is already serving")
+	}
+	c.server = srv
+	fromListener := c.Endpoint == ""
+	if fromListener {
+		c.Endpoint = ln.Addr().String()
+	}
+	endpoint := c.Endpoint
+	c.mu.Unlock()
+
+	// Listen first, so discovery never hands out an endpoint that refuses
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	if err := c.Connect(); err != nil {
+		srv.Close()
+		// Let a later Serve try again, on a listener of its own
+		c.mu.Lock()
+		if c.server == srv {
+			c.server = nil
+		}
+		if fromListener && c.Endpoint == endpoint {
+			c.Endpoint = ""
+		}
+		c.mu.Unlock()
+		if errors.Is(err, ErrClosed) {
+			return nil
+		}
+		return err
+	}
+	c.logger().Info("listening for calls", "addr", ln.Addr().String(), "endpoint", endpoint)
+
+	select {
+	case err := <-errc:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), serveDrainTimeout)
+		defer cancel()
+		if err := c.Shutdown(shutdownCtx); err != nil {
+			c.logger().Warn("shutdown incomplete", "agent_id", c.AgentID, "err", err)
+		}
+		return ctx.Err()
+	}
+}
+
+// Shutdown takes the bot out of discovery, stops the keepalive, refuses
+// new calls and waits for in-flight requests and OnCall handlers to
+// return, or for ctx to expire. Discovery is told first, so no new humans
+// are sent here while the calls drain; a registration still in flight is
+// cut off, or withdrawn if discovery already took it. The client cannot
+// be reused.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.closeOnce.Do(func() { close(c.closed) })
+
+	c.mu.Lock()
+	c.draining = true
+	srv := c.server
+	registered := c.registered
+	c.mu.Unlock()
+
+	var err error
+	if registered {
+		err = c.Deregister(ctx)
+	}
+	if srv != nil {
+		if serr := srv.Shutdown(ctx); err == nil {
+			err = serr
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("drain calls: %w", ctx.Err())
+		}
+	}
+
+	// Relayed handlers talk over the socket, so it goes last
+	c.hangupAll(HangupShutdown)
+	c.mu.Lock()
+	c.registered = false
+	if c.wsConn != nil {
+		if cerr := c.wsConn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	c.mu.Unlock()
+	return err
+}
+
+// beginCall counts a call in until its OnCall handler returns. It fails
+// once the client is shutting down.
+func (c *Client) beginCall() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining {
+		return false
+	}
+	c.inflight.Add(1)
+	return true
+}
+
+func (c *Client) isDraining() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.draining
+}
+
+func (c *Client) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	w.Header().Set("Content-Type", "application/json")
+	// Discovery's health probe marks a draining instance degraded
+	if c.isDraining() {
+		status = "draining"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  status,
+		"agent":   c.AgentID,
+		"version": "0.1.0",
+	})
+}
@@ -0,0 +1,367 @@
+package botcall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeDiscovery records registrations and deregistrations by agent ID
+type fakeDiscovery struct {
+	*httptest.Server
+	mu            sync.Mutex
+	endpoints     map[string]string
+	deregistered  map[string]bool
+	registrations int
+	// failWith answers the next registrations with these statuses
+	failWith []int
+	// hold, if set, delays the answer to registrations it has recorded
+	// until it is closed
+	hold   chan struct{}
+	bootID string
+	// relays receives bots' relay sockets
+	relays chan *websocket.Conn
+	// iceExpires, if set, is when the TURN credentials handed out expire
+	iceExpires time.Time
+	iceFetches int
+	iceRequest *http.Request
+	// presence is the last PUT /v1/presence body, keyed by agent ID
+	presence     map[string]presenceRequest
+	presenceAuth string
+}
+
+func newFakeDiscovery(t *testing.T) *fakeDiscovery {
+	d := &fakeDiscovery{
+		endpoints:    make(map[string]string),
+		deregistered: make(map[string]bool),
+		relays:       make(chan *websocket.Conn, 1),
+		presence:     make(map[string]presenceRequest),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/register", func(w http.ResponseWriter, r *http.Request) {
+		var req RegisterRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		d.mu.Lock()
+		d.registrations++
+		if len(d.failWith) > 0 {
+			status := d.failWith[0]
+			d.failWith = d.failWith[1:]
+			d.mu.Unlock()
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": "test_failure", "message": "failed on purpose"})
+			return
+		}
+		d.endpoints[req.AgentID] = req.Endpoint
+		hold := d.hold
+		d.mu.Unlock()
+		if hold != nil {
+			<-hold
+		}
+		json.NewEncoder(w).Encode(RegisterResponse{Confirmed: true, Status: "online"})
+	})
+	mux.HandleFunc("/v1/register/", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		d.deregistered[strings.TrimPrefix(r.URL.Path, "/v1/register/")] = true
+		d.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"status": "deregistered"})
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "boot_id": d.bootID})
+	})
+	mux.HandleFunc("/v1/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		d.relays <- conn
+	})
+	mux.HandleFunc("/v1/ice-servers", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		d.iceFetches++
+		d.iceRequest = r
+		resp := map[string]interface{}{
+			"ice_servers": []ICEServer{{URLs: []string{"stun:stun.example.com:3478"}}},
+		}
+		if !d.iceExpires.IsZero() {
+			resp["expires"] = d.iceExpires
+		}
+		d.mu.Unlock()
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/v1/presence/", func(w http.ResponseWriter, r *http.Request) {
+		var req presenceRequest
+		if r.Method != http.MethodPut || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, `{"error":"bad_request","message":"bad presence"}`, http.StatusBadRequest)
+			return
+		}
+		d.mu.Lock()
+		d.presence[strings.TrimPrefix(r.URL.Path, "/v1/presence/")] = req
+		d.presenceAuth = r.Header.Get("Authorization")
+		d.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"state": req.State})
+	})
+	d.Server = httptest.NewServer(mux)
+	t.Cleanup(d.Close)
+	return d
+}
+
+func (d *fakeDiscovery) endpoint(agentID string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.endpoints[agentID]
+}
+
+func (d *fakeDiscovery) wasDeregistered(agentID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.deregistered[agentID]
+}
+
+func newTestClient(d *fakeDiscovery, agentID string) *Client {
+	c := NewClient(agentID, "")
+	c.SetDiscoveryURL(d.URL)
+	return c
+}
+
+// serve runs c.Serve on a fresh localhost listener until it registers
+func serve(t *testing.T, ctx context.Context, d *fakeDiscovery, c *Client) <-chan error {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Serve(ctx, ln) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for d.endpoint(c.AgentID) == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never registered", c.AgentID)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got, want := d.endpoint(c.AgentID), ln.Addr().String(); got != want {
+		t.Fatalf("%s registered endpoint %q, want %q", c.AgentID, got, want)
+	}
+	return done
+}
+
+func placeCall(t *testing.T, url, humanID string) *http.Response {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"human_id": humanID})
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestTwoBotsOneProcess(t *testing.T) {
+	d := newFakeDiscovery(t)
+	calls := make(chan string, 2)
+
+	// alpha runs its own server
+	alpha := newTestClient(d, "alpha")
+	alpha.OnCall(func(call *Call) { calls <- "alpha:" + call.HumanID })
+	ctx, cancel := context.WithCancel(context.Background())
+	done := serve(t, ctx, d, alpha)
+
+	// beta is mounted in an application's router
+	beta := newTestClient(d, "beta")
+	beta.OnCall(func(call *Call) { calls <- "beta:" + call.HumanID })
+	router := http.NewServeMux()
+	router.Handle("/bots/beta/", http.StripPrefix("/bots/beta", beta.Handler()))
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("app")) })
+	app := httptest.NewServer(router)
+	defer app.Close()
+	beta.Endpoint = app.URL + "/bots/beta"
+	if err := beta.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := placeCall(t, "http://"+d.endpoint("alpha")+"/call", "ann"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Call to alpha: %d", resp.StatusCode)
+	}
+	if resp := placeCall(t, app.URL+"/bots/beta/call", "bob"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Call to beta: %d", resp.StatusCode)
+	}
+	got := map[string]bool{<-calls: true, <-calls: true}
+	if !got["alpha:ann"] || !got["beta:bob"] {
+		t.Errorf("Calls reached %v, want alpha:ann and beta:bob", got)
+	}
+
+	for url, agent := range map[string]string{
+		"http://" + d.endpoint("alpha") + "/health": "alpha",
+		app.URL + "/bots/beta/health":               "beta",
+	} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var health map[string]string
+		json.NewDecoder(resp.Body).Decode(&health)
+		resp.Body.Close()
+		if health["agent"] != agent {
+			t.Errorf("%s served agent %q, want %q", url, health["agent"], agent)
+		}
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Serve returned %v, want context.Canceled", err)
+	}
+	if !d.wasDeregistered("alpha") {
+		t.Error("alpha was not deregistered")
+	}
+	if d.wasDeregistered("beta") {
+		t.Error("Stopping alpha deregistered beta")
+	}
+	if err := beta.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !d.wasDeregistered("beta") {
+		t.Error("beta was not deregistered")
+	}
+}
+
+func TestShutdownDrainsCalls(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	started, release := make(chan struct{}), make(chan struct{})
+	c.OnCall(func(call *Call) {
+		close(started)
+		<-release
+	})
+	done := serve(t, context.Background(), d, c)
+
+	placeCall(t, "http://"+d.endpoint("orion")+"/call", "ann")
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- c.Shutdown(context.Background()) }()
+
+	// Discovery hears first, and new calls are refused while ann talks
+	deadline := time.Now().Add(2 * time.Second)
+	for !d.wasDeregistered("orion") {
+		if time.Now().After(deadline) {
+			t.Fatal("Not deregistered while draining")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/call", strings.NewReader(`{"human_id":"bob"}`)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Call while draining: %d, want 503", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Health while draining: %d, want 503", rec.Code)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("Shutdown returned %v before the call ended", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Serve returned %v after Shutdown, want nil", err)
+	}
+	if err := c.Connect(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Connect after Shutdown: %v, want ErrClosed", err)
+	}
+}
+
+func TestShutdownGivesUpAtDeadline(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	c.OnCall(func(call *Call) {
+		close(started)
+		<-release
+	})
+	serve(t, context.Background(), d, c)
+
+	placeCall(t, "http://"+d.endpoint("orion")+"/call", "ann")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown: %v, want a deadline error", err)
+	}
+}
+
+func TestShutdownWithdrawsSlowRegistration(t *testing.T) {
+	d := newFakeDiscovery(t)
+	d.hold = make(chan struct{})
+	defer close(d.hold)
+	c := newTestClient(d, "orion")
+
+	// A keepalive discovery has taken but not yet answered
+	connected := make(chan error, 1)
+	go func() { connected <- c.Connect() }()
+	deadline := time.Now().Add(2 * time.Second)
+	for d.endpoint("orion") == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("Registration never reached discovery")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	select {
+	case err := <-connected:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Registration in flight returned %v, want ErrClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown left the registration in flight")
+	}
+	if !d.wasDeregistered("orion") {
+		t.Error("Bot left online after Shutdown")
+	}
+}
+
+func TestServeRetriesAfterConnectFails(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	d.failWith = []int{http.StatusForbidden}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Serve(context.Background(), ln); err == nil {
+		t.Fatal("Serve succeeded though discovery refused it")
+	}
+	// The failed attempt leaves the client free to serve again
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serve(t, ctx, d, c)
+}
@@ -0,0 +1,199 @@
+package botcall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Registration states reported to OnRegistration
+const (
+	RegistrationRegistered = "registered" // discovery confirmed the registration
+	RegistrationRetrying   = "retrying"   // an attempt failed; the next follows after RetryIn
+	RegistrationRejected   = "rejected"   // discovery refused it; KeepRegistered has stopped
+)
+
+// ErrRejected is returned when discovery answers but does not confirm
+// the registration
+var ErrRejected = errors.New("botcall: registration rejected")
+
+// DefaultKeepalive is how often KeepRegistered re-registers by default,
+// inside discovery's default five-minute presence TTL
+const DefaultKeepalive = 4 * time.Minute
+
+// Vars so tests need not wait out real backoffs
+var (
+	registerMinBackoff = time.Second
+	registerMaxBackoff = time.Minute
+	// discoveryCheckInterval paces the /health checks that notice
+	// discovery restarting or coming back; each waits a random half to
+	// all of it, so a fleet of bots does not poll in step
+	discoveryCheckInterval = time.Minute
+)
+
+// RegistrationEvent reports a change in KeepRegistered's state
+type RegistrationEvent struct {
+	State string
+	// Err is why the last attempt failed; nil once registered
+	Err error
+	// Failures counts attempts failed in a row
+	Failures int
+	// RetryIn is the wait before the next attempt, when retrying
+	RetryIn time.Duration
+}
+
+// OnRegistration sets a handler for KeepRegistered's state changes. It
+// runs on the supervisor's goroutine, so keep it quick.
+func (c *Client) OnRegistration(handler func(RegistrationEvent)) {
+	c.onRegistration = handler
+}
+
+// KeepRegistered registers and keeps the registration alive until ctx is
+// cancelled or the client is closed. Healthy bots re-register every
+// interval (DefaultKeepalive if zero); failed attempts are retried with
+// exponential backoff and jitter. It checks discovery's /health about
+// once a minute and re-registers as soon as discovery has restarted or
+// come back from an outage, rather than waiting out the interval. A
+// registration discovery refuses outright, such as a key that does not
+// own AgentID, is reported as RegistrationRejected and returned.
+func (c *Client) KeepRegistered(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultKeepalive
+	}
+	checkEvery := min(discoveryCheckInterval, interval)
+	check := time.NewTimer(jitter(checkEvery))
+	defer check.Stop()
+
+	var state, bootID string
+	failures := 0
+	for {
+		err := c.Connect()
+		var wait time.Duration
+		switch {
+		case err == nil:
+			failures = 0
+			wait = interval
+			bootID, _ = c.discoveryBootID(ctx)
+			if state != RegistrationRegistered {
+				state = RegistrationRegistered
+				c.reportRegistration(RegistrationEvent{State: state})
+			}
+		case errors.Is(err, ErrClosed):
+			return nil
+		case rejected(err):
+			c.logger().Error("registration rejected", "agent_id", c.AgentID, "err", err)
+			c.reportRegistration(RegistrationEvent{State: RegistrationRejected, Err: err, Failures: failures + 1})
+			return err
+		default:
+			failures++
+			wait = backoffDelay(failures, registerMinBackoff, registerMaxBackoff)
+			state = RegistrationRetrying
+			c.logger().Warn("registration failed; retrying", "agent_id", c.AgentID, "err", err, "failures", failures, "retry_in", wait)
+			c.reportRegistration(RegistrationEvent{State: state, Err: err, Failures: failures, RetryIn: wait})
+		}
+
+		timer := time.NewTimer(wait)
+	waiting:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-c.closed:
+				timer.Stop()
+				return nil
+			case <-timer.C:
+				break waiting
+			case <-check.C:
+				check.Reset(jitter(checkEvery))
+				id, herr := c.discoveryBootID(ctx)
+				if herr != nil {
+					continue
+				}
+				back := failures > 0 && unreachable(err)
+				restarted := bootID != "" && id != "" && id != bootID
+				if back || restarted {
+					c.logger().Info("discovery is back; re-registering", "agent_id", c.AgentID, "restarted", restarted)
+					timer.Stop()
+					break waiting
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) reportRegistration(ev RegistrationEvent) {
+	if c.onRegistration != nil {
+		c.onRegistration(ev)
+	}
+}
+
+// discoveryBootID returns the ID discovery's /health reports, which
+// changes whenever discovery restarts; empty from servers without one
+func (c *Client) discoveryBootID(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.DiscoveryURL+"/health", nil)
+	if err != nil {
+		return "", fmt.Errorf("build health request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("check discovery health: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", discoveryError(resp)
+	}
+	var health struct {
+		BootID string `json:"boot_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return "", fmt.Errorf("decode health: %w", err)
+	}
+	return health.BootID, nil
+}
+
+// rejected reports whether discovery will keep refusing the registration
+// until the bot's configuration changes
+func rejected(err error) bool {
+	if errors.Is(err, ErrRejected) {
+		return true
+	}
+	var de *DiscoveryError
+	if !errors.As(err, &de) {
+		return false
+	}
+	switch de.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return de.StatusCode >= 400 && de.StatusCode < 500
+}
+
+// unreachable reports whether err means discovery never answered
+func unreachable(err error) bool {
+	var de *DiscoveryError
+	return err != nil && !errors.Is(err, ErrRejected) && !errors.As(err, &de)
+}
+
+// backoffDelay is the wait before retry n (from 1): doubling from lo up
+// to hi, with the upper half randomized so bots that lost discovery
+// together do not all come back at once
+func backoffDelay(n int, lo, hi time.Duration) time.Duration {
+	d := lo
+	for i := 1; i < n && d < hi; i++ {
+		d *= 2
+	}
+	if d > hi {
+		d = hi
+	}
+	return jitter(d)
+}
+
+// jitter is a random wait between half of d and d
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
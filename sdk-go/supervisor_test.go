@@ -0,0 +1,157 @@
+package botcall
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fastRetries shrinks the supervisor's backoff and health checks
+func fastRetries(t *testing.T) {
+	lo, hi, check := registerMinBackoff, registerMaxBackoff, discoveryCheckInterval
+	registerMinBackoff, registerMaxBackoff, discoveryCheckInterval = 10*time.Millisecond, 40*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { registerMinBackoff, registerMaxBackoff, discoveryCheckInterval = lo, hi, check })
+}
+
+func nextEvent(t *testing.T, events <-chan RegistrationEvent) RegistrationEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("No registration event")
+		return RegistrationEvent{}
+	}
+}
+
+func TestKeepRegisteredRetriesThenRegisters(t *testing.T) {
+	fastRetries(t)
+	d := newFakeDiscovery(t)
+	d.failWith = []int{http.StatusServiceUnavailable, http.StatusBadGateway}
+	c := newTestClient(d, "orion")
+	c.Endpoint = "127.0.0.1:9000"
+	events := make(chan RegistrationEvent, 8)
+	c.OnRegistration(func(ev RegistrationEvent) { events <- ev })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.KeepRegistered(ctx, time.Hour) }()
+
+	for i := 1; i <= 2; i++ {
+		ev := nextEvent(t, events)
+		var de *DiscoveryError
+		if ev.State != RegistrationRetrying || ev.Failures != i || !errors.As(ev.Err, &de) {
+			t.Fatalf("Event %d = %+v, want retrying after a discovery error", i, ev)
+		}
+		if lo, hi := registerMinBackoff<<(i-1)/2, registerMinBackoff<<(i-1); ev.RetryIn < lo || ev.RetryIn > hi {
+			t.Errorf("RetryIn %v outside [%v, %v]", ev.RetryIn, lo, hi)
+		}
+	}
+	if ev := nextEvent(t, events); ev.State != RegistrationRegistered || ev.Err != nil {
+		t.Fatalf("Event = %+v, want registered", ev)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("KeepRegistered returned %v, want context.Canceled", err)
+	}
+}
+
+func TestKeepRegisteredAfterDiscoveryRestart(t *testing.T) {
+	fastRetries(t)
+	d := newFakeDiscovery(t)
+	d.bootID = "first"
+	c := newTestClient(d, "orion")
+	c.Endpoint = "127.0.0.1:9000"
+	events := make(chan RegistrationEvent, 8)
+	c.OnRegistration(func(ev RegistrationEvent) { events <- ev })
+	go c.KeepRegistered(context.Background(), time.Hour)
+	defer c.Close()
+
+	if ev := nextEvent(t, events); ev.State != RegistrationRegistered {
+		t.Fatalf("Event = %+v, want registered", ev)
+	}
+
+	// A restarted discovery has forgotten the bot; the hour-long keepalive
+	// must not be what brings it back
+	d.mu.Lock()
+	d.bootID = "second"
+	d.endpoints = make(map[string]string)
+	d.mu.Unlock()
+	deadline := time.Now().Add(2 * time.Second)
+	for d.endpoint("orion") == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("Not re-registered after discovery restarted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("Unexpected event %+v; the state never changed", ev)
+	default:
+	}
+}
+
+func TestKeepRegisteredStopsWhenRejected(t *testing.T) {
+	fastRetries(t)
+	d := newFakeDiscovery(t)
+	d.failWith = []int{http.StatusForbidden}
+	c := newTestClient(d, "orion")
+	c.Endpoint = "127.0.0.1:9000"
+	var got []RegistrationEvent
+	c.OnRegistration(func(ev RegistrationEvent) { got = append(got, ev) })
+
+	err := c.KeepRegistered(context.Background(), time.Hour)
+	var de *DiscoveryError
+	if !errors.As(err, &de) || de.StatusCode != http.StatusForbidden || de.Code != "test_failure" {
+		t.Fatalf("KeepRegistered returned %v, want the 403", err)
+	}
+	if len(got) != 1 || got[0].State != RegistrationRejected || got[0].Err != err {
+		t.Errorf("Events = %+v, want one rejection", got)
+	}
+	if d.registrations != 1 {
+		t.Errorf("%d registration attempts, want 1", d.registrations)
+	}
+}
+
+func TestKeepRegisteredStopsOnClose(t *testing.T) {
+	fastRetries(t)
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	c.Endpoint = "127.0.0.1:9000"
+	done := make(chan error, 1)
+	go func() { done <- c.KeepRegistered(context.Background(), time.Hour) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !c.IsRegistered() {
+		if time.Now().After(deadline) {
+			t.Fatal("Never registered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("KeepRegistered returned %v after Close, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("KeepRegistered still running after Close")
+	}
+}
+
+func TestJitterStaysInUpperHalf(t *testing.T) {
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 200; i++ {
+		d := jitter(time.Minute)
+		if d < 30*time.Second || d > time.Minute {
+			t.Fatalf("jitter(1m) = %v", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("Only %d distinct waits in 200 draws", len(seen))
+	}
+}
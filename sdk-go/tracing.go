@@ -0,0 +1,85 @@
+package botcall
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the SDK's spans
+const tracerName = "github.com/TheOrionAI/botcall-sdk-go"
+
+// propagator carries W3C trace context on the client's requests and relay
+// frames, whether or not the application installed a global one
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// tracer returns the client's tracer. Without a configured provider the
+// spans are no-ops, but trace context still flows through the bot.
+func (c *Client) tracer() trace.Tracer {
+	if c.TracerProvider != nil {
+		return c.TracerProvider.Tracer(tracerName)
+	}
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// endSpan records err, if any, and ends span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tagRequest marks an outgoing discovery request with a request ID and the
+// trace context of its own context
+func tagRequest(req *http.Request) {
+	setRequestID(req)
+	propagator.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+}
+
+// dispatchCall runs the OnCall handler in a span of its own, a child of the
+// one that accepted the call, and counts the call out when it returns. The
+// handler outlives the request that placed the call, so ctx's cancellation
+// is dropped.
+func (c *Client) dispatchCall(ctx context.Context, call *Call) {
+	defer c.inflight.Done()
+	if c.onCallHandler == nil {
+		return
+	}
+	_, span := c.tracer().Start(context.WithoutCancel(ctx), "botcall.OnCall", trace.WithAttributes(
+		attribute.String("botcall.call_id", call.CallID),
+		attribute.String("botcall.human_id", call.HumanID),
+	))
+	defer span.End()
+	c.onCallHandler(call)
+}
+
+// frameCarrier exposes a relay frame's trace fields to the propagator
+type frameCarrier struct{ frame *relayFrame }
+
+func (c frameCarrier) Get(key string) string {
+	switch key {
+	case "traceparent":
+		return c.frame.TraceParent
+	case "tracestate":
+		return c.frame.TraceState
+	}
+	return ""
+}
+
+func (c frameCarrier) Set(key, value string) {
+	switch key {
+	case "traceparent":
+		c.frame.TraceParent = value
+	case "tracestate":
+		c.frame.TraceState = value
+	}
+}
+
+func (c frameCarrier) Keys() []string { return []string{"traceparent", "tracestate"} }
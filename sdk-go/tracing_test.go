@@ -0,0 +1,122 @@
+package botcall
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// The caller's trace: trace ID 4bf9..., span ID 00f0...
+const (
+	callerTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	callerTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+)
+
+// spanRecorder is a TracerProvider that notes the parent each span was
+// started under; the spans themselves are no-ops
+type spanRecorder struct {
+	embedded.TracerProvider
+
+	mu      sync.Mutex
+	parents map[string]trace.SpanContext
+}
+
+type recordingTracer struct {
+	embedded.Tracer
+	r *spanRecorder
+}
+
+func newSpanRecorder() *spanRecorder {
+	return &spanRecorder{parents: make(map[string]trace.SpanContext)}
+}
+
+func (r *spanRecorder) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return recordingTracer{r: r}
+}
+
+func (t recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	t.r.mu.Lock()
+	t.r.parents[name] = trace.SpanContextFromContext(ctx)
+	t.r.mu.Unlock()
+	return noop.NewTracerProvider().Tracer("").Start(ctx, name, opts...)
+}
+
+func (r *spanRecorder) parent(name string) trace.SpanContext {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.parents[name]
+}
+
+func TestTraceContinuesFromCall(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	spans := newSpanRecorder()
+	c.TracerProvider = spans
+	calls := make(chan *Call, 1)
+	c.OnCall(func(call *Call) { calls <- call })
+	serve(t, context.Background(), d, c)
+	defer c.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, "http://"+d.endpoint("orion")+"/call", strings.NewReader(`{"human_id":"ann"}`))
+	req.Header.Set("traceparent", callerTraceParent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	<-calls
+
+	parent := spans.parent("botcall.handleCall")
+	if !parent.IsRemote() || parent.TraceID().String() != callerTraceID || parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("handleCall started under %+v, want the caller's span", parent)
+	}
+	if got := spans.parent("botcall.OnCall").TraceID().String(); got != callerTraceID {
+		t.Errorf("OnCall ran in trace %s", got)
+	}
+	// Discovery requests made for the call carry the trace on
+	d.mu.Lock()
+	ice := d.iceRequest
+	d.mu.Unlock()
+	if ice == nil || !strings.Contains(ice.Header.Get("traceparent"), callerTraceID) {
+		t.Error("ICE servers fetched outside the caller's trace")
+	}
+}
+
+func TestTraceContinuesFromRelayFrame(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	spans := newSpanRecorder()
+	c.TracerProvider = spans
+	calls := make(chan *Call, 1)
+	c.OnCall(func(call *Call) { calls <- call })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.ServeRelay(ctx)
+
+	var hub *websocket.Conn
+	select {
+	case hub = <-d.relays:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Bot never opened its relay socket")
+	}
+	defer hub.Close()
+
+	hub.WriteJSON(relayFrame{Type: "call", CallID: "call-1", HumanID: "ann", Mode: "text", TraceParent: callerTraceParent})
+	select {
+	case <-calls:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Relayed call never reached OnCall")
+	}
+	parent := spans.parent("botcall.OnCall")
+	if !parent.IsRemote() || parent.TraceID().String() != callerTraceID {
+		t.Errorf("OnCall started under %+v, want the frame's span", parent)
+	}
+}
@@ -0,0 +1,221 @@
+package botcall
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// WAV files here are RIFF/WAVE with 16-bit PCM samples, the format
+// recorders and TTS engines write by default
+
+const (
+	wavFormatPCM  = 1
+	wavHeaderSize = 44
+	// DefaultFrameDuration is the frame size of WAV sources, as in WebRTC
+	DefaultFrameDuration = 20 * time.Millisecond
+)
+
+// WAVSource reads a WAV file as PCM frames
+type WAVSource struct {
+	r         io.Reader
+	format    AudioFormat
+	frameSize int // samples per frame, all channels
+	remaining int64
+	read      int64 // samples read so far
+}
+
+// NewWAVSource reads the WAV header from r and returns a source of
+// frames of frameDur (DefaultFrameDuration if zero)
+func NewWAVSource(r io.Reader, frameDur time.Duration) (*WAVSource, error) {
+	if frameDur <= 0 {
+		frameDur = DefaultFrameDuration
+	}
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("read wav header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("botcall: not a WAV file")
+	}
+
+	src := &WAVSource{r: r}
+	haveFormat := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("read wav chunk: %w", err)
+		}
+		id, size := string(chunk[0:4]), int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, errors.New("botcall: short WAV fmt chunk")
+			}
+			var fmtChunk [16]byte
+			if _, err := io.ReadFull(r, fmtChunk[:]); err != nil {
+				return nil, fmt.Errorf("read wav fmt: %w", err)
+			}
+			if tag := binary.LittleEndian.Uint16(fmtChunk[0:2]); tag != wavFormatPCM {
+				return nil, fmt.Errorf("botcall: WAV format %d is not PCM", tag)
+			}
+			if bits := binary.LittleEndian.Uint16(fmtChunk[14:16]); bits != 16 {
+				return nil, fmt.Errorf("botcall: WAV has %d-bit samples, want 16", bits)
+			}
+			src.format = AudioFormat{
+				Codec:      CodecPCM,
+				Channels:   int(binary.LittleEndian.Uint16(fmtChunk[2:4])),
+				SampleRate: int(binary.LittleEndian.Uint32(fmtChunk[4:8])),
+			}
+			if src.format.Channels == 0 || src.format.SampleRate == 0 {
+				return nil, errors.New("botcall: WAV has no channels or sample rate")
+			}
+			if err := skip(r, size-16+size%2); err != nil {
+				return nil, err
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return nil, errors.New("botcall: WAV data before fmt")
+			}
+			src.remaining = size / 2
+			src.frameSize = int(int64(src.format.SampleRate)*int64(frameDur)/int64(time.Second)) * src.format.Channels
+			if src.frameSize == 0 {
+				src.frameSize = src.format.Channels
+			}
+			return src, nil
+		default:
+			// LIST, fact and the like; chunks are padded to even sizes
+			if err := skip(r, size+size%2); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func skip(r io.Reader, n int64) error {
+	if _, err := io.CopyN(io.Discard, r, n); err != nil {
+		return fmt.Errorf("read wav chunk: %w", err)
+	}
+	return nil
+}
+
+// Format is the file's format
+func (s *WAVSource) Format() AudioFormat {
+	return s.format
+}
+
+// ReadFrame returns the next frame; the last may be short
+func (s *WAVSource) ReadFrame() (AudioFrame, error) {
+	n := int64(s.frameSize)
+	if n > s.remaining {
+		n = s.remaining
+	}
+	n -= n % int64(s.format.Channels)
+	if n == 0 {
+		return AudioFrame{}, io.EOF
+	}
+	buf := make([]byte, n*2)
+	got, err := io.ReadFull(s.r, buf)
+	switch {
+	case err == io.EOF:
+		return AudioFrame{}, io.EOF
+	case err == io.ErrUnexpectedEOF:
+		// Truncated file: end after what was there
+		s.remaining = 0
+	case err != nil:
+		return AudioFrame{}, fmt.Errorf("read wav data: %w", err)
+	default:
+		s.remaining -= n
+	}
+	samples := got / 2
+	samples -= samples % s.format.Channels
+	if samples == 0 {
+		return AudioFrame{}, io.EOF
+	}
+	pcm := make([]int16, samples)
+	for i := range pcm {
+		pcm[i] = int16(binary.LittleEndian.Uint16(buf[2*i:]))
+	}
+
+	frame := AudioFrame{
+		Format:    s.format,
+		PCM:       pcm,
+		Timestamp: pcmDuration(int(s.read), s.format),
+		Duration:  pcmDuration(samples, s.format),
+	}
+	s.read += int64(samples)
+	return frame, nil
+}
+
+// WAVSink writes PCM frames to a WAV file. The header's sizes are filled
+// in by Close, so the writer must be seekable, like an *os.File.
+type WAVSink struct {
+	w       io.WriteSeeker
+	format  AudioFormat
+	samples int64
+}
+
+// NewWAVSink writes a WAV header for format, which must be PCM, to w
+func NewWAVSink(w io.WriteSeeker, format AudioFormat) (*WAVSink, error) {
+	if format.Codec != CodecPCM {
+		return nil, fmt.Errorf("botcall: WAV sink needs PCM, not %s", format.Codec)
+	}
+	if format.Channels <= 0 || format.SampleRate <= 0 {
+		return nil, errors.New("botcall: WAV sink needs channels and a sample rate")
+	}
+	sink := &WAVSink{w: w, format: format}
+	if _, err := w.Write(sink.header()); err != nil {
+		return nil, fmt.Errorf("write wav header: %w", err)
+	}
+	return sink, nil
+}
+
+func (s *WAVSink) header() []byte {
+	h := make([]byte, wavHeaderSize)
+	dataSize := uint32(s.samples * 2)
+	blockAlign := uint16(s.format.Channels * 2)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 36+dataSize)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], wavFormatPCM)
+	binary.LittleEndian.PutUint16(h[22:], uint16(s.format.Channels))
+	binary.LittleEndian.PutUint32(h[24:], uint32(s.format.SampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(s.format.SampleRate)*uint32(blockAlign))
+	binary.LittleEndian.PutUint16(h[32:], blockAlign)
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], dataSize)
+	return h
+}
+
+// WriteFrame appends frame's samples. Frames must match the sink's format.
+func (s *WAVSink) WriteFrame(frame AudioFrame) error {
+	if frame.Format != s.format {
+		return fmt.Errorf("botcall: frame is %+v, WAV sink is %+v", frame.Format, s.format)
+	}
+	buf := make([]byte, 2*len(frame.PCM))
+	for i, sample := range frame.PCM {
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(sample))
+	}
+	if _, err := s.w.Write(buf); err != nil {
+		return fmt.Errorf("write wav data: %w", err)
+	}
+	s.samples += int64(len(frame.PCM))
+	return nil
+}
+
+// Close fills in the header's sizes. It does not close the writer.
+func (s *WAVSink) Close() error {
+	if _, err := s.w.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek wav header: %w", err)
+	}
+	if _, err := s.w.Write(s.header()); err != nil {
+		return fmt.Errorf("write wav header: %w", err)
+	}
+	_, err := s.w.Seek(0, io.SeekEnd)
+	return err
+}
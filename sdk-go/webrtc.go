@@ -0,0 +1,430 @@
+package botcall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// Voice calls are WebRTC sessions the human offers once the bot accepts.
+// The offer, the answer and trickled ICE candidates travel as frames on
+// the call's transport, signaling or the call socket, and the peer
+// connection carries one Opus track each way plus a "text" data channel.
+// The SDK does not encode or decode Opus: AudioIn yields the human's
+// packets as they arrive and AudioOut takes packets ready to send.
+
+// textChannelID is the data channel both sides open, pre-negotiated, for
+// chat; the PWA creates the same channel before making its offer
+const textChannelID = 0
+
+// peer is a call's WebRTC session
+type peer struct {
+	pc    *webrtc.PeerConnection
+	track *webrtc.TrackLocalStaticSample
+	text  *webrtc.DataChannel
+
+	mu       sync.Mutex
+	answered bool
+	early    []relayFrame // candidates gathered before the answer went out
+}
+
+// newPeerConnection builds a peer connection with the client's WebRTC API
+func (c *Client) newPeerConnection(config webrtc.Configuration) (*webrtc.PeerConnection, error) {
+	if c.WebRTCAPI != nil {
+		return c.WebRTCAPI.NewPeerConnection(config)
+	}
+	return webrtc.NewPeerConnection(config)
+}
+
+// heldAudio is how much of the bot's audio waits for the peer connection;
+// older frames are dropped
+const heldAudio = 5 * time.Second
+
+// queueSignal hands a WebRTC frame from the human to the call's signaling
+// goroutine. Frames are handled in order, so candidates find the offer's
+// peer connection, and a slow offer holds up neither the transport's read
+// loop nor other calls.
+func (call *Call) queueSignal(frame relayFrame) {
+	call.signalOnce.Do(func() {
+		call.signals = make(chan relayFrame, callBuffer)
+		go call.runSignals()
+	})
+	select {
+	case call.signals <- frame:
+	default:
+		call.client.logger().Warn("webrtc signaling queue full; dropping frame", "call_id", call.CallID, "type", frame.Type)
+	}
+}
+
+func (call *Call) runSignals() {
+	for {
+		select {
+		case frame := <-call.signals:
+			call.signal(frame)
+		case <-call.ctx.Done():
+			return
+		}
+	}
+}
+
+// signal handles a WebRTC frame from the human
+func (call *Call) signal(frame relayFrame) {
+	var err error
+	switch frame.Type {
+	case "offer":
+		err = call.answerOffer(frame.SDP)
+	case "candidate":
+		err = call.addCandidate(frame.Candidate)
+	}
+	if err != nil {
+		call.client.logger().Warn("webrtc signaling failed", "call_id", call.CallID, "type", frame.Type, "err", err)
+	}
+}
+
+// answerOffer answers the human's SDP offer, setting up the peer
+// connection on the first one
+func (call *Call) answerOffer(sdp string) error {
+	call.mu.Lock()
+	p, ended := call.peer, call.ended
+	call.mu.Unlock()
+	if ended {
+		return ErrCallEnded
+	}
+	if p == nil {
+		var err error
+		if p, err = call.newPeer(); err != nil {
+			return err
+		}
+	}
+
+	if err := p.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}); err != nil {
+		return fmt.Errorf("set offer: %w", err)
+	}
+	answer, err := p.pc.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("create answer: %w", err)
+	}
+	if err := p.pc.SetLocalDescription(answer); err != nil {
+		return fmt.Errorf("set answer: %w", err)
+	}
+	if err := call.send(relayFrame{Type: "answer", SDP: answer.SDP}); err != nil {
+		return err
+	}
+
+	// Browsers refuse candidates that arrive ahead of the answer
+	p.mu.Lock()
+	p.answered = true
+	early := p.early
+	p.early = nil
+	p.mu.Unlock()
+	for _, frame := range early {
+		call.send(frame)
+	}
+	return nil
+}
+
+// newPeer sets up the call's peer connection: the bot's audio track, the
+// human's track feeding AudioIn and the text channel. If another offer set
+// one up meanwhile, that one is returned.
+func (call *Call) newPeer() (*peer, error) {
+	c := call.client
+	var config webrtc.Configuration
+	if servers, err := c.ICEServers(call.ctx); err == nil {
+		for _, s := range servers {
+			config.ICEServers = append(config.ICEServers, webrtc.ICEServer{
+				URLs:       s.URLs,
+				Username:   s.Username,
+				Credential: s.Credential,
+			})
+		}
+	} else {
+		c.logger().Warn("no ICE servers for call", "call_id", call.CallID, "err", err)
+	}
+
+	pc, err := c.newPeerConnection(config)
+	if err != nil {
+		return nil, fmt.Errorf("create peer connection: %w", err)
+	}
+	p := &peer{pc: pc}
+	if err := call.setupPeer(p); err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	call.mu.Lock()
+	if call.ended {
+		call.mu.Unlock()
+		pc.Close()
+		return nil, ErrCallEnded
+	}
+	if existing := call.peer; existing != nil {
+		call.mu.Unlock()
+		pc.Close()
+		return existing, nil
+	}
+	call.peer = p
+	call.mu.Unlock()
+	return p, nil
+}
+
+func (call *Call) setupPeer(p *peer) error {
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypeOpus,
+		ClockRate: uint32(WebRTCAudio.SampleRate),
+		Channels:  uint16(WebRTCAudio.Channels),
+	}, "audio", "botcall")
+	if err != nil {
+		return fmt.Errorf("create audio track: %w", err)
+	}
+	sender, err := p.pc.AddTrack(track)
+	if err != nil {
+		return fmt.Errorf("add audio track: %w", err)
+	}
+	p.track = track
+	// RTCP must be read for pion's interceptors to run
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	negotiated, id := true, uint16(textChannelID)
+	p.text, err = p.pc.CreateDataChannel("text", &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &id})
+	if err != nil {
+		return fmt.Errorf("create text channel: %w", err)
+	}
+	p.text.OnMessage(func(msg webrtc.DataChannelMessage) {
+		call.deliver(string(msg.Data))
+	})
+
+	p.pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if remote.Kind() == webrtc.RTPCodecTypeAudio {
+			call.readTrack(remote)
+		}
+	})
+	p.pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		raw, err := json.Marshal(candidate.ToJSON())
+		if err != nil {
+			return
+		}
+		frame := relayFrame{Type: "candidate", Candidate: raw}
+		p.mu.Lock()
+		if !p.answered {
+			p.early = append(p.early, frame)
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+		call.send(frame)
+	})
+	p.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		call.client.logger().Debug("webrtc state", "call_id", call.CallID, "state", state.String())
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			call.connectedOnce.Do(func() { close(call.connected) })
+		case webrtc.PeerConnectionStateFailed:
+			call.end(HangupDisconnected)
+		}
+	})
+	return nil
+}
+
+// addCandidate adds an ICE candidate the human trickled
+func (call *Call) addCandidate(raw json.RawMessage) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	call.mu.Lock()
+	p := call.peer
+	call.mu.Unlock()
+	if p == nil {
+		return errors.New("botcall: ICE candidate before offer")
+	}
+	var candidate webrtc.ICECandidateInit
+	if err := json.Unmarshal(raw, &candidate); err != nil {
+		return fmt.Errorf("decode candidate: %w", err)
+	}
+	return p.pc.AddICECandidate(candidate)
+}
+
+// readTrack feeds the human's Opus packets to AudioIn until the track ends
+func (call *Call) readTrack(track *webrtc.TrackRemote) {
+	clock := track.Codec().ClockRate
+	if clock == 0 {
+		clock = uint32(WebRTCAudio.SampleRate)
+	}
+	var first uint32
+	started := false
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		if len(pkt.Payload) == 0 {
+			continue
+		}
+		if !started {
+			first, started = pkt.Timestamp, true
+		}
+		call.audioIn.Offer(AudioFrame{
+			Format:    WebRTCAudio,
+			Opus:      pkt.Payload,
+			Timestamp: time.Duration(pkt.Timestamp-first) * time.Second / time.Duration(clock),
+			Duration:  opusDuration(pkt.Payload),
+		})
+	}
+}
+
+// playAudio is the one reader of AudioOut. Once WebRTC is up it sends the
+// bot's Opus frames to the human in real time, so a bot may write a whole
+// utterance at once and be held back by AudioOut. Until then the last
+// heldAudio of it waits, without blocking the bot, and plays first once
+// the peer connection connects; on calls that never negotiate media it is
+// dropped.
+func (call *Call) playAudio() {
+	frames := make(chan AudioFrame)
+	go func() {
+		defer close(frames)
+		for {
+			frame, err := call.audioOut.ReadFrame()
+			if err != nil {
+				return
+			}
+			select {
+			case frames <- frame:
+			case <-call.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		p       *peer
+		held    []AudioFrame
+		heldFor time.Duration
+		next    time.Time
+		warned  bool
+	)
+	connected := call.connected
+	play := func(frame AudioFrame) bool {
+		if frame.Format.Codec != CodecOpus {
+			if !warned {
+				call.client.logger().Warn("dropping non-Opus audio on WebRTC call", "call_id", call.CallID, "codec", frame.Format.Codec)
+				warned = true
+			}
+			return true
+		}
+		dur := frameDuration(frame)
+		now := time.Now()
+		if next.Before(now) {
+			// First frame, or after a pause: start on time again
+			next = now
+		}
+		if wait := next.Sub(now); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-call.ctx.Done():
+				return false
+			}
+		}
+		next = next.Add(dur)
+		if err := p.track.WriteSample(media.Sample{Data: frame.Opus, Duration: dur}); err != nil {
+			call.client.logger().Debug("audio write failed", "call_id", call.CallID, "err", err)
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-call.ctx.Done():
+			return
+		case <-connected:
+			connected = nil
+			call.mu.Lock()
+			p = call.peer
+			call.mu.Unlock()
+			for _, frame := range held {
+				if !play(frame) {
+					return
+				}
+			}
+			held, heldFor = nil, 0
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			if p != nil {
+				if !play(frame) {
+					return
+				}
+				continue
+			}
+			held = append(held, frame)
+			heldFor += frameDuration(frame)
+			for heldFor > heldAudio && len(held) > 1 {
+				heldFor -= frameDuration(held[0])
+				held = held[1:]
+			}
+		}
+	}
+}
+
+// frameDuration is how much audio frame holds
+func frameDuration(frame AudioFrame) time.Duration {
+	if frame.Duration > 0 {
+		return frame.Duration
+	}
+	return opusDuration(frame.Opus)
+}
+
+// sendDataChannel sends text on the call's data channel if it is open and
+// reports whether it did
+func (call *Call) sendDataChannel(text string) bool {
+	call.mu.Lock()
+	p := call.peer
+	call.mu.Unlock()
+	if p == nil || p.text.ReadyState() != webrtc.DataChannelStateOpen {
+		return false
+	}
+	return p.text.SendText(text) == nil
+}
+
+// opusDuration reads how much audio an Opus packet holds from its TOC
+// byte (RFC 6716, section 3.1); 20 ms if the packet is malformed
+func opusDuration(packet []byte) time.Duration {
+	if len(packet) == 0 {
+		return DefaultFrameDuration
+	}
+	config := packet[0] >> 3
+	var frame time.Duration
+	switch {
+	case config < 12: // SILK
+		frame = [...]time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16: // hybrid
+		frame = [...]time.Duration{10, 20}[config%2] * time.Millisecond
+	default: // CELT
+		frame = [...]time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+	frames := 1
+	switch packet[0] & 3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return DefaultFrameDuration
+		}
+		frames = int(packet[1] & 0x3f)
+	}
+	return time.Duration(frames) * frame
+}
@@ -0,0 +1,289 @@
+package botcall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// loopbackAPI builds peers that only gather host candidates on localhost
+func loopbackAPI() *webrtc.API {
+	var se webrtc.SettingEngine
+	se.SetIncludeLoopbackCandidate(true)
+	se.SetInterfaceFilter(func(name string) bool { return strings.HasPrefix(name, "lo") })
+	se.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	se.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	return webrtc.NewAPI(webrtc.WithSettingEngine(se))
+}
+
+// celt20ms is an Opus packet header for one 20 ms CELT frame
+const celt20ms = 31 << 3
+
+// webrtcHuman is a browser-like peer with a microphone and the text
+// channel, signaling over conn
+type webrtcHuman struct {
+	pc     *webrtc.PeerConnection
+	mic    *webrtc.TrackLocalStaticSample
+	text   *webrtc.DataChannel
+	opened chan struct{}
+	chat   chan string
+	heard  chan []byte
+	hangup chan relayFrame
+}
+
+func newWebRTCHuman(t *testing.T) *webrtcHuman {
+	t.Helper()
+	pc, err := loopbackAPI().NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	h := &webrtcHuman{
+		pc:     pc,
+		opened: make(chan struct{}),
+		chat:   make(chan string, 1),
+		heard:  make(chan []byte, 64),
+		hangup: make(chan relayFrame, 1),
+	}
+	h.mic, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "mic", "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.AddTrack(h.mic); err != nil {
+		t.Fatal(err)
+	}
+	negotiated, id := true, uint16(textChannelID)
+	h.text, err = pc.CreateDataChannel("text", &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.text.OnOpen(func() { close(h.opened) })
+	h.text.OnMessage(func(msg webrtc.DataChannelMessage) { h.chat <- string(msg.Data) })
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			pkt, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			h.heard <- pkt.Payload
+		}
+	})
+	return h
+}
+
+// negotiate offers over conn, applies the bot's answer and then keeps
+// reading conn for trickled candidates and the hangup
+func (h *webrtcHuman) negotiate(t *testing.T, conn *websocket.Conn, callID string) {
+	t.Helper()
+	offer, err := h.pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(h.pc)
+	if err := h.pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	conn.WriteJSON(relayFrame{Type: "offer", CallID: callID, SDP: h.pc.LocalDescription().SDP})
+
+	// The bot answers, then trickles its candidates
+	answer := expectFrame(t, conn, "answer")
+	if err := h.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			var frame relayFrame
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			switch frame.Type {
+			case "candidate":
+				var candidate webrtc.ICECandidateInit
+				json.Unmarshal(frame.Candidate, &candidate)
+				h.pc.AddICECandidate(candidate)
+			case "hangup":
+				h.hangup <- frame
+			}
+		}
+	}()
+}
+
+// hears waits for the human to hear payload
+func (h *webrtcHuman) hears(t *testing.T, payload []byte) {
+	t.Helper()
+	select {
+	case got := <-h.heard:
+		if !bytes.Equal(got, payload) {
+			t.Errorf("Human heard %x, want %x", got, payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No audio reached the human")
+	}
+}
+
+func TestWebRTCLoopback(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	c.WebRTCAPI = loopbackAPI()
+	calls := make(chan *Call, 1)
+	c.OnCall(func(call *Call) { calls <- call })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.ServeRelay(ctx)
+
+	var hub *websocket.Conn
+	select {
+	case hub = <-d.relays:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Bot never opened its relay socket")
+	}
+	defer hub.Close()
+
+	hub.WriteJSON(relayFrame{Type: "call", CallID: "call-1", HumanID: "ann", Mode: "voice"})
+	if frame := expectFrame(t, hub, "accept"); frame.Mode != "voice" {
+		t.Fatalf("Voice call accepted as %q", frame.Mode)
+	}
+	call := <-calls
+	human := newWebRTCHuman(t)
+	human.negotiate(t, hub, "call-1")
+
+	// Human to bot: Opus packets land on AudioIn
+	spoken := []byte{celt20ms, 1, 2, 3}
+	go func() {
+		for call.Context().Err() == nil {
+			human.mic.WriteSample(media.Sample{Data: spoken, Duration: 20 * time.Millisecond})
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	received := make(chan AudioFrame, 1)
+	go func() {
+		frame, err := call.AudioIn().ReadFrame()
+		if err == nil {
+			received <- frame
+		}
+	}()
+	select {
+	case frame := <-received:
+		if !bytes.Equal(frame.Opus, spoken) || frame.Format != WebRTCAudio || frame.Duration != 20*time.Millisecond {
+			t.Errorf("Bot heard %+v", frame)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("No audio reached the bot")
+	}
+
+	// Bot to human: AudioOut frames play on the bot's track
+	said := []byte{celt20ms, 9, 8, 7}
+	go func() {
+		for i := 0; i < 50; i++ {
+			if call.AudioOut().WriteFrame(AudioFrame{Format: WebRTCAudio, Opus: said, Duration: 20 * time.Millisecond}) != nil {
+				return
+			}
+		}
+	}()
+	human.hears(t, said)
+
+	// Text both ways over the data channel, not signaling
+	select {
+	case <-human.opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Text channel never opened")
+	}
+	human.text.SendText("hi")
+	if got := nextText(t, call); got != "hi" {
+		t.Errorf("Received %q, want hi", got)
+	}
+	if err := call.SendText("hello ann"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-human.chat:
+		if got != "hello ann" {
+			t.Errorf("Human read %q on the text channel", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Bot's text never came over the data channel")
+	}
+
+	call.Hangup("")
+	select {
+	case frame := <-human.hangup:
+		if frame.Reason != HangupNormal {
+			t.Errorf("Hung up with %q", frame.Reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Human never heard the hangup")
+	}
+}
+
+func TestWebRTCDirectCall(t *testing.T) {
+	d := newFakeDiscovery(t)
+	c := newTestClient(d, "orion")
+	c.WebRTCAPI = loopbackAPI()
+	// A greeting said before the human has even connected
+	greeting := []byte{celt20ms, 4, 5, 6}
+	calls := make(chan *Call, 1)
+	c.OnCall(func(call *Call) {
+		for i := 0; i < 25; i++ {
+			call.AudioOut().WriteFrame(AudioFrame{Format: WebRTCAudio, Opus: greeting, Duration: 20 * time.Millisecond})
+		}
+		calls <- call
+	})
+	serve(t, context.Background(), d, c)
+	defer c.Close()
+
+	base := "http://" + d.endpoint("orion") + "/call"
+	resp, err := http.Post(base, "application/json", strings.NewReader(`{"human_id":"ann"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var placed struct {
+		CallID string `json:"call_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&placed)
+	resp.Body.Close()
+	call := <-calls
+
+	// Offer, answer and candidates travel on the call socket
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(base, "http")+"/ws?call_id="+placed.CallID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	human := newWebRTCHuman(t)
+	human.negotiate(t, socket, placed.CallID)
+	human.hears(t, greeting)
+
+	call.Hangup("")
+	select {
+	case <-human.hangup:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Human never heard the hangup")
+	}
+}
+
+func TestOpusDuration(t *testing.T) {
+	for _, tc := range []struct {
+		packet []byte
+		want   time.Duration
+	}{
+		{[]byte{celt20ms}, 20 * time.Millisecond},
+		{[]byte{16 << 3}, 2500 * time.Microsecond},    // CELT 2.5 ms
+		{[]byte{3<<3 | 1}, 120 * time.Millisecond},    // SILK 60 ms, two frames
+		{[]byte{13<<3 | 3, 3}, 60 * time.Millisecond}, // hybrid 20 ms, three frames
+		{nil, DefaultFrameDuration},
+	} {
+		if got := opusDuration(tc.packet); got != tc.want {
+			t.Errorf("opusDuration(%x) = %v, want %v", tc.packet, got, tc.want)
+		}
+	}
+}
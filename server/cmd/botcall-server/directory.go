@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// Sort orders for /v1/agents; a leading "-" reverses them
+const (
+	sortID       = "id"
+	sortName     = "name"
+	sortLastSeen = "last_seen"
+)
+
+// AgentQuery selects and orders a page of the agent directory
+type AgentQuery struct {
+	Text     string   // substring of the ID, display name or description
+	Tags     []string // every tag must be present
+	Mode     string   // call mode the agent must accept
+	Language string
+	Codec    string
+	Status   string // online (default), offline or all
+	Sort     string
+	Desc     bool
+	Limit    int
+	After    *agentCursor
+}
+
+// agentCursor is the position of the last agent on a page. It names the
+// sort it belongs to, so it cannot be replayed against another order.
+type agentCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+func (c *agentCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*agentCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var c agentCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+var errInvalidCursor = errors.New("cursor is malformed or belongs to another sort order")
+
+// parseAgentQuery reads the /v1/agents query string. tag may repeat or
+// hold a comma-separated list.
+func parseAgentQuery(values url.Values) (*AgentQuery, error) {
+	q := &AgentQuery{
+		Text:     strings.ToLower(strings.TrimSpace(values.Get("q"))),
+		Mode:     strings.ToLower(values.Get("mode")),
+		Language: values.Get("language"),
+		Codec:    strings.ToLower(values.Get("codec")),
+		Status:   values.Get("status"),
+		Sort:     values.Get("sort"),
+		Limit:    defaultPageSize,
+	}
+	for _, tag := range values["tag"] {
+		for _, t := range splitList(tag) {
+			q.Tags = append(q.Tags, strings.ToLower(t))
+		}
+	}
+
+	switch q.Status {
+	case "":
+		q.Status = "online"
+	case "online", "offline", "all":
+	default:
+		return nil, errors.New("status must be online, offline or all")
+	}
+
+	if strings.HasPrefix(q.Sort, "-") {
+		q.Sort, q.Desc = q.Sort[1:], true
+	}
+	switch q.Sort {
+	case "":
+		q.Sort = sortID
+	case sortID, sortName, sortLastSeen:
+	default:
+		return nil, errors.New("sort must be id, name or last_seen")
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = n
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil || after.Sort != q.Sort || after.Desc != q.Desc {
+			return nil, errInvalidCursor
+		}
+		q.After = after
+	}
+	return q, nil
+}
+
+// sortKey is the agent's position in the query's order; keys compare as
+// strings, with ties broken by ID
+func (q *AgentQuery) sortKey(agent *discovery.Agent) string {
+	switch q.Sort {
+	case sortName:
+		if agent.DisplayName != "" {
+			return strings.ToLower(agent.DisplayName)
+		}
+		return strings.ToLower(agent.ID)
+	case sortLastSeen:
+		return fmt.Sprintf("%020d", agent.LastSeen.UnixNano())
+	}
+	return ""
+}
+
+// Match reports whether the agent passes every filter
+func (q *AgentQuery) Match(agent *discovery.Agent) bool {
+	if q.Status == "online" && !agent.Online || q.Status == "offline" && agent.Online {
+		return false
+	}
+	if q.Text != "" &&
+		!strings.Contains(strings.ToLower(agent.ID), q.Text) &&
+		!strings.Contains(strings.ToLower(agent.DisplayName), q.Text) &&
+		!strings.Contains(strings.ToLower(agent.Description), q.Text) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !contains(agent.Tags, tag) {
+			return false
+		}
+	}
+	if q.Mode != "" && !agent.Supports(q.Mode) {
+		return false
+	}
+	if q.Language != "" && !hasLanguage(agent.Languages, q.Language) {
+		return false
+	}
+	if q.Codec != "" && !contains(agent.Codecs, q.Codec) {
+		return false
+	}
+	return true
+}
+
+// Page filters and orders agents and returns the page after q.After,
+// the total number of matches and the cursor for the next page, if any
+func (q *AgentQuery) Page(agents []*discovery.Agent) (page []*discovery.Agent, total int, next string) {
+	type entry struct {
+		key   string
+		agent *discovery.Agent
+	}
+	var matched []entry
+	for _, agent := range agents {
+		if q.Match(agent) {
+			matched = append(matched, entry{q.sortKey(agent), agent})
+		}
+	}
+	less := func(aKey, aID, bKey, bID string) bool {
+		if aKey != bKey {
+			return aKey < bKey != q.Desc
+		}
+		return aID < bID != q.Desc
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return less(matched[i].key, matched[i].agent.ID, matched[j].key, matched[j].agent.ID)
+	})
+
+	start := 0
+	if q.After != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return less(q.After.Key, q.After.ID, matched[i].key, matched[i].agent.ID)
+		})
+	}
+	end := start + q.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	for _, e := range matched[start:end] {
+		page = append(page, e.agent)
+	}
+	if end < len(matched) {
+		last := matched[end-1]
+		next = (&agentCursor{Sort: q.Sort, Desc: q.Desc, Key: last.key, ID: last.agent.ID}).encode()
+	}
+	return page, len(matched), next
+}
+
+func contains(items []string, want string) bool {
+	for _, item := range items {
+		if item == want {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLanguage matches BCP 47 tags by prefix, so "en" finds "en-US"
+func hasLanguage(languages []string, want string) bool {
+	for _, lang := range languages {
+		if strings.EqualFold(lang, want) || len(lang) > len(want) &&
+			strings.EqualFold(lang[:len(want)], want) && lang[len(want)] == '-' {
+			return true
+		}
+	}
+	return false
+}
+
+// AgentPage is the /v1/agents response
+type AgentPage struct {
+	Agents []*discovery.Agent `json:"agents"`
+	// Count is the number of agents on this page, Total the number
+	// matching the query
+	Count      int    `json:"count"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// listAgents serves the agent directory. See parseAgentQuery for the
+// query parameters; without any it lists the first page of online agents
+// by ID.
+func (s *Server) listAgents(w http.ResponseWriter, r *http.Request) {
+	q, err := parseAgentQuery(r.URL.Query())
+	if err == errInvalidCursor {
+		writeError(w, http.StatusBadRequest, "invalid_cursor", err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	agents := s.store.ListOnline()
+	if q.Status != "online" {
+		agents = s.store.List()
+	}
+	page, total, next := q.Page(agents)
+	for i, agent := range page {
+		page[i] = agent.Public()
+		if agent.Mode == modeRelay {
+			page[i].Endpoint = relayEndpoint(r, agent.ID)
+		}
+	}
+	if page == nil {
+		page = []*discovery.Agent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AgentPage{
+		Agents:     page,
+		Count:      len(page),
+		Total:      total,
+		NextCursor: next,
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+)
+
+// agentIDs fetches a /v1/agents page and returns its IDs, next cursor
+// and total
+func agentIDs(t *testing.T, ts *httptest.Server, query url.Values) ([]string, string, float64) {
+	t.Helper()
+	status, body := doJSON(t, http.MethodGet, ts.URL+"/v1/agents?"+query.Encode(), nil, nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET /v1/agents?%s returned %d %v", query.Encode(), status, body)
+	}
+	var ids []string
+	agents, _ := body["agents"].([]interface{})
+	for _, a := range agents {
+		ids = append(ids, a.(map[string]interface{})["agent_id"].(string))
+	}
+	next, _ := body["next_cursor"].(string)
+	total, _ := body["total"].(float64)
+	return ids, next, total
+}
+
+func newDirectoryServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	store := discovery.NewDiscoveryStore()
+	base := time.Now().Add(-time.Minute)
+	agents := []*discovery.Agent{
+		{ID: "orion", Profile: discovery.Profile{DisplayName: "Orion", Description: "Stargazing guide",
+			Modes: []string{"voice", "text"}, Languages: []string{"en-US"}, Tags: []string{"astronomy", "science"}}},
+		{ID: "vega", Profile: discovery.Profile{DisplayName: "Astra", Modes: []string{"text"},
+			Languages: []string{"fr-FR"}, Codecs: []string{"opus"}, Tags: []string{"science"}}},
+		{ID: "lyra", Profile: discovery.Profile{Description: "Music tutor", Tags: []string{"music"}}},
+		{ID: "draco", Profile: discovery.Profile{DisplayName: "Draco"}},
+	}
+	for i, agent := range agents {
+		agent.Endpoint = "10.0.0.1:9000"
+		agent.Mode = "direct"
+		agent.Online = agent.ID != "draco"
+		agent.LastSeen = base.Add(time.Duration(i) * time.Second)
+		if err := store.Register(agent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newTestServer(t, Config{Store: store})
+}
+
+func TestAgentDirectoryFilters(t *testing.T) {
+	ts := newDirectoryServer(t)
+
+	tests := []struct {
+		query url.Values
+		want  string
+	}{
+		{url.Values{}, "lyra,orion,vega"},
+		{url.Values{"status": {"offline"}}, "draco"},
+		{url.Values{"status": {"all"}}, "draco,lyra,orion,vega"},
+		{url.Values{"q": {"GUIDE"}}, "orion"},
+		{url.Values{"q": {"astra"}}, "vega"},
+		{url.Values{"tag": {"science"}}, "orion,vega"},
+		{url.Values{"tag": {"science,astronomy"}}, "orion"},
+		{url.Values{"tag": {"science", "music"}}, ""},
+		{url.Values{"mode": {"voice"}}, "lyra,orion"}, // lyra lists no modes
+		{url.Values{"language": {"fr"}}, "vega"},
+		{url.Values{"codec": {"opus"}}, "vega"},
+		{url.Values{"sort": {"name"}}, "vega,lyra,orion"},
+		{url.Values{"sort": {"-last_seen"}}, "lyra,vega,orion"},
+		{url.Values{"sort": {"-id"}, "status": {"all"}}, "vega,orion,lyra,draco"},
+	}
+	for _, tt := range tests {
+		ids, _, total := agentIDs(t, ts, tt.query)
+		if got := strings.Join(ids, ","); got != tt.want {
+			t.Errorf("?%s = %q, want %q", tt.query.Encode(), got, tt.want)
+		}
+		if int(total) != len(ids) {
+			t.Errorf("?%s total = %v, want %d", tt.query.Encode(), total, len(ids))
+		}
+	}
+}
+
+func TestAgentDirectoryPagination(t *testing.T) {
+	ts := newDirectoryServer(t)
+	query := url.Values{"status": {"all"}, "sort": {"name"}, "limit": {"3"}}
+
+	first, cursor, total := agentIDs(t, ts, query)
+	if strings.Join(first, ",") != "vega,draco,lyra" || total != 4 || cursor == "" {
+		t.Fatalf("first page = %v, total %v, cursor %q", first, total, cursor)
+	}
+
+	// A new agent sorting before the cursor does not shift the next page
+	register(t, ts, RegisterRequest{AgentID: "aaa", Endpoint: "10.0.0.2:9000"})
+
+	query.Set("cursor", cursor)
+	second, cursor, _ := agentIDs(t, ts, query)
+	if strings.Join(second, ",") != "orion" || cursor != "" {
+		t.Fatalf("second page = %v, cursor %q", second, cursor)
+	}
+
+	query.Set("sort", "id")
+	if status, body := doJSON(t, http.MethodGet, ts.URL+"/v1/agents?"+query.Encode(), nil, nil); status != http.StatusBadRequest || body["error"] != "invalid_cursor" {
+		t.Errorf("Cursor replayed against another sort returned %d %v", status, body)
+	}
+}
+
+func TestAgentDirectoryRejectsBadQueries(t *testing.T) {
+	ts := newDirectoryServer(t)
+	for _, query := range []string{"limit=0", "limit=1000", "sort=rank", "status=away", "cursor=%21%21"} {
+		status, body := doJSON(t, http.MethodGet, ts.URL+"/v1/agents?"+query, nil, nil)
+		if status != http.StatusBadRequest {
+			t.Errorf("?%s returned %d %v", query, status, body)
+		}
+	}
+}
@@ -6,89 +6,272 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/TheOrionAI/botcall-server/internal/attest"
+	"github.com/TheOrionAI/botcall-server/internal/balance"
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+	"github.com/TheOrionAI/botcall-server/internal/ice"
+	"github.com/TheOrionAI/botcall-server/internal/logging"
+	"github.com/TheOrionAI/botcall-server/internal/metrics"
+	"github.com/TheOrionAI/botcall-server/internal/probe"
+	"github.com/TheOrionAI/botcall-server/internal/signaling"
+	"github.com/TheOrionAI/botcall-server/internal/tracing"
+	"github.com/TheOrionAI/botcall-server/internal/turnserver"
 )
 
-// Agent represents a registered bot
-type Agent struct {
-	ID          string    `json:"agent_id"`
-	Endpoint    string    `json:"endpoint"`
-	Mode        string    `json:"mode"` // direct, relay, nat-pending
-	Attestation string    `json:"attestation"`
-	Online      bool      `json:"online"`
-	LastSeen    time.Time `json:"last_seen"`
+var (
+	storeKind = flag.String("store", envOr("BOTCALL_STORE", "memory"), "Registry backend: memory or file")
+	dataDir   = flag.String("data-dir", envOr("BOTCALL_DATA_DIR", "data"), "Directory for the file store")
+
+	logLevel  = flag.String("log-level", envOr("BOTCALL_LOG_LEVEL", "info"), "Log level: debug, info, warn or error")
+	logFormat = flag.String("log-format", envOr("BOTCALL_LOG_FORMAT", logging.FormatText), "Log format: text or json")
+
+	traceExporter = flag.String("trace-exporter", envOr("BOTCALL_TRACE_EXPORTER", tracing.ExporterNone), "OpenTelemetry span exporter: none, stdout, file or otlp")
+	traceFile     = flag.String("trace-file", envOr("BOTCALL_TRACE_FILE", "traces.jsonl"), "File the file exporter appends spans to")
+	traceEndpoint = flag.String("trace-endpoint", os.Getenv("BOTCALL_TRACE_ENDPOINT"), "OTLP/HTTP collector URL, e.g. http://localhost:4318 (default: OTEL_EXPORTER_OTLP_ENDPOINT)")
+	traceSample   = flag.Float64("trace-sample", envFloat("BOTCALL_TRACE_SAMPLE", 1), "Share of new traces recorded, 0 to 1")
+
+	presenceTTL = flag.Duration("presence-ttl", envDuration("BOTCALL_PRESENCE_TTL", discovery.DefaultPresenceTTL), "Time without a keepalive before an agent goes offline")
+	retention   = flag.Duration("retention", envDuration("BOTCALL_RETENTION", discovery.DefaultRetention), "Time an offline agent is kept before eviction")
+
+	probeInterval = flag.Duration("probe-interval", envDuration("BOTCALL_PROBE_INTERVAL", probe.DefaultInterval), "Time between /health probes of each direct-mode bot; 0 disables probing")
+	probeJitter   = flag.Duration("probe-jitter", envDuration("BOTCALL_PROBE_JITTER", 0), "Most a probe is delayed at random (default: a fifth of -probe-interval)")
+	probeTimeout  = flag.Duration("probe-timeout", envDuration("BOTCALL_PROBE_TIMEOUT", probe.DefaultTimeout), "Time a bot has to answer a /health probe")
+	probeFailures = flag.Int("probe-failures", envInt("BOTCALL_PROBE_FAILURES", probe.DefaultFailureThreshold), "Failed probes in a row before a bot is degraded")
+	probeWorkers  = flag.Int("probe-workers", envInt("BOTCALL_PROBE_WORKERS", probe.DefaultWorkers), "Most /health probes in flight at once")
+	probePrivate  = flag.Bool("probe-private", os.Getenv("BOTCALL_PROBE_PRIVATE") == "true", "Probe bots on loopback, private and link-local addresses")
+
+	balancePolicy = flag.String("balance", envOr("BOTCALL_BALANCE", discovery.BalanceLeastCalls), "Default policy for picking among an agent's instances: least_calls, round_robin or sticky")
+
+	attestIssuers  = flag.String("attest-issuers", os.Getenv("BOTCALL_ATTEST_ISSUERS"), "Comma-separated trusted attestation issuers; empty disables verification")
+	attestJWKS     = flag.String("attest-jwks", os.Getenv("BOTCALL_ATTEST_JWKS"), "Local JWKS file with issuer keys (default: fetch <issuer>/.well-known/jwks.json)")
+	attestAudience = flag.String("attest-audience", envOr("BOTCALL_ATTEST_AUDIENCE", attest.DefaultAudience), "Required aud claim in attestation tokens")
+
+	adminToken = flag.String("admin-token", os.Getenv("BOTCALL_ADMIN_TOKEN"), "Bearer token for /v1/admin endpoints; empty disables them")
+
+	stunURLs   = flag.String("stun-urls", envOr("BOTCALL_STUN_URLS", ice.DefaultSTUN), "Comma-separated STUN URLs handed to peers")
+	turnURLs   = flag.String("turn-urls", os.Getenv("BOTCALL_TURN_URLS"), "Comma-separated TURN URLs handed to peers")
+	turnSecret = flag.String("turn-secret", os.Getenv("BOTCALL_TURN_SECRET"), "Secret shared with the TURN server for REST API credentials")
+	turnTTL    = flag.Duration("turn-ttl", envDuration("BOTCALL_TURN_TTL", ice.DefaultTTL), "Lifetime of issued TURN credentials")
+
+	turnListen          = flag.String("turn-listen", os.Getenv("BOTCALL_TURN_LISTEN"), "UDP/TCP address for the embedded STUN/TURN server, e.g. :3478; empty disables it")
+	turnPublicIP        = flag.String("turn-public-ip", os.Getenv("BOTCALL_TURN_PUBLIC_IP"), "Public IP the embedded TURN server advertises for relays")
+	turnRealm           = flag.String("turn-realm", envOr("BOTCALL_TURN_REALM", turnserver.DefaultRealm), "Realm of the embedded TURN server")
+	turnRelayPorts      = flag.String("turn-relay-ports", envOr("BOTCALL_TURN_RELAY_PORTS", "49152-65535"), "UDP port range (min-max) for TURN relays")
+	turnUserAllocations = flag.Int("turn-user-allocations", envInt("BOTCALL_TURN_USER_ALLOCATIONS", turnserver.DefaultMaxUserAllocations), "Concurrent TURN allocations per user; negative is unlimited")
+	turnAllocationBytes = flag.Int64("turn-allocation-bytes", int64(envInt("BOTCALL_TURN_ALLOCATION_BYTES", turnserver.DefaultMaxAllocationBytes)), "Bytes one TURN allocation may relay; negative is unlimited")
+)
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
 }
 
-// DiscoveryStore holds registered agents
-type DiscoveryStore struct {
-	mu     sync.RWMutex
-	agents map[string]*Agent
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("ignoring invalid environment variable", "key", key, "value", value, "err", err)
+		return fallback
+	}
+	return d
 }
 
-func NewDiscoveryStore() *DiscoveryStore {
-	return &DiscoveryStore{
-		agents: make(map[string]*Agent),
+func envFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
 	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Warn("ignoring invalid environment variable", "key", key, "value", value, "err", err)
+		return fallback
+	}
+	return f
 }
 
-func (s *DiscoveryStore) Register(agent *Agent) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.agents[agent.ID] = agent
-	log.Printf("Registered agent: %s at %s", agent.ID, agent.Endpoint)
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("ignoring invalid environment variable", "key", key, "value", value, "err", err)
+		return fallback
+	}
+	return n
 }
 
-func (s *DiscoveryStore) Lookup(agentID string) (*Agent, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	agent, ok := s.agents[agentID]
-	return agent, ok
+// openStore builds the registry backend selected by -store
+func openStore(kind, dir string) (discovery.Store, error) {
+	switch kind {
+	case "memory":
+		return discovery.NewDiscoveryStore(), nil
+	case "file":
+		return discovery.OpenFileStore(dir)
+	default:
+		return nil, fmt.Errorf("unknown store %q (want memory or file)", kind)
+	}
 }
 
-func (s *DiscoveryStore) ListOnline() []*Agent {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	
-	var online []*Agent
-	for _, agent := range s.agents {
-		if agent.Online && time.Since(agent.LastSeen) < 6*time.Minute {
-			online = append(online, agent)
+// splitList parses a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
 		}
 	}
-	return online
+	return out
 }
 
-func (s *DiscoveryStore) Touch(agentID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if agent, ok := s.agents[agentID]; ok {
-		agent.LastSeen = time.Now()
-		agent.Online = true
+// newVerifier builds the attestation verifier from flags, or returns nil
+// when no trusted issuers are configured
+func newVerifier() (*attest.Verifier, error) {
+	issuers := splitList(*attestIssuers)
+	if len(issuers) == 0 {
+		if *attestJWKS != "" {
+			return nil, fmt.Errorf("-attest-jwks needs -attest-issuers")
+		}
+		return nil, nil
 	}
+	return attest.New(attest.Config{
+		Issuers:  issuers,
+		JWKSFile: *attestJWKS,
+		Audience: *attestAudience,
+		Leeway:   time.Minute,
+	})
+}
+
+// Config wires a Server's dependencies
+type Config struct {
+	Store      discovery.Store
+	Verifier   *attest.Verifier   // nil when attestation is not enforced
+	AdminToken string             // empty disables the admin API
+	ICE        *ice.Provider      // nil offers the default STUN server only
+	TURN       *turnserver.Server // nil when the embedded TURN server is off
+	// PresenceTTL is how long an instance counts as live without a
+	// keepalive; zero means discovery.DefaultPresenceTTL
+	PresenceTTL time.Duration
+	// Balance is the instance policy for agents that set none; empty
+	// means discovery.BalanceLeastCalls
+	Balance string
+	// Prober supplies health probe results; nil disables health checks
+	Prober *probe.Prober
+	// Logger receives request logs; nil means slog.Default()
+	Logger *slog.Logger
 }
 
 // Server handles HTTP and WebSocket
 type Server struct {
-	store *DiscoveryStore
-	upgrader websocket.Upgrader
+	store      discovery.Store
+	verifier   *attest.Verifier
+	adminToken string
+	upgrader   websocket.Upgrader
+	hub        *signaling.Hub
+	ice        *ice.Provider
+	turn       *turnserver.Server
+	balancer   *balance.Balancer
+	prober     *probe.Prober
+	metrics    *metrics.Metrics
+	logger     *slog.Logger
+
+	presenceTTL    time.Duration
+	defaultBalance string
+
+	// bootID changes on every start, so bots can tell discovery restarted
+	// and may have lost their registration
+	bootID string
+
+	// pingInterval paces WebSocket heartbeats
+	pingInterval time.Duration
+
+	// regMu makes the ownership check and the write that follows atomic
+	regMu sync.Mutex
 }
 
-func NewServer() *Server {
-	return &Server{
-		store: NewDiscoveryStore(),
+func NewServer(cfg Config) *Server {
+	if cfg.ICE == nil {
+		cfg.ICE = ice.New(ice.Config{})
+	}
+	if cfg.PresenceTTL <= 0 {
+		cfg.PresenceTTL = discovery.DefaultPresenceTTL
+	}
+	if cfg.Balance == "" {
+		cfg.Balance = discovery.BalanceLeastCalls
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	s := &Server{
+		store:      cfg.Store,
+		verifier:   cfg.Verifier,
+		adminToken: cfg.AdminToken,
+		hub:        signaling.NewHub(),
+		ice:        cfg.ICE,
+		turn:       cfg.TURN,
+		balancer:   balance.New(),
+		prober:     cfg.Prober,
+		metrics:    metrics.New(cfg.Store),
+		logger:     cfg.Logger,
+
+		presenceTTL:    cfg.PresenceTTL,
+		defaultBalance: cfg.Balance,
+		bootID:         strconv.FormatInt(time.Now().UnixNano(), 36),
+
+		pingInterval: 30 * time.Second,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true }, // TODO: restrict in production
 		},
 	}
+	s.hub.Admit = s.admitCall
+	if cfg.TURN != nil {
+		s.metrics.RegisterTURN(cfg.TURN)
+	}
+	return s
+}
+
+// Handler returns the server's routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/register", s.handleRegister)
+	mux.HandleFunc("/v1/register/", s.handleDeregister)
+	mux.HandleFunc("/v1/lookup/", s.handleLookup)
+	mux.HandleFunc("/v1/ws", s.handleWebSocket)
+	mux.HandleFunc("/v1/call/", s.handleRelayCall)
+	mux.HandleFunc("/t/", s.handleTunnel)
+	mux.HandleFunc("/v1/agents", s.listAgents)
+	mux.HandleFunc("/v1/presence/stream", s.handlePresenceStream)
+	mux.HandleFunc("/v1/presence/", s.handleSetPresence)
+	mux.HandleFunc("/v1/admin/transfer", s.handleAdminTransfer)
+	mux.HandleFunc("/v1/admin/turn", s.handleTURNStats)
+	mux.HandleFunc("/v1/ice-servers", s.handleICEServers)
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.Handle("/metrics", s.metrics.Handler())
+	return logging.Middleware(s.logger,
+		tracing.Instrument(mux, s.metrics.Instrument(mux, "/v1/presence/stream"), "/v1/presence/stream"))
 }
 
 // RegisterRequest from bots
@@ -97,12 +280,31 @@ type RegisterRequest struct {
 	Endpoint    string `json:"endpoint"`
 	Mode        string `json:"mode"` // direct, relay
 	Attestation string `json:"attestation"`
+	// RegistrationKey proves ownership of an agent ID claimed without
+	// attestation. Bots may pick their own on first registration.
+	RegistrationKey string `json:"registration_key,omitempty"`
+
+	// InstanceID tells replicas sharing the agent ID apart; registrations
+	// without one replace each other
+	InstanceID string `json:"instance_id,omitempty"`
+	// Weight is this instance's share under round_robin
+	Weight int `json:"weight,omitempty"`
+	// ActiveCalls is how many calls this instance is on right now
+	ActiveCalls int `json:"active_calls,omitempty"`
+	// Balance picks the policy lookups use across instances
+	Balance string `json:"balance,omitempty"`
+
+	// Display name, modes, tags and the rest of the bot card
+	discovery.Profile
 }
 
 type RegisterResponse struct {
 	Confirmed bool   `json:"confirmed"`
 	URL       string `json:"url,omitempty"`
 	Status    string `json:"status"`
+	// RegistrationKey is set only when the server generated the key that
+	// now owns the ID; the bot must send it on every later registration
+	RegistrationKey string `json:"registration_key,omitempty"`
 }
 
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -110,6 +312,8 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	result := metrics.ResultRejected
+	defer func() { s.metrics.Registrations.WithLabelValues(result).Inc() }()
 
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -117,47 +321,283 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate required fields
-	if req.AgentID == "" || req.Endpoint == "" {
+	// Validate required fields; relay bots are reached through their
+	// socket and need no endpoint
+	if req.AgentID == "" || (req.Endpoint == "" && req.Mode != modeRelay) {
 		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
 
-	// TODO: Verify BotAuth attestation
-	// For now, accept all
+	req.Profile.Normalize()
+	if err := req.Profile.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_profile", err.Error())
+		return
+	}
+
+	now := time.Now()
+	if req.InstanceID == "" {
+		req.InstanceID = discovery.DefaultInstance
+	}
+	instance := discovery.Instance{
+		ID:                 req.InstanceID,
+		Endpoint:           req.Endpoint,
+		Mode:               req.Mode,
+		Weight:             req.Weight,
+		MaxConcurrentCalls: req.MaxConcurrentCalls,
+		ActiveCalls:        req.ActiveCalls,
+		LastSeen:           now,
+	}
+	if err := instance.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_instance", err.Error())
+		return
+	}
+	if req.Balance != "" && !discovery.ValidBalance(req.Balance) {
+		writeError(w, http.StatusBadRequest, "invalid_instance", "balance must be least_calls, round_robin or sticky")
+		return
+	}
+
+	logger := logging.FromContext(r.Context()).With("agent_id", req.AgentID)
+	claims, err := s.verifyAttestation(r.Context(), req.AgentID, req.Attestation)
+	if err != nil {
+		logger.Warn("rejected registration", "err", err)
+		writeAttestationError(w, err)
+		return
+	}
 
-	agent := &Agent{
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+
+	existing, _ := s.store.Lookup(req.AgentID)
+	owner, issuedKey, err := claimOwnership(existing, req.RegistrationKey, claims)
+	if err != nil {
+		logger.Warn("rejected registration", "err", err)
+		writeOwnershipError(w, err)
+		return
+	}
+	// The hub holds one bot socket per agent ID, so a second relay
+	// instance would take the first one's calls
+	if req.Mode == modeRelay && existing != nil && existing.Online {
+		for _, inst := range existing.LiveInstances(now.Add(-s.presenceTTL)) {
+			if inst.Mode == modeRelay && inst.ID != instance.ID {
+				logger.Warn("rejected registration", "instance_id", instance.ID, "relay_instance", inst.ID)
+				writeError(w, http.StatusConflict, "relay_instance_conflict",
+					"instance "+inst.ID+" of "+req.AgentID+" already uses the relay; relay agents run one instance")
+				return
+			}
+		}
+	}
+
+	agent := &discovery.Agent{
 		ID:          req.AgentID,
 		Endpoint:    req.Endpoint,
 		Mode:        req.Mode,
 		Attestation: req.Attestation,
 		Online:      true,
-		LastSeen:    time.Now(),
+		LastSeen:    now,
+		Owner:       owner,
+		Balance:     req.Balance,
+		Profile:     req.Profile,
+	}
+	if existing != nil {
+		// Keepalive registrations leave the presence the bot set alone
+		agent.Presence = existing.Presence
+		agent.StatusMessage = existing.StatusMessage
+		agent.PresenceExpires = existing.PresenceExpires
+		// Sibling instances stay until they miss the presence TTL; an
+		// offline entry's instances are all gone
+		if existing.Online {
+			agent.Instances = existing.Instances
+		}
+		if agent.Balance == "" {
+			agent.Balance = existing.Balance
+		}
+	}
+	agent.PutInstance(instance, now.Add(-s.presenceTTL))
+	if claims != nil {
+		agent.AttestationValid = true
+		agent.AttestationExpires = claims.ExpiresAt
 	}
 
-	s.store.Register(agent)
+	if err := s.store.Register(agent); err != nil {
+		logger.Error("register failed", "err", err)
+		http.Error(w, "Registration failed", http.StatusInternalServerError)
+		result = metrics.ResultError
+		return
+	}
+	s.balancer.Reported(agent.ID, instance.ID)
+	result = metrics.ResultAccepted
 
 	resp := RegisterResponse{
-		Confirmed: true,
-		URL:       fmt.Sprintf("wss://%s/v1/call/%s", r.Host, agent.ID),
-		Status:    "online",
+		Confirmed:       true,
+		URL:             fmt.Sprintf("wss://%s/v1/call/%s", r.Host, agent.ID),
+		Status:          "online",
+		RegistrationKey: issuedKey,
+	}
+	if agent.Mode == modeRelay {
+		// Relay bots dial back here and keep the socket open
+		resp.URL = relaySocketURL(r, agent.ID)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// handleDeregister takes an agent offline at the owner's request. The
+// entry and its ownership claim stay until the reaper evicts it, so the ID
+// cannot be grabbed the moment its bot shuts down. With ?instance= only
+// that instance leaves; the agent goes offline with its last instance.
+func (s *Server) handleDeregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agentID := strings.TrimPrefix(r.URL.Path, "/v1/register/")
+	if agentID == "" {
+		http.Error(w, "Missing agent ID", http.StatusBadRequest)
+		return
+	}
+
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+
+	agent, ok := s.store.Lookup(agentID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "agent "+agentID+" is not registered")
+		return
+	}
+	logger := logging.FromContext(r.Context()).With("agent_id", agentID)
+	if err := s.authorizeOwner(r, agent); err != nil {
+		logger.Warn("rejected deregistration", "err", err)
+		if _, isAttest := err.(*attest.Error); isAttest {
+			writeAttestationError(w, err)
+		} else {
+			writeOwnershipError(w, err)
+		}
+		return
+	}
+
+	if instanceID := r.URL.Query().Get("instance"); instanceID != "" {
+		if !agent.RemoveInstance(instanceID) {
+			writeError(w, http.StatusNotFound, "not_found", "instance "+instanceID+" of "+agentID+" is not registered")
+			return
+		}
+		remaining := 0
+		cutoff := time.Now().Add(-s.presenceTTL)
+		for _, inst := range agent.Instances {
+			if !inst.LastSeen.Before(cutoff) {
+				remaining++
+			}
+		}
+		if agent.Online && remaining > 0 {
+			if err := s.store.Register(agent); err != nil {
+				logger.Error("deregister failed", "instance_id", instanceID, "err", err)
+				http.Error(w, "Deregistration failed", http.StatusInternalServerError)
+				return
+			}
+			logger.Info("instance deregistered", "instance_id", instanceID, "remaining", remaining)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"agent_id":    agentID,
+				"instance_id": instanceID,
+				"status":      "online",
+				"instances":   remaining,
+			})
+			return
+		}
+	}
+
+	// The last keepalive is necessarily before now, so this always applies
+	if _, err := s.store.MarkOffline(agentID, time.Now()); err != nil {
+		logger.Error("deregister failed", "err", err)
+		http.Error(w, "Deregistration failed", http.StatusInternalServerError)
+		return
+	}
+	s.balancer.Forget(agentID)
+
+	logger.Info("agent deregistered")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"agent_id": agentID,
+		"status":   "offline",
+	})
+}
+
+// verifyAttestation checks a bot's attestation token. Without a verifier
+// every registration is accepted and reported as unverified.
+func (s *Server) verifyAttestation(ctx context.Context, agentID, token string) (*attest.Claims, error) {
+	if s.verifier == nil {
+		return nil, nil
+	}
+	ctx, span := tracing.Start(ctx, "attest.verify", attribute.String("botcall.agent_id", agentID))
+	claims, err := s.verifier.Verify(ctx, token, agentID)
+	tracing.End(span, err)
+	if err != nil {
+		code := attest.CodeMalformed
+		var attErr *attest.Error
+		if errors.As(err, &attErr) {
+			code = attErr.Code
+		}
+		s.metrics.AttestationFailures.WithLabelValues(code).Inc()
+	}
+	return claims, err
+}
+
+func writeAttestationError(w http.ResponseWriter, err error) {
+	var attErr *attest.Error
+	if !errors.As(err, &attErr) {
+		attErr = &attest.Error{Code: attest.CodeMalformed, Message: err.Error()}
+	}
+	status := http.StatusUnauthorized
+	if attErr.Code == attest.CodeKeysUnavailable {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(attErr)
+}
+
 // LookupResponse for humans
 type LookupResponse struct {
-	Status            string `json:"status"`
-	Endpoint          string `json:"endpoint,omitempty"`
-	Mode              string `json:"mode,omitempty"`
-	AttestationValid  bool   `json:"attestation_valid"`
-	LastSeen          string `json:"last_seen,omitempty"`
-	Error             string `json:"error,omitempty"`
+	Status           string `json:"status"`
+	Endpoint         string `json:"endpoint,omitempty"`
+	Mode             string `json:"mode,omitempty"`
+	AttestationValid bool   `json:"attestation_valid"`
+	LastSeen         string `json:"last_seen,omitempty"`
+	Error            string `json:"error,omitempty"`
+
+	// Presence is available, busy, dnd, in_call or offline; Status folds
+	// it into online, busy or offline
+	Presence        string `json:"presence,omitempty"`
+	StatusMessage   string `json:"status_message,omitempty"`
+	PresenceExpires string `json:"presence_expires,omitempty"`
+	// Message explains a busy status to the human
+	Message string `json:"message,omitempty"`
+	// RetryAfter is when, in seconds, a busy agent expects to be free
+	RetryAfter int64 `json:"retry_after,omitempty"`
+
+	// InstanceID is the instance Endpoint leads to, and Instances how many
+	// live instances the agent has
+	InstanceID string `json:"instance_id,omitempty"`
+	Instances  int    `json:"instances,omitempty"`
+	// Health is the probe history of that instance, when it is probed
+	Health *probe.Health `json:"health,omitempty"`
+
+	discovery.Profile
 }
 
+// handleLookup serves GET /v1/lookup/{id}[?human=]. For online agents it
+// picks the instance to call under the agent's balance policy. Only
+// lookups naming the human count as callers; sticky balancing keys on the
+// human ID.
 func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	// The PWA tags lookups with X-Request-ID, which needs a preflight
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", logging.RequestIDHeader+", traceparent, tracestate")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 	agentID := r.URL.Path[len("/v1/lookup/"):]
 	if agentID == "" {
 		http.Error(w, "Missing agent ID", http.StatusBadRequest)
@@ -166,6 +606,7 @@ func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
 
 	agent, ok := s.store.Lookup(agentID)
 	if !ok {
+		s.metrics.Lookups.WithLabelValues(metrics.LookupMiss).Inc()
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(LookupResponse{
 			Status: "offline",
@@ -173,20 +614,54 @@ func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	s.metrics.Lookups.WithLabelValues(metrics.LookupHit).Inc()
 
-	// Check if still online (5 min timeout)
-	isOnline := agent.Online && time.Since(agent.LastSeen) < 5*time.Minute
-
+	presence := s.presenceMessage(agent.ID, agent)
 	resp := LookupResponse{
-		Status:           "online",
+		Status:           presence.Status,
 		Endpoint:         agent.Endpoint,
 		Mode:             agent.Mode,
-		AttestationValid: true, // TODO: verify
-		LastSeen:         agent.LastSeen.Format(time.RFC3339),
+		AttestationValid: agent.AttestationCurrent(time.Now()),
+		LastSeen:         presence.LastSeen,
+		Presence:         presence.Presence,
+		StatusMessage:    presence.StatusMessage,
+		PresenceExpires:  presence.PresenceExpires,
+		Profile:          agent.Profile,
+	}
+	if resp.Status == statusOnline {
+		now := time.Now()
+		live := agent.LiveInstances(now.Add(-s.presenceTTL))
+		resp.Instances = len(live)
+		candidates := s.healthyInstances(agent.ID, live)
+		if len(candidates) == 0 {
+			// Still hand out an endpoint; the bot may only be firewalled
+			// from us
+			resp.Status = statusDegraded
+			resp.Message = "bot is not answering health checks"
+			candidates = live
+		}
+		if inst, ok := s.balancer.Pick(agent.ID, s.balanceFor(agent), r.URL.Query().Get("human"), candidates, now); ok {
+			resp.InstanceID = inst.ID
+			resp.Endpoint, resp.Mode = inst.Endpoint, inst.Mode
+			resp.Health = s.instanceHealth(agent.ID, inst.ID)
+		} else {
+			// Every instance reports a full load of direct calls
+			resp.Status, resp.Presence = statusBusy, discovery.PresenceInCall
+			resp.StatusMessage, resp.PresenceExpires = "", ""
+		}
+	}
+	if resp.Mode == modeRelay {
+		resp.Endpoint = relayEndpoint(r, agent.ID)
 	}
-	
-	if !isOnline {
-		resp.Status = "offline"
+	if resp.Status == statusBusy {
+		resp.Message = "bot is busy, try later"
+		if presence.StatusMessage != "" {
+			resp.Message = "bot is busy (" + presence.StatusMessage + "), try later"
+		}
+		if presence.PresenceExpires != "" {
+			resp.RetryAfter = int64(time.Until(agent.PresenceExpires).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.FormatInt(resp.RetryAfter, 10))
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -196,63 +671,262 @@ func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
-		"version": "0.1.0",
+		"status":  "ok",
+		"version": version,
+		"boot_id": s.bootID,
 	})
 }
 
+// handleICEServers returns STUN servers and short-lived TURN credentials.
+// TURN relays traffic for whoever holds credentials, so they go only to a
+// bot proving ownership of ?agent= or a human on the signaling call
+// ?call_id=; anyone else gets STUN alone. ?user= names the holder, for
+// the TURN server's logs.
+func (s *Server) handleICEServers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !s.mayUseTURN(r) {
+		json.NewEncoder(w).Encode(s.ice.STUN())
+		return
+	}
+	json.NewEncoder(w).Encode(s.ice.Issue(r.URL.Query().Get("user"), time.Now()))
+}
+
+// mayUseTURN reports whether r comes from a party to a call: the owner of
+// a registered agent, or a human whose signaling call is live
+func (s *Server) mayUseTURN(r *http.Request) bool {
+	q := r.URL.Query()
+	if id := q.Get("call_id"); id != "" && s.hub.HasCall(id) {
+		return true
+	}
+	if id := q.Get("agent"); id != "" {
+		agent, ok := s.store.Lookup(id)
+		return ok && agent.Owner != "" && s.authorizeOwner(r, agent) == nil
+	}
+	return false
+}
+
+// handleWebSocket serves /v1/ws?agent=<id>[&role=bot]. Humans (the
+// default role) get presence updates for the agent and can place calls
+// through the signaling hub; a bot attaches with role=bot, proving
+// ownership the same way as for deregistration, and receives those calls.
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	agentID := r.URL.Query().Get("agent")
+	role := signaling.Role(r.URL.Query().Get("role"))
+	if role == "" {
+		role = signaling.RoleHuman
+	}
+	if role != signaling.RoleHuman && role != signaling.RoleBot {
+		writeError(w, http.StatusBadRequest, "bad_role", "role must be human or bot")
+		return
+	}
+	logger := logging.FromContext(r.Context()).With("agent_id", agentID)
+
+	if role == signaling.RoleBot {
+		agent, ok := s.store.Lookup(agentID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "agent "+agentID+" is not registered")
+			return
+		}
+		if err := s.authorizeOwner(r, agent); err != nil {
+			logger.Warn("rejected bot socket", "err", err)
+			if _, isAttest := err.(*attest.Error); isAttest {
+				writeAttestationError(w, err)
+			} else {
+				writeOwnershipError(w, err)
+			}
+			return
+		}
+	}
+
+	_, span := tracing.Start(r.Context(), "websocket.connect",
+		attribute.String("botcall.agent_id", agentID),
+		attribute.String("botcall.role", string(role)),
+	)
 	conn, err := s.upgrader.Upgrade(w, r, nil)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
+		logger.Warn("websocket upgrade failed", "err", err)
 		return
 	}
 	defer conn.Close()
 
-	agentID := r.URL.Query().Get("agent")
 	if agentID == "" {
 		conn.WriteMessage(websocket.TextMessage, []byte(`{"error": "missing agent ID"}`))
 		return
 	}
 
-	log.Printf("WebSocket connected for agent: %s", agentID)
+	logger.Info("websocket connected", "role", role)
+	sockets := s.metrics.WebSockets.WithLabelValues(string(role))
+	sockets.Inc()
+	defer sockets.Dec()
+
+	peer := signaling.NewPeer(conn, role, agentID)
+	served := make(chan struct{})
+	defer func() {
+		peer.Close()
+		<-served
+		if role == signaling.RoleBot {
+			s.relayDetached(agentID)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := s.store.Watch(ctx)
 
-	// Keep connection alive and forward messages
-	ticker := time.NewTicker(30 * time.Second)
+	// Attach before announcing presence so a human who sees the bot
+	// online can reach it; the hub then reads the socket until it closes
+	s.hub.Attach(peer)
+	go func() {
+		defer close(served)
+		defer cancel()
+		s.hub.Serve(peer)
+	}()
+
+	agent, _ := s.store.Lookup(agentID)
+	peer.Send(s.presenceMessage(agentID, agent))
+	if role == signaling.RoleBot {
+		// The socket doubles as an HTTP tunnel to the bot's listener
+		peer.Send(signaling.Message{Type: signaling.TypeTunnel, AgentID: agentID, URL: tunnelURL(r, agentID)})
+	}
+
+	// Keep connection alive and forward presence changes
+	ticker := time.NewTicker(s.pingInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
+		case <-peer.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Agent.ID != agentID {
+				continue
+			}
+			if ev.Type != discovery.EventOnline && ev.Type != discovery.EventOffline {
+				continue
+			}
+			peer.Send(s.presenceMessage(agentID, ev.Agent))
 		case <-ticker.C:
 			// Send heartbeat
-			if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "ping"}`)); err != nil {
-				log.Printf("Ping failed: %v", err)
+			if !peer.Send(signaling.Message{Type: signaling.TypePing}) {
 				return
 			}
-			// Touch the agent
-			s.store.Touch(agentID)
+			// An attached bot socket counts as a keepalive
+			if role == signaling.RoleBot {
+				s.store.Touch(agentID)
+			}
 		}
 	}
 }
 
-func (s *Server) listAgents(w http.ResponseWriter, r *http.Request) {
-	agents := s.store.ListOnline()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"agents": agents,
-		"count":  len(agents),
-	})
+// version is reported by /health and on trace spans
+const version = "0.1.0"
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
-	server := NewServer()
+	flag.Parse()
+
+	logger, err := logging.New(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fatal("invalid logging flags", "err", err)
+	}
+	// Also routes the standard log package, which dependencies use
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    *traceExporter,
+		File:        *traceFile,
+		Endpoint:    *traceEndpoint,
+		SampleRatio: *traceSample,
+		Version:     version,
+	})
+	if err != nil {
+		fatal("tracing", "err", err)
+	}
+	if *traceExporter != tracing.ExporterNone {
+		slog.Info("tracing enabled", "exporter", *traceExporter, "sample", *traceSample)
+	}
 
-	// Routes
-	http.HandleFunc("/v1/register", server.handleRegister)
-	http.HandleFunc("/v1/lookup/", server.handleLookup)
-	http.HandleFunc("/v1/ws", server.handleWebSocket)
-	http.HandleFunc("/v1/agents", server.listAgents)
-	http.HandleFunc("/health", server.handleHealth)
+	store, err := openStore(*storeKind, *dataDir)
+	if err != nil {
+		fatal("open store", "err", err)
+	}
+	slog.Info("store opened", "kind", *storeKind)
+
+	verifier, err := newVerifier()
+	if err != nil {
+		fatal("attestation config", "err", err)
+	}
+	if verifier == nil {
+		slog.Warn("attestation verification disabled (no -attest-issuers)")
+	}
+
+	turnServer, err := startTURN()
+	if err != nil {
+		fatal("TURN server", "err", err)
+	}
+	if turnServer != nil {
+		slog.Info("embedded STUN/TURN server listening", "addr", turnServer.Addr(), "transports", "udp,tcp")
+	}
+
+	iceServers := ice.New(ice.Config{
+		STUN:   splitList(*stunURLs),
+		TURN:   splitList(*turnURLs),
+		Secret: *turnSecret,
+		TTL:    *turnTTL,
+	})
+	if *turnURLs != "" && !iceServers.TURNEnabled() {
+		slog.Warn("TURN URLs configured without -turn-secret; offering STUN only")
+	}
+
+	if !discovery.ValidBalance(*balancePolicy) {
+		fatal("unknown -balance (want least_calls, round_robin or sticky)", "balance", *balancePolicy)
+	}
+
+	prober := newProber(store)
+
+	server := NewServer(Config{
+		Store:      store,
+		Verifier:   verifier,
+		AdminToken: *adminToken,
+		ICE:        iceServers,
+		TURN:       turnServer,
+
+		PresenceTTL: *presenceTTL,
+		Balance:     *balancePolicy,
+		Prober:      prober,
+		Logger:      logger,
+	})
+
+	reaperCtx, stopReaper := context.WithCancel(context.Background())
+	defer stopReaper()
+	reaper := discovery.NewReaper(store, *presenceTTL, *retention)
+	reaper.Swept = server.metrics.ReaperSwept
+	// Balancing state for an agent that stopped registering would
+	// otherwise stay until it deregistered
+	reaper.Gone = server.balancer.Forget
+	go reaper.Run(reaperCtx)
+	slog.Info("reaper started", "presence_ttl", *presenceTTL, "retention", *retention)
+	if prober != nil {
+		go prober.Run(reaperCtx)
+		slog.Info("health prober started", "interval", *probeInterval)
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -262,13 +936,13 @@ func main() {
 	// Graceful shutdown
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: nil,
+		Handler: server.Handler(),
 	}
 
 	go func() {
-		log.Printf("BotCall Discovery Server starting on :%s", port)
+		slog.Info("BotCall discovery server starting", "addr", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+			fatal("server failed", "err", err)
 		}
 	}()
 
@@ -277,13 +951,28 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down...")
+	slog.Info("shutting down")
+	stopReaper()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	
+
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("Shutdown error: %v", err)
+		slog.Error("shutdown", "err", err)
+	}
+	if turnServer != nil {
+		if err := turnServer.Close(); err != nil {
+			slog.Error("close TURN server", "err", err)
+		}
 	}
-	
-	log.Println("Server stopped")
+
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Error("close store", "err", err)
+		}
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("flush traces", "err", err)
+	}
+
+	slog.Info("server stopped")
 }
@@ -0,0 +1,943 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/TheOrionAI/botcall-server/internal/attest"
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+	"github.com/TheOrionAI/botcall-server/internal/ice"
+	"github.com/TheOrionAI/botcall-server/internal/probe"
+	"github.com/TheOrionAI/botcall-server/internal/signaling"
+	"github.com/TheOrionAI/botcall-server/internal/turnserver"
+)
+
+func newTestServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	if cfg.Store == nil {
+		cfg.Store = discovery.NewDiscoveryStore()
+	}
+	ts := httptest.NewServer(NewServer(cfg).Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func doJSON(t *testing.T, method, url string, body interface{}, headers map[string]string) (int, map[string]interface{}) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+func register(t *testing.T, ts *httptest.Server, req RegisterRequest) (int, map[string]interface{}) {
+	t.Helper()
+	return doJSON(t, http.MethodPost, ts.URL+"/v1/register", req, nil)
+}
+
+func lookupEndpoint(t *testing.T, ts *httptest.Server, agentID string) string {
+	t.Helper()
+	_, body := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/"+agentID, nil, nil)
+	endpoint, _ := body["endpoint"].(string)
+	return endpoint
+}
+
+func TestHealthReportsBootID(t *testing.T) {
+	first, second := newTestServer(t, Config{}), newTestServer(t, Config{})
+	_, a := doJSON(t, http.MethodGet, first.URL+"/health", nil, nil)
+	_, again := doJSON(t, http.MethodGet, first.URL+"/health", nil, nil)
+	_, b := doJSON(t, http.MethodGet, second.URL+"/health", nil, nil)
+	if id, _ := a["boot_id"].(string); id == "" || id != again["boot_id"] {
+		t.Errorf("Boot ID %v then %v, want one stable ID", a["boot_id"], again["boot_id"])
+	}
+	if a["boot_id"] == b["boot_id"] {
+		t.Errorf("Two servers share boot ID %v", a["boot_id"])
+	}
+}
+
+func TestRegisterIssuesOwnershipKey(t *testing.T) {
+	ts := newTestServer(t, Config{})
+
+	status, body := register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000"})
+	if status != http.StatusOK {
+		t.Fatalf("First registration returned %d", status)
+	}
+	key, _ := body["registration_key"].(string)
+	if key == "" {
+		t.Fatal("Expected the server to issue a registration_key")
+	}
+
+	status, body = register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "6.6.6.6:9000"})
+	if status != http.StatusForbidden || body["error"] != codeOwnershipRequired {
+		t.Errorf("Hijack without key: got %d %v", status, body)
+	}
+	status, body = register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "6.6.6.6:9000", RegistrationKey: "guess"})
+	if status != http.StatusForbidden || body["error"] != codeNotOwner {
+		t.Errorf("Hijack with wrong key: got %d %v", status, body)
+	}
+	if ep := lookupEndpoint(t, ts, "orion"); ep != "10.0.0.1:9000" {
+		t.Fatalf("Endpoint was hijacked to %s", ep)
+	}
+
+	status, body = register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.2:9000", RegistrationKey: key})
+	if status != http.StatusOK {
+		t.Fatalf("Owner re-registration returned %d %v", status, body)
+	}
+	if _, issued := body["registration_key"]; issued {
+		t.Error("Expected no new key on re-registration")
+	}
+	if ep := lookupEndpoint(t, ts, "orion"); ep != "10.0.0.2:9000" {
+		t.Errorf("Expected owner to move endpoint, got %s", ep)
+	}
+}
+
+func TestRegisterWithChosenKey(t *testing.T) {
+	ts := newTestServer(t, Config{})
+
+	status, body := register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+	if status != http.StatusOK {
+		t.Fatalf("Registration returned %d", status)
+	}
+	if _, issued := body["registration_key"]; issued {
+		t.Error("Expected no issued key when the bot chose one")
+	}
+	if status, _ := register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"}); status != http.StatusOK {
+		t.Errorf("Re-registration with chosen key returned %d", status)
+	}
+}
+
+func TestAdminTransfer(t *testing.T) {
+	ts := newTestServer(t, Config{AdminToken: "admin"})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "old"})
+
+	transfer := TransferRequest{AgentID: "orion", RegistrationKey: "new"}
+	if status, _ := doJSON(t, http.MethodPost, ts.URL+"/v1/admin/transfer", transfer, nil); status != http.StatusUnauthorized {
+		t.Errorf("Transfer without admin token returned %d", status)
+	}
+	auth := map[string]string{"Authorization": "Bearer admin"}
+	if status, body := doJSON(t, http.MethodPost, ts.URL+"/v1/admin/transfer", transfer, auth); status != http.StatusOK {
+		t.Fatalf("Transfer returned %d %v", status, body)
+	}
+
+	if status, _ := register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "old"}); status != http.StatusForbidden {
+		t.Errorf("Old owner still accepted after transfer: %d", status)
+	}
+	if status, _ := register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.9:9000", RegistrationKey: "new"}); status != http.StatusOK {
+		t.Errorf("New owner rejected after transfer: %d", status)
+	}
+}
+
+func TestAgentsListHidesOwner(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+
+	_, body := doJSON(t, http.MethodGet, ts.URL+"/v1/agents", nil, nil)
+	agents, _ := body["agents"].([]interface{})
+	if len(agents) != 1 {
+		t.Fatalf("Expected 1 agent, got %v", body)
+	}
+	if _, leaked := agents[0].(map[string]interface{})["owner"]; leaked {
+		t.Error("Expected owner binding to be hidden from /v1/agents")
+	}
+}
+
+func TestRegisterProfile(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	status, body := register(t, ts, RegisterRequest{
+		AgentID:  "orion",
+		Endpoint: "10.0.0.1:9000",
+		Profile: discovery.Profile{
+			DisplayName:        "Orion",
+			Description:        "Stargazing guide",
+			AvatarURL:          "https://example.com/orion.png",
+			Modes:              []string{"Voice", "text"},
+			Languages:          []string{"en-US"},
+			Codecs:             []string{"opus"},
+			MaxConcurrentCalls: 2,
+			Tags:               []string{"astronomy"},
+		},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("Register returned %d %v", status, body)
+	}
+
+	var lookup LookupResponse
+	resp, err := http.Get(ts.URL + "/v1/lookup/orion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&lookup)
+	resp.Body.Close()
+	if lookup.DisplayName != "Orion" || lookup.AvatarURL != "https://example.com/orion.png" ||
+		strings.Join(lookup.Modes, ",") != "voice,text" || lookup.MaxConcurrentCalls != 2 {
+		t.Errorf("Lookup profile = %+v", lookup.Profile)
+	}
+
+	_, body = doJSON(t, http.MethodGet, ts.URL+"/v1/agents", nil, nil)
+	agents, _ := body["agents"].([]interface{})
+	if len(agents) != 1 || agents[0].(map[string]interface{})["display_name"] != "Orion" {
+		t.Errorf("Agents list = %v", body)
+	}
+
+	status, body = register(t, ts, RegisterRequest{
+		AgentID:  "vega",
+		Endpoint: "10.0.0.2:9000",
+		Profile:  discovery.Profile{Modes: []string{"fax"}},
+	})
+	if status != http.StatusBadRequest || body["error"] != "invalid_profile" {
+		t.Errorf("Invalid profile returned %d %v", status, body)
+	}
+}
+
+// issuerKeys returns an attest.KeySource trusting a fresh Ed25519 key
+func issuerKeys(t *testing.T) (attest.KeySource, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":%q}]}`, base64.RawURLEncoding.EncodeToString(pub))
+	ks, err := attest.ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks, priv
+}
+
+func attestation(t *testing.T, issuer, sub string, key ed25519.PrivateKey) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Subject:   sub,
+		Audience:  jwt.ClaimStrings{attest.DefaultAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAttestationBindsOwnerToIssuer(t *testing.T) {
+	keysA, privA := issuerKeys(t)
+	keysB, privB := issuerKeys(t)
+	verifier := attest.NewWithKeys(map[string]attest.KeySource{
+		"https://a.test": keysA,
+		"https://b.test": keysB,
+	}, "")
+	ts := newTestServer(t, Config{Verifier: verifier})
+
+	status, body := register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", Attestation: "demo-token"})
+	if status != http.StatusUnauthorized || body["error"] != attest.CodeMalformed {
+		t.Errorf("Invalid attestation: got %d %v", status, body)
+	}
+
+	status, body = register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", Attestation: attestation(t, "https://a.test", "orion", privA)})
+	if status != http.StatusOK {
+		t.Fatalf("Attested registration returned %d %v", status, body)
+	}
+	_, lookup := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil)
+	if lookup["attestation_valid"] != true {
+		t.Errorf("Expected attestation_valid, got %v", lookup)
+	}
+
+	status, body = register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "6.6.6.6:9000", Attestation: attestation(t, "https://b.test", "orion", privB)})
+	if status != http.StatusForbidden || body["error"] != codeNotOwner {
+		t.Errorf("Other issuer took over orion: got %d %v", status, body)
+	}
+
+	status, _ = register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.2:9000", Attestation: attestation(t, "https://a.test", "orion", privA)})
+	if status != http.StatusOK {
+		t.Errorf("Same issuer re-registration returned %d", status)
+	}
+}
+
+func TestDeregisterRequiresOwner(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+
+	if status, _ := doJSON(t, http.MethodDelete, ts.URL+"/v1/register/orion", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Anonymous deregister returned %d", status)
+	}
+	if status, _ := doJSON(t, http.MethodDelete, ts.URL+"/v1/register/orion", nil, map[string]string{"Authorization": "Bearer wrong"}); status != http.StatusForbidden {
+		t.Errorf("Deregister with wrong key returned %d", status)
+	}
+
+	status, body := doJSON(t, http.MethodDelete, ts.URL+"/v1/register/orion", nil, map[string]string{"Authorization": "Bearer s3cret"})
+	if status != http.StatusOK || body["status"] != "offline" {
+		t.Fatalf("Owner deregister returned %d %v", status, body)
+	}
+
+	_, lookup := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil)
+	if lookup["status"] != "offline" {
+		t.Errorf("Expected orion offline right after deregister, got %v", lookup)
+	}
+
+	// The claim outlives the bot, so nobody can grab the ID on shutdown
+	if status, _ := register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "6.6.6.6:9000"}); status != http.StatusForbidden {
+		t.Errorf("ID was claimable after deregister: %d", status)
+	}
+	if status, _ := doJSON(t, http.MethodDelete, ts.URL+"/v1/register/ghost", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Deregister of unknown agent returned %d", status)
+	}
+}
+
+func TestBotSocketRequiresOwner(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+	base := "ws" + strings.TrimPrefix(ts.URL, "http") + "/v1/ws?agent=orion"
+
+	_, resp, err := websocket.DefaultDialer.Dial(base+"&role=bot", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Anonymous bot socket: err=%v resp=%v", err, resp)
+	}
+
+	bot, _, err := websocket.DefaultDialer.Dial(base+"&role=bot", http.Header{"Authorization": {"Bearer s3cret"}})
+	if err != nil {
+		t.Fatalf("Owner bot socket: %v", err)
+	}
+	defer bot.Close()
+	human, _, err := websocket.DefaultDialer.Dial(base, nil)
+	if err != nil {
+		t.Fatalf("Human socket: %v", err)
+	}
+	defer human.Close()
+
+	// Both sides start with the agent's presence
+	for _, conn := range []*websocket.Conn{bot, human} {
+		var msg PresenceMessage
+		if err := conn.ReadJSON(&msg); err != nil || msg.Status != "online" {
+			t.Fatalf("Presence = %+v, %v", msg, err)
+		}
+	}
+
+	// Bots also learn their tunnel URL
+	var tunnel signaling.Message
+	if err := bot.ReadJSON(&tunnel); err != nil || tunnel.Type != signaling.TypeTunnel || tunnel.URL != ts.URL+"/t/orion" {
+		t.Fatalf("Tunnel frame = %+v, %v", tunnel, err)
+	}
+
+	human.WriteJSON(signaling.Message{Type: signaling.TypeCall, HumanID: "alice"})
+	var incoming signaling.Message
+	bot.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := bot.ReadJSON(&incoming); err != nil || incoming.Type != signaling.TypeCall || incoming.HumanID != "alice" {
+		t.Fatalf("Bot got %+v, %v", incoming, err)
+	}
+}
+
+func TestRelayModeProxiesCalls(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	status, body := register(t, ts, RegisterRequest{AgentID: "laptop", Mode: "relay", RegistrationKey: "s3cret"})
+	if status != http.StatusOK {
+		t.Fatalf("Relay registration without endpoint returned %d %v", status, body)
+	}
+	if url, _ := body["url"].(string); !strings.HasSuffix(url, "/v1/ws?role=bot&agent=laptop") {
+		t.Errorf("Registration URL = %q, want the bot socket", url)
+	}
+
+	endpoint := lookupEndpoint(t, ts, "laptop")
+	if endpoint != ts.URL+"/v1/call/laptop" {
+		t.Fatalf("Relay endpoint = %q", endpoint)
+	}
+
+	// No socket yet
+	if status, body := doJSON(t, http.MethodPost, endpoint, map[string]string{"human_id": "alice"}, nil); status != http.StatusBadGateway {
+		t.Fatalf("Call without relay socket returned %d %v", status, body)
+	}
+
+	bot := attachBot(t, ts, "laptop", "s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"accepted","path":%q,"body":%q}`, r.URL.Path, body)
+	}))
+
+	status, body = doJSON(t, http.MethodPost, endpoint, map[string]string{"human_id": "alice"}, nil)
+	if status != http.StatusOK || body["status"] != "accepted" || body["path"] != "/call" {
+		t.Fatalf("Relayed call returned %d %v", status, body)
+	}
+	if got, _ := body["body"].(string); !strings.Contains(got, "alice") {
+		t.Errorf("Bot saw body %q", got)
+	}
+
+	// Dropping the socket takes a relay bot offline
+	bot.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, lookup := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/laptop", nil, nil)
+		if lookup["status"] == "offline" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Relay bot still %v after its socket closed", lookup["status"])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRelayLimitsBodiesBothWays(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "laptop", Mode: "relay", RegistrationKey: "s3cret"})
+	attachBot(t, ts, "laptop", "s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// Echo the body, one byte longer when asked to overflow
+		if r.URL.Query().Get("overflow") != "" {
+			body = append(body, '!')
+		}
+		w.Write(body)
+	}))
+
+	post := func(query string, size int) (int, []byte) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/v1/call/laptop"+query, "application/octet-stream", bytes.NewReader(bytes.Repeat([]byte("a"), size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	if status, body := post("", signaling.MaxRelayBodySize); status != http.StatusOK || len(body) != signaling.MaxRelayBodySize {
+		t.Fatalf("1 MiB each way returned %d with %d bytes", status, len(body))
+	}
+	if status, _ := post("", signaling.MaxRelayBodySize+1); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized request returned %d", status)
+	}
+	if status, body := post("?overflow=1", signaling.MaxRelayBodySize); status != http.StatusBadGateway || !bytes.Contains(body, []byte("response_too_large")) {
+		t.Errorf("Oversized response returned %d %s", status, body)
+	}
+	// None of that cost the bot its socket
+	if status, body := post("", 2); status != http.StatusOK || string(body) != "aa" {
+		t.Errorf("Call after the limits returned %d %q", status, body)
+	}
+}
+
+func TestRequestIDReachesBot(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "laptop", Mode: "relay", RegistrationKey: "s3cret"})
+	seen := make(chan string, 2)
+	attachBot(t, ts, "laptop", "s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get("X-Request-ID")
+	}))
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/call/laptop", strings.NewReader(`{"human_id":"alice"}`))
+	req.Header.Set("X-Request-ID", "pwa-42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Relayed call returned %d", resp.StatusCode)
+	}
+	if got := <-seen; got != "pwa-42" {
+		t.Errorf("Bot saw request ID %q, want the PWA's", got)
+	}
+	if got := resp.Header.Get("X-Request-ID"); got != "pwa-42" {
+		t.Errorf("Response request ID = %q", got)
+	}
+
+	// Without one, discovery assigns an ID and hands it to the bot too
+	resp, err = http.Post(ts.URL+"/v1/call/laptop", "application/json", strings.NewReader(`{"human_id":"alice"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Relayed call returned %d", resp.StatusCode)
+	}
+	if got, want := <-seen, resp.Header.Get("X-Request-ID"); got == "" || got != want {
+		t.Errorf("Bot saw request ID %q, response had %q", got, want)
+	}
+}
+
+func TestTraceReachesRelayedBot(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "laptop", Mode: "relay", RegistrationKey: "s3cret"})
+	seen := make(chan string, 1)
+	attachBot(t, ts, "laptop", "s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get("traceparent")
+	}))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/call/laptop", strings.NewReader(`{"human_id":"alice"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Relayed call returned %d", resp.StatusCode)
+	}
+
+	var forward sdktrace.ReadOnlySpan
+	for _, span := range spans.Ended() {
+		switch span.Name() {
+		case "POST /v1/call/", "relay.forward":
+			if span.SpanContext().TraceID().String() != traceID {
+				t.Errorf("Span %s left the caller's trace", span.Name())
+			}
+		}
+		if span.Name() == "relay.forward" {
+			forward = span
+		}
+	}
+	if forward == nil {
+		t.Fatalf("No relay.forward span among %v", spans.Ended())
+	}
+	if got, want := <-seen, "00-"+traceID+"-"+forward.SpanContext().SpanID().String()+"-01"; got != want {
+		t.Errorf("Bot saw traceparent %q, want %q", got, want)
+	}
+}
+
+// attachBot opens a bot socket for agentID and answers relayed requests
+// with handler, the way the SDK does
+func attachBot(t *testing.T, ts *httptest.Server, agentID, key string, handler http.Handler) *websocket.Conn {
+	t.Helper()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/v1/ws?role=bot&agent=" + agentID
+	bot, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + key}})
+	if err != nil {
+		t.Fatalf("Bot socket: %v", err)
+	}
+	t.Cleanup(func() { bot.Close() })
+	// The presence frame comes once the hub has attached the socket
+	bot.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := bot.ReadMessage(); err != nil {
+		t.Fatalf("Bot socket: %v", err)
+	}
+	bot.SetReadDeadline(time.Time{})
+
+	go func() {
+		for {
+			var req signaling.RelayRequest
+			if err := bot.ReadJSON(&req); err != nil {
+				return
+			}
+			if req.Type != signaling.TypeRequest {
+				continue
+			}
+			r := httptest.NewRequest(req.Method, req.Path, bytes.NewReader(req.Body))
+			r.Header = req.Header
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			bot.WriteJSON(signaling.RelayResponse{
+				Type:      signaling.TypeResponse,
+				RequestID: req.RequestID,
+				Status:    rec.Code,
+				Header:    rec.Header(),
+				Body:      rec.Body.Bytes(),
+			})
+		}
+	}()
+	return bot
+}
+
+func TestTunnelProxiesAnyPath(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "localhost:9000", RegistrationKey: "s3cret"})
+
+	if status, _ := doJSON(t, http.MethodGet, ts.URL+"/t/orion/health", nil, nil); status != http.StatusBadGateway {
+		t.Fatalf("Tunnel without socket returned %d", status)
+	}
+
+	attachBot(t, ts, "orion", "s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTeapot)
+		json.NewEncoder(w).Encode(map[string]string{
+			"method": r.Method,
+			"uri":    r.URL.RequestURI(),
+			"proto":  r.Header.Get("X-Forwarded-Proto"),
+			"for":    r.Header.Get("X-Forwarded-For"),
+		})
+	}))
+
+	status, body := doJSON(t, http.MethodPut, ts.URL+"/t/orion/files/a.txt?v=2", map[string]string{"x": "y"},
+		map[string]string{"X-Forwarded-For": "203.0.113.7"})
+	if status != http.StatusTeapot {
+		t.Fatalf("Tunnel returned %d %v", status, body)
+	}
+	if body["method"] != http.MethodPut || body["uri"] != "/files/a.txt?v=2" || body["proto"] != "http" {
+		t.Errorf("Bot saw %v", body)
+	}
+	if body["for"] != "203.0.113.7, 127.0.0.1" {
+		t.Errorf("X-Forwarded-For = %v, want the caller's chain extended", body["for"])
+	}
+
+	if _, body := doJSON(t, http.MethodGet, ts.URL+"/t/orion", nil, nil); body["uri"] != "/" {
+		t.Errorf("Tunnel root reached %v", body["uri"])
+	}
+}
+
+func TestTunnelCarriesLargeBodies(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "localhost:9000", RegistrationKey: "s3cret"})
+	page := bytes.Repeat([]byte("botcall "), signaling.MaxRelayBodySize/8)
+	attachBot(t, ts, "orion", "s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(page)
+	}))
+
+	// A full 1 MiB page comes back, twice: the bot kept its socket
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(ts.URL + "/t/orion/index.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(got, page) {
+			t.Fatalf("Tunnel returned %d with %d bytes, want %d", resp.StatusCode, len(got), len(page))
+		}
+	}
+}
+
+// fetchICE gets /v1/ice-servers with query and headers
+func fetchICE(t *testing.T, ts *httptest.Server, query string, headers http.Header) ice.Servers {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/ice-servers?"+query, nil)
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var servers ice.Servers
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		t.Fatal(err)
+	}
+	return servers
+}
+
+func TestICEServers(t *testing.T) {
+	ts := newTestServer(t, Config{ICE: ice.New(ice.Config{
+		TURN:   []string{"turn:turn.example:3478"},
+		Secret: "s3cret",
+		TTL:    5 * time.Minute,
+	})})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "orion-key"})
+	owner := http.Header{"Authorization": {"Bearer orion-key"}}
+
+	servers := fetchICE(t, ts, "user=orion&agent=orion", owner)
+	if len(servers.ICEServers) != 2 || servers.TTL != 300 {
+		t.Fatalf("ice-servers = %+v", servers)
+	}
+	turn := servers.ICEServers[1]
+	if !strings.HasSuffix(turn.Username, ":orion") || turn.Credential != ice.Password("s3cret", turn.Username) {
+		t.Errorf("TURN entry = %+v", turn)
+	}
+	if servers.Expires == nil {
+		t.Fatal("No expiry on TURN credentials")
+	}
+	if until := time.Until(*servers.Expires); until <= 0 || until > 5*time.Minute {
+		t.Errorf("Credentials expire in %s", until)
+	}
+
+	// Strangers get STUN alone, so the TURN server is no open relay
+	for _, tc := range []struct {
+		query   string
+		headers http.Header
+	}{
+		{"user=alice", nil},
+		{"user=alice&agent=orion", http.Header{"Authorization": {"Bearer guess"}}},
+		{"user=alice&agent=ghost", owner},
+		{"user=alice&call_id=call-guess", nil},
+	} {
+		if servers := fetchICE(t, ts, tc.query, tc.headers); len(servers.ICEServers) != 1 || servers.Username != "" {
+			t.Errorf("%s got %+v, want STUN only", tc.query, servers)
+		}
+	}
+
+	// A human on a live signaling call gets TURN too
+	base := "ws" + strings.TrimPrefix(ts.URL, "http") + "/v1/ws?agent=orion"
+	bot, _, err := websocket.DefaultDialer.Dial(base+"&role=bot", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bot.Close()
+	bot.ReadMessage() // presence
+	human, _, err := websocket.DefaultDialer.Dial(base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer human.Close()
+	human.ReadMessage() // presence
+	human.WriteJSON(signaling.Message{Type: signaling.TypeCall, HumanID: "alice"})
+	var ringing signaling.Message
+	human.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := human.ReadJSON(&ringing); err != nil || ringing.Type != signaling.TypeRinging {
+		t.Fatalf("Human got %+v, %v", ringing, err)
+	}
+	if servers := fetchICE(t, ts, "user=alice&call_id="+ringing.CallID, nil); len(servers.ICEServers) != 2 {
+		t.Errorf("Caller on %s got %+v, want TURN", ringing.CallID, servers)
+	}
+}
+
+func TestAdminTURNStats(t *testing.T) {
+	auth := map[string]string{"Authorization": "Bearer admin"}
+	off := newTestServer(t, Config{AdminToken: "admin"})
+	if status, body := doJSON(t, http.MethodGet, off.URL+"/v1/admin/turn", nil, auth); status != http.StatusNotFound || body["error"] != "turn_disabled" {
+		t.Errorf("Stats without TURN returned %d %v", status, body)
+	}
+
+	turnServer, err := turnserver.Start(turnserver.Config{
+		ListenAddr: "127.0.0.1:0",
+		PublicIP:   net.ParseIP("127.0.0.1"),
+		Secret:     "s3cret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer turnServer.Close()
+	ts := newTestServer(t, Config{AdminToken: "admin", TURN: turnServer})
+
+	if status, _ := doJSON(t, http.MethodGet, ts.URL+"/v1/admin/turn", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Stats without admin token returned %d", status)
+	}
+	status, body := doJSON(t, http.MethodGet, ts.URL+"/v1/admin/turn", nil, auth)
+	if status != http.StatusOK {
+		t.Fatalf("Stats returned %d %v", status, body)
+	}
+	if _, ok := body["active_allocations"]; !ok {
+		t.Errorf("Stats = %v", body)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	if min, max, err := parsePortRange("49152-65535"); err != nil || min != 49152 || max != 65535 {
+		t.Errorf("parsePortRange = %d, %d, %v", min, max, err)
+	}
+	for _, bad := range []string{"1000", "2000-1000", "0-10", "a-b", "1-70000"} {
+		if _, _, err := parsePortRange(bad); err == nil {
+			t.Errorf("parsePortRange(%q) accepted", bad)
+		}
+	}
+}
+
+func TestInstancesShareAgentID(t *testing.T) {
+	ts := newTestServer(t, Config{Balance: discovery.BalanceRoundRobin})
+	auth := map[string]string{"Authorization": "Bearer s3cret"}
+	for _, inst := range []RegisterRequest{
+		{InstanceID: "a", Endpoint: "http://10.0.0.1:9000", Weight: 2},
+		{InstanceID: "b", Endpoint: "http://10.0.0.2:9000"},
+	} {
+		inst.AgentID, inst.RegistrationKey = "orion", "s3cret"
+		inst.MaxConcurrentCalls = 10
+		if status, body := register(t, ts, inst); status != http.StatusOK {
+			t.Fatalf("Register %s returned %d %v", inst.InstanceID, status, body)
+		}
+	}
+
+	// Each caller moves the rotation on; anonymous lookups do not
+	var got []string
+	for i := 0; i < 6; i++ {
+		lookupEndpoint(t, ts, "orion")
+		_, body := doJSON(t, http.MethodGet, fmt.Sprintf("%s/v1/lookup/orion?human=human-%d", ts.URL, i), nil, nil)
+		got = append(got, body["endpoint"].(string))
+	}
+	want := "http://10.0.0.1:9000,http://10.0.0.2:9000,http://10.0.0.1:9000"
+	if strings.Join(got, ",") != want+","+want {
+		t.Errorf("Round robin endpoints = %v", got)
+	}
+	_, body := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil)
+	if body["instances"] != 2.0 || body["instance_id"] == "" {
+		t.Errorf("Lookup = %v", body)
+	}
+
+	// One replica leaving keeps the agent online on the other
+	status, body := doJSON(t, http.MethodDelete, ts.URL+"/v1/register/orion?instance=a", nil, auth)
+	if status != http.StatusOK || body["status"] != "online" {
+		t.Fatalf("Deregister instance returned %d %v", status, body)
+	}
+	for i := 0; i < 3; i++ {
+		if endpoint := lookupEndpoint(t, ts, "orion"); endpoint != "http://10.0.0.2:9000" {
+			t.Errorf("Lookup after a left = %s", endpoint)
+		}
+	}
+	doJSON(t, http.MethodDelete, ts.URL+"/v1/register/orion?instance=b", nil, auth)
+	if _, body := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil); body["status"] != "offline" {
+		t.Errorf("Lookup after the last instance left = %v", body)
+	}
+}
+
+func TestRelayAllowsOneInstance(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", RegistrationKey: "s3cret", InstanceID: "a", Mode: modeRelay})
+	// Re-registering the same instance is its keepalive
+	register(t, ts, RegisterRequest{AgentID: "orion", RegistrationKey: "s3cret", InstanceID: "a", Mode: modeRelay})
+
+	second := RegisterRequest{AgentID: "orion", RegistrationKey: "s3cret", InstanceID: "b", Mode: modeRelay}
+	if status, body := doJSON(t, http.MethodPost, ts.URL+"/v1/register", second, nil); status != http.StatusConflict || body["error"] != "relay_instance_conflict" {
+		t.Fatalf("Second relay instance got %d %v", status, body)
+	}
+	// Direct instances do not need the socket
+	register(t, ts, RegisterRequest{AgentID: "orion", RegistrationKey: "s3cret", InstanceID: "c", Endpoint: "http://10.0.0.3:9000"})
+
+	doJSON(t, http.MethodDelete, ts.URL+"/v1/register/orion?instance=a", nil, map[string]string{"Authorization": "Bearer s3cret"})
+	register(t, ts, second)
+}
+
+func TestLookupBusyWhenInstancesFull(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	for _, inst := range []RegisterRequest{
+		{InstanceID: "a", Endpoint: "http://10.0.0.1:9000", ActiveCalls: 1},
+		{InstanceID: "b", Endpoint: "http://10.0.0.2:9000", ActiveCalls: 1},
+	} {
+		inst.AgentID, inst.RegistrationKey = "orion", "s3cret"
+		inst.MaxConcurrentCalls = 1
+		register(t, ts, inst)
+	}
+	_, body := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil)
+	if body["status"] != "busy" || body["presence"] != "in_call" {
+		t.Fatalf("Lookup with every instance full = %v", body)
+	}
+
+	// A keepalive reporting a free slot brings it back
+	register(t, ts, RegisterRequest{AgentID: "orion", RegistrationKey: "s3cret", InstanceID: "b", Endpoint: "http://10.0.0.2:9000", Profile: discovery.Profile{MaxConcurrentCalls: 1}})
+	_, body = doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil)
+	if body["status"] != "online" || body["instance_id"] != "b" {
+		t.Errorf("Lookup after b freed up = %v", body)
+	}
+
+	// Instances that state no cap are never full
+	register(t, ts, RegisterRequest{AgentID: "vega", InstanceID: "a", Endpoint: "http://10.0.0.3:9000", ActiveCalls: 40})
+	if _, body := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/vega", nil, nil); body["status"] != "online" {
+		t.Errorf("Lookup of uncapped agent = %v", body)
+	}
+}
+
+func TestStickyLookupKeepsHuman(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	for i := 0; i < 4; i++ {
+		register(t, ts, RegisterRequest{AgentID: "orion", RegistrationKey: "s3cret", Balance: discovery.BalanceSticky,
+			InstanceID: fmt.Sprintf("i%d", i), Endpoint: fmt.Sprintf("http://10.0.0.%d:9000", i)})
+	}
+	seen := make(map[string]bool)
+	for _, human := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+		_, first := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion?human="+human, nil, nil)
+		for i := 0; i < 3; i++ {
+			_, again := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion?human="+human, nil, nil)
+			if again["instance_id"] != first["instance_id"] {
+				t.Fatalf("%s moved from %v to %v", human, first["instance_id"], again["instance_id"])
+			}
+		}
+		seen[first["instance_id"].(string)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Six humans all landed on %v", seen)
+	}
+}
+
+func TestRegisterRejectsBadInstance(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	for _, req := range []RegisterRequest{
+		{Weight: -1},
+		{Weight: discovery.MaxInstanceWeight + 1},
+		{ActiveCalls: -1},
+		{Balance: "random"},
+		{InstanceID: strings.Repeat("x", discovery.MaxInstanceIDLen+1)},
+	} {
+		req.AgentID, req.Endpoint = "orion", "10.0.0.1:9000"
+		if status, body := register(t, ts, req); status != http.StatusBadRequest || body["error"] != "invalid_instance" {
+			t.Errorf("Register %+v returned %d %v", req, status, body)
+		}
+	}
+}
+
+func TestLoopbackBotStaysCallable(t *testing.T) {
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer bot.Close()
+
+	// Default flags: probing on, private addresses refused
+	store := discovery.NewDiscoveryStore()
+	prober := newProber(store)
+	ts := newTestServer(t, Config{Store: store, Prober: prober})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: bot.URL})
+
+	now := time.Now()
+	for i := 0; i <= *probeFailures; i++ {
+		prober.Sweep(context.Background(), now.Add(time.Duration(i)*(*probeInterval)*2))
+	}
+	_, body := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil)
+	health, _ := body["health"].(map[string]interface{})
+	if body["status"] != "online" || body["endpoint"] != bot.URL || health["state"] != probe.StateUnknown {
+		t.Fatalf("Lookup = %v", body)
+	}
+}
+
+func TestLookupSkipsDegradedInstances(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "firewalled", http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	store := discovery.NewDiscoveryStore()
+	prober := probe.New(store, probe.Config{Interval: time.Minute, Jitter: time.Nanosecond, FailureThreshold: 1, AllowPrivate: true})
+	ts := newTestServer(t, Config{Store: store, Prober: prober, Balance: discovery.BalanceRoundRobin})
+	register(t, ts, RegisterRequest{AgentID: "orion", RegistrationKey: "s3cret", InstanceID: "up", Endpoint: up.URL, Profile: discovery.Profile{MaxConcurrentCalls: 10}})
+	register(t, ts, RegisterRequest{AgentID: "orion", RegistrationKey: "s3cret", InstanceID: "down", Endpoint: down.URL, Profile: discovery.Profile{MaxConcurrentCalls: 10}})
+	prober.Sweep(context.Background(), time.Now())
+
+	for i := 0; i < 4; i++ {
+		_, body := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil)
+		health, _ := body["health"].(map[string]interface{})
+		if body["status"] != "online" || body["endpoint"] != up.URL || health["state"] != probe.StateHealthy {
+			t.Fatalf("Lookup = %v", body)
+		}
+	}
+
+	// With only the firewalled instance left the agent is degraded
+	doJSON(t, http.MethodDelete, ts.URL+"/v1/register/orion?instance=up", nil, map[string]string{"Authorization": "Bearer s3cret"})
+	_, body := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil)
+	health, _ := body["health"].(map[string]interface{})
+	probes, _ := health["probes"].([]interface{})
+	if body["status"] != "degraded" || health["state"] != probe.StateDegraded || len(probes) != 1 {
+		t.Fatalf("Lookup = %v", body)
+	}
+	if last := probes[0].(map[string]interface{}); last["ok"] != false || last["status"] != 502.0 || last["error"] != nil {
+		t.Errorf("Probe = %v", last)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/attest"
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+)
+
+func scrape(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/metrics returned %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	keys, priv := issuerKeys(t)
+	store := discovery.NewDiscoveryStore()
+	server := NewServer(Config{
+		Store:    store,
+		Verifier: attest.NewWithKeys(map[string]attest.KeySource{"https://a.test": keys}, ""),
+	})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", Attestation: attestation(t, "https://a.test", "orion", priv)})
+	register(t, ts, RegisterRequest{AgentID: "vega", Mode: "relay", Attestation: attestation(t, "https://a.test", "vega", priv)})
+	register(t, ts, RegisterRequest{AgentID: "lyra", Endpoint: "10.0.0.3:9000", Attestation: "demo-token"})
+	lookupEndpoint(t, ts, "orion")
+	lookupEndpoint(t, ts, "vega")
+	lookupEndpoint(t, ts, "nobody")
+
+	// Both bots go quiet: the reaper takes them offline, then evicts them
+	reaper := discovery.NewReaper(store, time.Minute, time.Hour)
+	server.metrics.ReaperSwept(reaper.Sweep(time.Now().Add(2 * time.Minute)))
+	server.metrics.ReaperSwept(reaper.Sweep(time.Now().Add(3 * time.Hour)))
+
+	out := scrape(t, ts)
+	for _, want := range []string{
+		`botcall_registrations_total{result="accepted"} 2`,
+		`botcall_registrations_total{result="rejected"} 1`,
+		`botcall_lookups_total{result="hit"} 2`,
+		`botcall_lookups_total{result="miss"} 1`,
+		`botcall_attestation_failures_total{code="` + attest.CodeMalformed + `"} 1`,
+		`botcall_http_request_duration_seconds_count{code="200",method="GET",route="/v1/lookup/"} 3`,
+		`botcall_http_request_duration_seconds_count{code="200",method="POST",route="/v1/register"} 2`,
+		`botcall_reaper_offline_total 2`,
+		`botcall_reaper_evictions_total 2`,
+		`botcall_agents_online{mode="direct"} 0`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("/metrics is missing %s", want)
+		}
+	}
+}
+
+func TestMetricsGauges(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000"})
+	register(t, ts, RegisterRequest{AgentID: "vega", Endpoint: "10.0.0.2:9000"})
+	_, reg := register(t, ts, RegisterRequest{AgentID: "lyra", Mode: "relay"})
+	attachBot(t, ts, "lyra", reg["registration_key"].(string), http.NotFoundHandler())
+
+	out := scrape(t, ts)
+	if strings.Contains(out, `route="/v1/ws"`) {
+		t.Error("WebSocket upgrades should not be timed")
+	}
+	for _, want := range []string{
+		`botcall_websockets_active{role="bot"} 1`,
+		`botcall_agents_online{mode="direct"} 2`,
+		`botcall_agents_online{mode="relay"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("/metrics is missing %s", want)
+		}
+	}
+}
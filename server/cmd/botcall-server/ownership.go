@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/TheOrionAI/botcall-server/internal/attest"
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+	"github.com/TheOrionAI/botcall-server/internal/logging"
+)
+
+// ErrorResponse is the body of structured API errors. attest.Error
+// encodes to the same shape.
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: code, Message: message})
+}
+
+const (
+	codeOwnershipRequired = "ownership_proof_required"
+	codeNotOwner          = "not_owner"
+)
+
+// ownershipError explains why a registrant may not touch an agent ID
+type ownershipError struct {
+	code    string
+	message string
+}
+
+func (e *ownershipError) Error() string { return e.code + ": " + e.message }
+
+func writeOwnershipError(w http.ResponseWriter, err error) {
+	if oe, ok := err.(*ownershipError); ok {
+		writeError(w, http.StatusForbidden, oe.code, oe.message)
+		return
+	}
+	writeError(w, http.StatusForbidden, codeNotOwner, err.Error())
+}
+
+// claimOwnership decides who owns agentID after this request.
+//
+// The first registrant claims the ID: with a verified attestation the ID is
+// bound to its issuer, otherwise to the registration key the bot sent, or
+// to one the server generates and returns. Later requests must present the
+// same key, or an attestation from the same issuer. issuedKey is non-empty
+// only when the server generated a key the bot must keep.
+func claimOwnership(existing *discovery.Agent, registrationKey string, claims *attest.Claims) (owner, issuedKey string, err error) {
+	if existing == nil || existing.Owner == "" {
+		switch {
+		case claims != nil:
+			return discovery.OwnerForIssuer(claims.Issuer), "", nil
+		case registrationKey != "":
+			return discovery.OwnerForKey(registrationKey), "", nil
+		default:
+			key, err := newRegistrationKey()
+			if err != nil {
+				return "", "", err
+			}
+			return discovery.OwnerForKey(key), key, nil
+		}
+	}
+
+	if err := proveOwnership(existing, registrationKey, claims); err != nil {
+		return "", "", err
+	}
+	return existing.Owner, "", nil
+}
+
+// proveOwnership checks that a request carries the credential the agent's
+// ID is bound to
+func proveOwnership(existing *discovery.Agent, registrationKey string, claims *attest.Claims) error {
+	owner := existing.Owner
+	switch {
+	case owner == "":
+		return nil
+
+	case strings.HasPrefix(owner, "attest:"):
+		if claims != nil && discovery.OwnerForIssuer(claims.Issuer) == owner {
+			return nil
+		}
+		return &ownershipError{codeNotOwner, "agent ID " + existing.ID + " is bound to attestations from " + strings.TrimPrefix(owner, "attest:")}
+
+	default:
+		if registrationKey == "" {
+			return &ownershipError{codeOwnershipRequired, "agent ID " + existing.ID + " is claimed; send its registration_key"}
+		}
+		if subtle.ConstantTimeCompare([]byte(discovery.OwnerForKey(registrationKey)), []byte(owner)) != 1 {
+			return &ownershipError{codeNotOwner, "registration_key does not match the owner of " + existing.ID}
+		}
+		return nil
+	}
+}
+
+func newRegistrationKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// attestationHeader carries an attestation token on requests that have no
+// JSON body, such as deregistration
+const attestationHeader = "X-BotCall-Attestation"
+
+// authorizeOwner checks that a bodyless request acts for the owner of
+// agent. The registration key travels as a bearer token and attestations in
+// attestationHeader; the admin token is accepted too.
+func (s *Server) authorizeOwner(r *http.Request, agent *discovery.Agent) error {
+	if s.isAdmin(r) {
+		return nil
+	}
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	var claims *attest.Claims
+	if token := r.Header.Get(attestationHeader); token != "" && strings.HasPrefix(agent.Owner, "attest:") {
+		c, err := s.verifyAttestation(r.Context(), agent.ID, token)
+		if err != nil {
+			return err
+		}
+		claims = c
+	}
+	return proveOwnership(agent, key, claims)
+}
+
+// TransferRequest rebinds an agent ID to a new owner
+type TransferRequest struct {
+	AgentID string `json:"agent_id"`
+	// RegistrationKey becomes the new owner key. Empty releases the claim
+	// so the next registrant takes the ID.
+	RegistrationKey string `json:"registration_key,omitempty"`
+}
+
+// handleAdminTransfer lets an operator move an agent ID to a new owner
+func (s *Server) handleAdminTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "admin_required", "a valid admin bearer token is required")
+		return
+	}
+
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AgentID == "" {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+
+	agent, ok := s.store.Lookup(req.AgentID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "agent "+req.AgentID+" is not registered")
+		return
+	}
+	agent.Owner = ""
+	if req.RegistrationKey != "" {
+		agent.Owner = discovery.OwnerForKey(req.RegistrationKey)
+	}
+	if err := s.store.Register(agent); err != nil {
+		logging.FromContext(r.Context()).Error("transfer failed", "agent_id", req.AgentID, "err", err)
+		http.Error(w, "Transfer failed", http.StatusInternalServerError)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("admin transferred agent", "agent_id", req.AgentID, "released", req.RegistrationKey == "")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agent_id":    req.AgentID,
+		"transferred": true,
+	})
+}
+
+// isAdmin checks the request's bearer token against the admin token
+func (s *Server) isAdmin(r *http.Request) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
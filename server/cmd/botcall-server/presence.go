@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/TheOrionAI/botcall-server/internal/attest"
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+	"github.com/TheOrionAI/botcall-server/internal/logging"
+	"github.com/TheOrionAI/botcall-server/internal/signaling"
+)
+
+// maxPresenceAgents caps the agents one presence stream may follow
+const maxPresenceAgents = 100
+
+// Coarse statuses: whether a human can call right now
+const (
+	statusOnline  = "online"
+	statusBusy    = "busy"
+	statusOffline = "offline"
+)
+
+// presence is the agent's state as humans see it: the state the bot set,
+// or in_call while its signaled calls fill the capacity of its live
+// instances. An agent that states no max_concurrent_calls is in_call
+// during any call, though admitCall still lets more through.
+func (s *Server) presence(agent *discovery.Agent) string {
+	if agent == nil {
+		return discovery.PresenceOffline
+	}
+	return s.presenceWith(agent, s.hub.CallsFor(agent.ID))
+}
+
+// presenceWith is presence for an agent with calls signaled calls
+func (s *Server) presenceWith(agent *discovery.Agent, calls int) string {
+	now := time.Now()
+	state := agent.PresenceAt(now)
+	if state == discovery.PresenceOffline {
+		return state
+	}
+	if limit := agent.Capacity(now.Add(-s.presenceTTL)); calls > 0 && (limit == 0 || calls >= limit) {
+		return discovery.PresenceInCall
+	}
+	return state
+}
+
+// full reports whether calls signaled calls take every slot of the
+// agent's live instances. Uncapped agents are never full.
+func (s *Server) full(agent *discovery.Agent, calls int, now time.Time) bool {
+	limit := agent.Capacity(now.Add(-s.presenceTTL))
+	return limit > 0 && calls >= limit
+}
+
+// balanceFor is the policy lookups of agent use to pick an instance
+func (s *Server) balanceFor(agent *discovery.Agent) string {
+	if agent.Balance != "" {
+		return agent.Balance
+	}
+	return s.defaultBalance
+}
+
+// statusFor folds a presence state into online, busy or offline
+func statusFor(state string) string {
+	switch state {
+	case discovery.PresenceAvailable:
+		return statusOnline
+	case discovery.PresenceOffline:
+		return statusOffline
+	}
+	return statusBusy
+}
+
+// admitCall refuses signaled calls to agents that set themselves busy or
+// whose instances are full. It goes by capacity rather than presence, so
+// an uncapped agent shown in_call still takes more calls. The hub calls it
+// locked, with the agent's calls counted.
+func (s *Server) admitCall(agentID string, calls int) string {
+	agent, ok := s.store.Lookup(agentID)
+	if !ok {
+		return ""
+	}
+	now := time.Now()
+	if statusFor(agent.PresenceAt(now)) == statusBusy || s.full(agent, calls, now) {
+		return signaling.ReasonBusy
+	}
+	return ""
+}
+
+// PresenceMessage is pushed to WebSocket and presence stream subscribers
+// when an agent they are watching changes state
+type PresenceMessage struct {
+	Type    string `json:"type"` // always "presence"
+	AgentID string `json:"agent_id"`
+	Status  string `json:"status"` // online, busy or offline
+	// Presence is available, busy, dnd, in_call or offline
+	Presence        string `json:"presence"`
+	StatusMessage   string `json:"status_message,omitempty"`
+	PresenceExpires string `json:"presence_expires,omitempty"`
+	LastSeen        string `json:"last_seen,omitempty"`
+}
+
+func (s *Server) presenceMessage(agentID string, agent *discovery.Agent) PresenceMessage {
+	state := s.presence(agent)
+	msg := PresenceMessage{Type: "presence", AgentID: agentID, Status: statusFor(state), Presence: state}
+	if agent != nil {
+		msg.LastSeen = agent.LastSeen.Format(time.RFC3339)
+		if agent.Presence == state {
+			msg.StatusMessage = agent.StatusMessage
+			if !agent.PresenceExpires.IsZero() {
+				msg.PresenceExpires = agent.PresenceExpires.Format(time.RFC3339)
+			}
+		}
+	}
+	return msg
+}
+
+// streamPresence sends the current presence of each agent, then every
+// change of state or status message until ctx ends or send fails. ping
+// runs every pingInterval, when expired states are also noticed.
+func (s *Server) streamPresence(ctx context.Context, agentIDs []string, send func(PresenceMessage) error, ping func() error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Subscribe before the snapshot so no transition falls in between
+	events := s.store.Watch(ctx)
+	calls := s.hub.Watch(ctx)
+
+	// Keepalives move LastSeen only, which is not worth an event
+	last := make(map[string]PresenceMessage, len(agentIDs))
+	update := func(agentID string, agent *discovery.Agent) error {
+		msg := s.presenceMessage(agentID, agent)
+		key := msg
+		key.LastSeen = ""
+		if prev, seen := last[agentID]; seen && prev == key {
+			return nil
+		}
+		last[agentID] = key
+		return send(msg)
+	}
+
+	for _, id := range agentIDs {
+		agent, _ := s.store.Lookup(id)
+		if err := update(id, agent); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if _, watched := last[ev.Agent.ID]; !watched {
+				continue
+			}
+			agent := ev.Agent
+			if ev.Type == discovery.EventEvicted || ev.Type == discovery.EventDeregistered {
+				agent = nil
+			}
+			err = update(ev.Agent.ID, agent)
+		case ev, ok := <-calls:
+			if !ok {
+				return
+			}
+			if _, watched := last[ev.AgentID]; !watched {
+				continue
+			}
+			agent, _ := s.store.Lookup(ev.AgentID)
+			err = update(ev.AgentID, agent)
+		case <-ticker.C:
+			// Bot-set states lapse without an event; catch them here
+			for id := range last {
+				agent, _ := s.store.Lookup(id)
+				if err = update(id, agent); err != nil {
+					return
+				}
+			}
+			err = ping()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handlePresenceStream serves GET /v1/presence/stream?agents=a,b,c as
+// Server-Sent Events, or as a WebSocket of presence messages when the
+// request is an upgrade
+func (s *Server) handlePresenceStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var agentIDs []string
+	seen := make(map[string]bool)
+	for _, value := range r.URL.Query()["agents"] {
+		for _, id := range splitList(value) {
+			if !seen[id] {
+				seen[id] = true
+				agentIDs = append(agentIDs, id)
+			}
+		}
+	}
+	if len(agentIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "missing_agents", "list agent IDs in ?agents=")
+		return
+	}
+	if len(agentIDs) > maxPresenceAgents {
+		writeError(w, http.StatusBadRequest, "too_many_agents", fmt.Sprintf("a stream follows at most %d agents", maxPresenceAgents))
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		s.servePresenceSocket(w, r, agentIDs)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep proxies from buffering
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(msg PresenceMessage) error {
+		data, _ := json.Marshal(msg)
+		if _, err := fmt.Fprintf(w, "event: presence\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	ping := func() error {
+		if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	s.streamPresence(r.Context(), agentIDs, send, ping)
+}
+
+func (s *Server) servePresenceSocket(w http.ResponseWriter, r *http.Request, agentIDs []string) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("websocket upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+	sockets := s.metrics.WebSockets.WithLabelValues("presence")
+	sockets.Inc()
+	defer sockets.Dec()
+
+	// The client only listens; reading notices when it goes away
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(v interface{}) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(v)
+	}
+	send := func(msg PresenceMessage) error { return write(msg) }
+	ping := func() error { return write(map[string]string{"type": "ping"}) }
+	s.streamPresence(ctx, agentIDs, send, ping)
+}
+
+// PresenceRequest sets the presence a bot shows to humans
+type PresenceRequest struct {
+	State   string `json:"state"` // available, busy or dnd
+	Message string `json:"message,omitempty"`
+	// ExpiresIn reverts the state to available after this many seconds;
+	// zero keeps it until changed
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+}
+
+// handleSetPresence serves PUT /v1/presence/{id}, authenticated like
+// deregistration
+func (s *Server) handleSetPresence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agentID := strings.TrimPrefix(r.URL.Path, "/v1/presence/")
+	if agentID == "" {
+		http.Error(w, "Missing agent ID", http.StatusBadRequest)
+		return
+	}
+
+	var req PresenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	switch {
+	case !discovery.Settable(req.State):
+		writeError(w, http.StatusBadRequest, "invalid_presence", "state must be available, busy or dnd")
+		return
+	case utf8.RuneCountInString(req.Message) > discovery.MaxStatusMessageLen:
+		writeError(w, http.StatusBadRequest, "invalid_presence", fmt.Sprintf("message is longer than %d characters", discovery.MaxStatusMessageLen))
+		return
+	case req.ExpiresIn < 0:
+		writeError(w, http.StatusBadRequest, "invalid_presence", "expires_in must not be negative")
+		return
+	}
+
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+
+	agent, ok := s.store.Lookup(agentID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "agent "+agentID+" is not registered")
+		return
+	}
+	if err := s.authorizeOwner(r, agent); err != nil {
+		logging.FromContext(r.Context()).Warn("rejected presence", "agent_id", agentID, "err", err)
+		if _, isAttest := err.(*attest.Error); isAttest {
+			writeAttestationError(w, err)
+		} else {
+			writeOwnershipError(w, err)
+		}
+		return
+	}
+
+	agent.Presence = req.State
+	agent.StatusMessage = req.Message
+	agent.PresenceExpires = time.Time{}
+	if req.ExpiresIn > 0 {
+		agent.PresenceExpires = time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+	}
+	if err := s.store.Register(agent); err != nil {
+		logging.FromContext(r.Context()).Error("set presence failed", "agent_id", agentID, "err", err)
+		http.Error(w, "Presence update failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.presenceMessage(agentID, agent))
+}
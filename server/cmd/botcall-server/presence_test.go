@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/TheOrionAI/botcall-server/internal/signaling"
+)
+
+// sseStream reads presence events from /v1/presence/stream
+type sseStream struct {
+	t      *testing.T
+	events chan PresenceMessage
+}
+
+func openSSE(t *testing.T, ts *httptest.Server, agents string) *sseStream {
+	t.Helper()
+	resp, err := http.Get(ts.URL + "/v1/presence/stream?agents=" + agents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("Presence stream returned %d %s", resp.StatusCode, ct)
+	}
+
+	s := &sseStream{t: t, events: make(chan PresenceMessage, 16)}
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var msg PresenceMessage
+			json.Unmarshal([]byte(data), &msg)
+			s.events <- msg
+		}
+	}()
+	return s
+}
+
+func (s *sseStream) expect(agentID, status string) {
+	s.t.Helper()
+	select {
+	case msg := <-s.events:
+		if msg.Type != "presence" || msg.AgentID != agentID || msg.Status != status {
+			s.t.Fatalf("Got %+v, want %s %s", msg, agentID, status)
+		}
+	case <-time.After(2 * time.Second):
+		s.t.Fatalf("No presence event, want %s %s", agentID, status)
+	}
+}
+
+func TestPresenceStreamSSE(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+
+	stream := openSSE(t, ts, "orion,ghost")
+	stream.expect("orion", "online")
+	stream.expect("ghost", "offline")
+
+	// Keepalives do not repeat an unchanged status
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+	doJSON(t, http.MethodDelete, ts.URL+"/v1/register/orion", nil, map[string]string{"Authorization": "Bearer s3cret"})
+	stream.expect("orion", "offline")
+
+	register(t, ts, RegisterRequest{AgentID: "ghost", Endpoint: "10.0.0.2:9000"})
+	stream.expect("ghost", "online")
+}
+
+func TestPresenceStreamWebSocketBusy(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+	attachBot(t, ts, "orion", "s3cret", http.NotFoundHandler())
+
+	base := "ws" + strings.TrimPrefix(ts.URL, "http")
+	watcher, _, err := websocket.DefaultDialer.Dial(base+"/v1/presence/stream?agents=orion", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	expect := func(status string) {
+		t.Helper()
+		watcher.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg PresenceMessage
+		if err := watcher.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read presence: %v", err)
+		}
+		if msg.AgentID != "orion" || msg.Status != status {
+			t.Fatalf("Got %+v, want %s", msg, status)
+		}
+	}
+	expect("online")
+
+	human, _, err := websocket.DefaultDialer.Dial(base+"/v1/ws?agent=orion", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer human.Close()
+	human.WriteJSON(signaling.Message{Type: signaling.TypeCall, HumanID: "alice"})
+	expect("busy")
+
+	// Without max_concurrent_calls the bot shows in_call but still rings
+	second, _, err := websocket.DefaultDialer.Dial(base+"/v1/ws?agent=orion", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.WriteJSON(signaling.Message{Type: signaling.TypeCall, HumanID: "bob"})
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg signaling.Message
+		if err := second.ReadJSON(&msg); err != nil {
+			t.Fatalf("Second caller: %v", err)
+		}
+		if msg.Type == signaling.TypeRinging {
+			break
+		}
+		if msg.Type == signaling.TypeHangup {
+			t.Fatalf("Second call refused: %+v", msg)
+		}
+	}
+
+	human.Close()
+	second.Close()
+	expect("online")
+}
+
+func TestPresenceStreamRequiresAgents(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	if status, body := doJSON(t, http.MethodGet, ts.URL+"/v1/presence/stream", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("Stream without agents returned %d %v", status, body)
+	}
+}
+
+func TestSetPresence(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+	stream := openSSE(t, ts, "orion")
+	stream.expect("orion", "online")
+
+	dnd := PresenceRequest{State: "dnd", Message: "Stargazing", ExpiresIn: 600}
+	if status, _ := doJSON(t, http.MethodPut, ts.URL+"/v1/presence/orion", dnd, nil); status != http.StatusForbidden {
+		t.Errorf("Anonymous presence update returned %d", status)
+	}
+	auth := map[string]string{"Authorization": "Bearer s3cret"}
+	status, body := doJSON(t, http.MethodPut, ts.URL+"/v1/presence/orion", dnd, auth)
+	if status != http.StatusOK || body["presence"] != "dnd" || body["status"] != "busy" {
+		t.Fatalf("Set presence returned %d %v", status, body)
+	}
+	stream.expect("orion", "busy")
+
+	// Keepalive registrations keep the state the bot set
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+	_, lookup := doJSON(t, http.MethodGet, ts.URL+"/v1/lookup/orion", nil, nil)
+	if lookup["status"] != "busy" || lookup["presence"] != "dnd" || lookup["status_message"] != "Stargazing" {
+		t.Fatalf("Lookup = %v", lookup)
+	}
+	if msg, _ := lookup["message"].(string); !strings.Contains(msg, "try later") {
+		t.Errorf("Lookup message = %q", msg)
+	}
+	if retry, _ := lookup["retry_after"].(float64); retry < 590 || retry > 601 {
+		t.Errorf("retry_after = %v", lookup["retry_after"])
+	}
+
+	if status, body := doJSON(t, http.MethodPut, ts.URL+"/v1/presence/orion", PresenceRequest{State: "in_call"}, auth); status != http.StatusBadRequest {
+		t.Errorf("Setting in_call returned %d %v", status, body)
+	}
+
+	doJSON(t, http.MethodPut, ts.URL+"/v1/presence/orion", PresenceRequest{State: "available"}, auth)
+	stream.expect("orion", "online")
+}
+
+func TestBusyAgentRefusesCalls(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	register(t, ts, RegisterRequest{AgentID: "orion", Endpoint: "10.0.0.1:9000", RegistrationKey: "s3cret"})
+	attachBot(t, ts, "orion", "s3cret", http.NotFoundHandler())
+	doJSON(t, http.MethodPut, ts.URL+"/v1/presence/orion", PresenceRequest{State: "busy"}, map[string]string{"Authorization": "Bearer s3cret"})
+
+	human, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/v1/ws?agent=orion", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer human.Close()
+	human.WriteJSON(signaling.Message{Type: signaling.TypeCall, HumanID: "alice"})
+
+	human.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg signaling.Message
+		if err := human.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if msg.Type == signaling.TypeHangup {
+			if msg.Reason != signaling.ReasonBusy {
+				t.Fatalf("Hangup = %+v", msg)
+			}
+			return
+		}
+	}
+}
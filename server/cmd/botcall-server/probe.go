@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+	"github.com/TheOrionAI/botcall-server/internal/probe"
+)
+
+// statusDegraded is the lookup status of an online agent whose every live
+// instance is failing its health probes
+const statusDegraded = "degraded"
+
+// newProber builds the health prober from flags, or returns nil when
+// -probe-interval is zero
+func newProber(store discovery.Store) *probe.Prober {
+	if *probeInterval <= 0 {
+		return nil
+	}
+	return probe.New(store, probe.Config{
+		Interval:         *probeInterval,
+		Jitter:           *probeJitter,
+		Timeout:          *probeTimeout,
+		FailureThreshold: *probeFailures,
+		Workers:          *probeWorkers,
+		PresenceTTL:      *presenceTTL,
+		AllowPrivate:     *probePrivate,
+	})
+}
+
+// healthyInstances drops the instances the prober has degraded. Without a
+// prober every instance counts as healthy.
+func (s *Server) healthyInstances(agentID string, instances []discovery.Instance) []discovery.Instance {
+	if s.prober == nil {
+		return instances
+	}
+	var healthy []discovery.Instance
+	for _, inst := range instances {
+		if !s.prober.Degraded(agentID, inst.ID) {
+			healthy = append(healthy, inst)
+		}
+	}
+	return healthy
+}
+
+// instanceHealth is the probe history shown in lookups, or nil when the
+// instance is not probed. Lookups are public, so error text, which can
+// describe the network the probe reached, is left out.
+func (s *Server) instanceHealth(agentID, instanceID string) *probe.Health {
+	if s.prober == nil {
+		return nil
+	}
+	h, ok := s.prober.Health(agentID, instanceID)
+	if !ok {
+		return nil
+	}
+	for i := range h.Probes {
+		h.Probes[i].Error = ""
+	}
+	return &h
+}
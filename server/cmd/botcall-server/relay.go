@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/TheOrionAI/botcall-server/internal/logging"
+	"github.com/TheOrionAI/botcall-server/internal/signaling"
+	"github.com/TheOrionAI/botcall-server/internal/tracing"
+)
+
+const (
+	// modeRelay marks bots reachable only through their signaling socket
+	modeRelay = "relay"
+
+	relayTimeout = 15 * time.Second
+)
+
+// hopHeaders are connection-specific and never relayed
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func stripHopHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+	return h
+}
+
+// isTLS reports whether the client reached us over https, directly or
+// through a proxy
+func isTLS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// relayEndpoint is the URL humans POST to in place of a relay bot's /call
+func relayEndpoint(r *http.Request, agentID string) string {
+	scheme := "http"
+	if isTLS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/v1/call/" + url.PathEscape(agentID)
+}
+
+// tunnelURL is the public base URL proxied to a bot's local listener
+// through its socket
+func tunnelURL(r *http.Request, agentID string) string {
+	scheme := "http"
+	if isTLS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/t/" + url.PathEscape(agentID)
+}
+
+// relaySocketURL is the socket a relay bot dials to receive its traffic
+func relaySocketURL(r *http.Request, agentID string) string {
+	scheme := "ws"
+	if isTLS(r) {
+		scheme = "wss"
+	}
+	return scheme + "://" + r.Host + "/v1/ws?role=bot&agent=" + url.QueryEscape(agentID)
+}
+
+// handleRelayCall proxies POST /v1/call/{id} to the bot's /call handler
+// over the socket it holds open, for bots registered in relay mode
+func (s *Server) handleRelayCall(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+logging.RequestIDHeader+", traceparent, tracestate")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	agentID := strings.TrimPrefix(r.URL.Path, "/v1/call/")
+	if agentID == "" {
+		http.Error(w, "Missing agent ID", http.StatusBadRequest)
+		return
+	}
+	s.relay(w, r, agentID, "/call")
+}
+
+// handleTunnel proxies any request under /t/{id}/ to the bot attached
+// for id, which passes it on to its local HTTP listener
+func (s *Server) handleTunnel(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/t/")
+	agentID, path, _ := strings.Cut(rest, "/")
+	if agentID == "" {
+		http.Error(w, "Missing agent ID", http.StatusBadRequest)
+		return
+	}
+	s.relay(w, r, agentID, "/"+path)
+}
+
+// relay forwards r to path on the bot attached for agentID and copies the
+// bot's response back
+func (s *Server) relay(w http.ResponseWriter, r *http.Request, agentID, path string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, signaling.MaxRelayBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "relayed requests are limited to 1 MiB")
+		return
+	}
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+
+	header := stripHopHeaders(r.Header)
+	// Extend the chain earlier proxies built, as httputil.ReverseProxy does
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := header.Values("X-Forwarded-For"); len(prior) > 0 {
+			host = strings.Join(prior, ", ") + ", " + host
+		}
+		header.Set("X-Forwarded-For", host)
+	}
+	header.Set("X-Forwarded-Host", r.Host)
+	if isTLS(r) {
+		header.Set("X-Forwarded-Proto", "https")
+	} else {
+		header.Set("X-Forwarded-Proto", "http")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), relayTimeout)
+	defer cancel()
+
+	// The bot's spans hang off this one, not the caller's
+	ctx, span := tracing.Start(ctx, "relay.forward",
+		attribute.String("botcall.agent_id", agentID),
+		attribute.String("botcall.path", path),
+	)
+	defer span.End()
+	tracing.Inject(ctx, header)
+
+	resp, err := s.hub.Forward(ctx, agentID, &signaling.RelayRequest{
+		Method: r.Method,
+		Path:   path,
+		Header: header,
+		Body:   body,
+	})
+	if err != nil {
+		span.RecordError(err)
+	}
+	switch {
+	case errors.Is(err, signaling.ErrBotUnavailable):
+		writeError(w, http.StatusBadGateway, "bot_unavailable", "agent "+agentID+" has no relay or tunnel connection")
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, "bot_timeout", "agent "+agentID+" did not answer in time")
+		return
+	case err != nil:
+		// The human went away
+		return
+	}
+
+	if len(resp.Body) > signaling.MaxRelayBodySize {
+		writeError(w, http.StatusBadGateway, "response_too_large", "relayed responses are limited to 1 MiB")
+		return
+	}
+
+	for k, v := range stripHopHeaders(resp.Header) {
+		w.Header()[k] = v
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(resp.Body)
+}
+
+// relayDetached takes a bot reached only through its socket (relay mode,
+// or a tunnel URL as endpoint) offline when the socket drops, unless a new
+// socket has already replaced it
+func (s *Server) relayDetached(agentID string) {
+	if s.hub.BotOnline(agentID) {
+		return
+	}
+	agent, ok := s.store.Lookup(agentID)
+	if !ok {
+		return
+	}
+	tunnelled := strings.HasSuffix(agent.Endpoint, "/t/"+url.PathEscape(agentID))
+	if agent.Mode != modeRelay && !tunnelled {
+		return
+	}
+	if _, err := s.store.MarkOffline(agentID, time.Now()); err != nil {
+		s.logger.Error("mark offline failed", "agent_id", agentID, "err", err)
+		return
+	}
+	s.logger.Info("relay socket closed; agent offline", "agent_id", agentID)
+}
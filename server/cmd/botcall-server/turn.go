@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/TheOrionAI/botcall-server/internal/ice"
+	"github.com/TheOrionAI/botcall-server/internal/turnserver"
+)
+
+// startTURN starts the embedded STUN/TURN server when -turn-listen is set.
+// Unless STUN/TURN URLs and a secret were configured explicitly, peers are
+// pointed at it and it signs credentials with a generated secret.
+func startTURN() (*turnserver.Server, error) {
+	if *turnListen == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(*turnPublicIP)
+	if ip == nil {
+		return nil, fmt.Errorf("-turn-public-ip %q is not an IP address", *turnPublicIP)
+	}
+	minPort, maxPort, err := parsePortRange(*turnRelayPorts)
+	if err != nil {
+		return nil, err
+	}
+	if *turnSecret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		*turnSecret = hex.EncodeToString(buf)
+	}
+
+	srv, err := turnserver.Start(turnserver.Config{
+		ListenAddr:         *turnListen,
+		UDP:                true,
+		TCP:                true,
+		PublicIP:           ip,
+		Realm:              *turnRealm,
+		Secret:             *turnSecret,
+		RelayMinPort:       minPort,
+		RelayMaxPort:       maxPort,
+		MaxUserAllocations: *turnUserAllocations,
+		MaxAllocationBytes: *turnAllocationBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stun, turn := srv.URLs()
+	if *stunURLs == ice.DefaultSTUN {
+		*stunURLs = strings.Join(stun, ",")
+	}
+	if *turnURLs == "" {
+		*turnURLs = strings.Join(turn, ",")
+	}
+	return srv, nil
+}
+
+// parsePortRange parses "min-max"; empty lets the system pick relay ports
+func parsePortRange(value string) (min, max uint16, err error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	lo, hi, ok := strings.Cut(value, "-")
+	a, errLo := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	b, errHi := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+	if !ok || errLo != nil || errHi != nil || a == 0 || a > b {
+		return 0, 0, fmt.Errorf("relay port range %q is not min-max", value)
+	}
+	return uint16(a), uint16(b), nil
+}
+
+// handleTURNStats reports the embedded TURN server's counters
+func (s *Server) handleTURNStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "admin_required", "a valid admin bearer token is required")
+		return
+	}
+	if s.turn == nil {
+		writeError(w, http.StatusNotFound, "turn_disabled", "the embedded TURN server is not running")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.turn.Stats())
+}
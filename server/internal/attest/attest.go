@@ -0,0 +1,223 @@
+// Package attest verifies BotAuth attestation tokens: JWTs signed with
+// Ed25519 (EdDSA) or P-256 (ES256) by a trusted issuer, naming the bot's
+// agent ID as their subject.
+package attest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Error codes reported to bots when an attestation is rejected
+const (
+	CodeMissing         = "attestation_missing"
+	CodeMalformed       = "attestation_malformed"
+	CodeUnknownIssuer   = "attestation_unknown_issuer"
+	CodeUnknownKey      = "attestation_unknown_key"
+	CodeBadSignature    = "attestation_bad_signature"
+	CodeExpired         = "attestation_expired"
+	CodeNotYetValid     = "attestation_not_yet_valid"
+	CodeWrongAudience   = "attestation_wrong_audience"
+	CodeSubjectMismatch = "attestation_subject_mismatch"
+	CodeKeysUnavailable = "attestation_keys_unavailable"
+)
+
+// Error is a structured verification failure. It marshals directly into
+// the JSON error body returned by the discovery server.
+type Error struct {
+	Code    string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newError(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// DefaultAudience is the aud value expected when Config.Audience is empty
+const DefaultAudience = "botcall"
+
+// Config selects which tokens a Verifier accepts
+type Config struct {
+	// Issuers are the trusted iss values. Keys for an issuer come from
+	// JWKSFile when set, otherwise from <issuer>/.well-known/jwks.json.
+	Issuers []string
+	// JWKSFile is a local JWKS whose keys are trusted for every issuer
+	JWKSFile string
+	// Audience is the required aud claim
+	Audience string
+	// Leeway tolerates clock skew on exp, nbf and iat
+	Leeway time.Duration
+}
+
+// Verifier checks attestation tokens against a set of trusted issuers
+type Verifier struct {
+	issuers  map[string]KeySource
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// Claims are the verified contents of an attestation
+type Claims struct {
+	Issuer    string
+	Subject   string
+	ExpiresAt time.Time
+}
+
+// New builds a Verifier from cfg. At least one issuer is required.
+func New(cfg Config) (*Verifier, error) {
+	if len(cfg.Issuers) == 0 {
+		return nil, errors.New("attest: no trusted issuers configured")
+	}
+
+	var local *KeySet
+	if cfg.JWKSFile != "" {
+		ks, err := LoadJWKSFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("attest: %w", err)
+		}
+		local = ks
+	}
+
+	v := &Verifier{
+		issuers:  make(map[string]KeySource),
+		audience: cfg.Audience,
+		leeway:   cfg.Leeway,
+		now:      time.Now,
+	}
+	if v.audience == "" {
+		v.audience = DefaultAudience
+	}
+
+	for _, iss := range cfg.Issuers {
+		if local != nil {
+			v.issuers[iss] = local
+			continue
+		}
+		if !strings.HasPrefix(iss, "https://") {
+			return nil, fmt.Errorf("attest: issuer %q needs a JWKS file or an https:// URL", iss)
+		}
+		v.issuers[iss] = NewRemoteKeySet(strings.TrimSuffix(iss, "/")+"/.well-known/jwks.json", nil)
+	}
+	return v, nil
+}
+
+// NewWithKeys builds a Verifier that trusts the given issuer-to-keys map
+func NewWithKeys(issuers map[string]KeySource, audience string) *Verifier {
+	if audience == "" {
+		audience = DefaultAudience
+	}
+	return &Verifier{issuers: issuers, audience: audience, now: time.Now}
+}
+
+// Verify checks token and that its subject is agentID. Failures are
+// always returned as *Error.
+func (v *Verifier) Verify(ctx context.Context, token, agentID string) (*Claims, error) {
+	if token == "" {
+		return nil, newError(CodeMissing, "attestation token is required")
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithAudience(v.audience),
+		jwt.WithSubject(agentID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(v.leeway),
+		jwt.WithTimeFunc(v.now),
+	)
+
+	var claims jwt.RegisteredClaims
+	_, err := parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return v.keys(ctx, t, &claims)
+	})
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	out := &Claims{Issuer: claims.Issuer, Subject: claims.Subject}
+	if claims.ExpiresAt != nil {
+		out.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return out, nil
+}
+
+// keys resolves the verification keys for a parsed but unverified token
+func (v *Verifier) keys(ctx context.Context, t *jwt.Token, claims *jwt.RegisteredClaims) (interface{}, error) {
+	source, ok := v.issuers[claims.Issuer]
+	if !ok {
+		return nil, newError(CodeUnknownIssuer, "issuer %q is not trusted", claims.Issuer)
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	pubs, err := source.Keys(ctx, kid)
+	if err != nil {
+		return nil, newError(CodeKeysUnavailable, "keys for %s: %v", claims.Issuer, err)
+	}
+	if len(pubs) == 0 {
+		return nil, newError(CodeUnknownKey, "issuer %s has no key %q", claims.Issuer, kid)
+	}
+
+	set := jwt.VerificationKeySet{}
+	for _, pub := range pubs {
+		set.Keys = append(set.Keys, pub)
+	}
+	return set, nil
+}
+
+// classify maps jwt parse errors onto structured codes
+func classify(err error) *Error {
+	var attErr *Error
+	if errors.As(err, &attErr) {
+		return attErr
+	}
+
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return newError(CodeMalformed, "token is not a valid JWS")
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return newError(CodeBadSignature, "signature does not verify")
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return newError(CodeBadSignature, "token cannot be verified: %v", err)
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return newError(CodeExpired, "token is expired")
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return newError(CodeNotYetValid, "token is not valid yet")
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return newError(CodeWrongAudience, "token audience does not include this server")
+	case errors.Is(err, jwt.ErrTokenInvalidSubject):
+		return newError(CodeSubjectMismatch, "token subject does not match agent_id")
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return missingClaim(err)
+	default:
+		return newError(CodeMalformed, "%v", err)
+	}
+}
+
+// missingClaim reports which required claim a token lacks. jwt only names
+// the claim in its message ("exp claim is required"), and joins the errors
+// when several are missing.
+func missingClaim(err error) *Error {
+	missing := func(claim string) bool {
+		return strings.Contains(err.Error(), claim+" claim is required")
+	}
+	switch {
+	case missing("exp"):
+		return newError(CodeExpired, "token has no exp claim")
+	case missing("aud"):
+		return newError(CodeWrongAudience, "token has no aud claim")
+	case missing("sub"):
+		return newError(CodeSubjectMismatch, "token has no sub claim")
+	default:
+		return newError(CodeMalformed, "%v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package attest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testIssuer = "https://botauth.test"
+
+type testKeys struct {
+	ed    ed25519.PrivateKey
+	ec    *ecdsa.PrivateKey
+	jwks  []byte
+	other ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []JWK{
+			{Kty: "OKP", Crv: "Ed25519", X: b64(edPub), Kid: "ed-1"},
+			{Kty: "EC", Crv: "P-256", X: b64(ecPriv.X.FillBytes(make([]byte, 32))), Y: b64(ecPriv.Y.FillBytes(make([]byte, 32))), Kid: "ec-1"},
+			{Kty: "RSA", Kid: "ignored"},
+		},
+	})
+	return &testKeys{ed: edPriv, ec: ecPriv, jwks: jwks, other: otherPriv}
+}
+
+func claimsFor(sub string) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    testIssuer,
+		Subject:   sub,
+		Audience:  jwt.ClaimStrings{DefaultAudience},
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	tok.Header["kid"] = kid
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newVerifier(t *testing.T, keys *testKeys) *Verifier {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, keys.jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := New(Config{Issuers: []string{testIssuer}, JWKSFile: path})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return v
+}
+
+func TestVerifyAcceptsEd25519AndES256(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newVerifier(t, keys)
+
+	for name, token := range map[string]string{
+		"EdDSA": sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, claimsFor("orion")),
+		"ES256": sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, claimsFor("orion")),
+		"NoKid": sign(t, jwt.SigningMethodEdDSA, "", keys.ed, claimsFor("orion")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), token, "orion")
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if claims.Subject != "orion" || claims.Issuer != testIssuer || claims.ExpiresAt.IsZero() {
+				t.Errorf("Unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newVerifier(t, keys)
+
+	expired := claimsFor("orion")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	noExp := claimsFor("orion")
+	noExp.ExpiresAt = nil
+
+	future := claimsFor("orion")
+	future.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	wrongAud := claimsFor("orion")
+	wrongAud.Audience = jwt.ClaimStrings{"someone-else"}
+
+	noAud := claimsFor("orion")
+	noAud.Audience = nil
+
+	noSub := claimsFor("orion")
+	noSub.Subject = ""
+
+	wrongIss := claimsFor("orion")
+	wrongIss.Issuer = "https://evil.test"
+
+	tests := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{"Missing", "", CodeMissing},
+		{"Garbage", "demo-token", CodeMalformed},
+		{"Expired", sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, expired), CodeExpired},
+		{"NoExpiry", sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, noExp), CodeExpired},
+		{"NotYetValid", sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, future), CodeNotYetValid},
+		{"WrongAudience", sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, wrongAud), CodeWrongAudience},
+		{"NoAudience", sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, noAud), CodeWrongAudience},
+		{"WrongSubject", sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, claimsFor("gopi")), CodeSubjectMismatch},
+		{"NoSubject", sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, noSub), CodeSubjectMismatch},
+		{"UnknownIssuer", sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, wrongIss), CodeUnknownIssuer},
+		{"UnknownKid", sign(t, jwt.SigningMethodEdDSA, "nope", keys.ed, claimsFor("orion")), CodeUnknownKey},
+		{"ForgedSignature", sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.other, claimsFor("orion")), CodeBadSignature},
+		{"HMAC", sign(t, jwt.SigningMethodHS256, "ed-1", []byte("secret"), claimsFor("orion")), CodeBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(context.Background(), tt.token, "orion")
+			var attErr *Error
+			if !errors.As(err, &attErr) {
+				t.Fatalf("Expected *Error, got %v", err)
+			}
+			if attErr.Code != tt.code {
+				t.Errorf("Expected %s, got %s (%s)", tt.code, attErr.Code, attErr.Message)
+			}
+		})
+	}
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	keys := newTestKeys(t)
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(keys.jwks)
+	}))
+	defer srv.Close()
+
+	v := NewWithKeys(map[string]KeySource{testIssuer: NewRemoteKeySet(srv.URL, srv.Client())}, "")
+	token := sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed, claimsFor("orion"))
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), token, "orion"); err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected JWKS to be fetched once and cached, got %d fetches", fetches)
+	}
+}
+
+func TestRemoteKeySetFetchesOnce(t *testing.T) {
+	keys := newTestKeys(t)
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write(keys.jwks)
+	}))
+	defer srv.Close()
+	remote := NewRemoteKeySet(srv.URL, srv.Client())
+
+	// Callers arriving while a fetch runs wait for it instead of queueing
+	// fetches of their own
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			remote.Keys(context.Background(), "ed-1")
+		}()
+	}
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A caller that gives up is not held until the fetch ends
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := remote.Keys(ctx, "ed-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Keys with an expired context = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d fetches for one key, want 1", n)
+	}
+	if got, err := remote.Keys(context.Background(), "ed-1"); err != nil || len(got) != 1 {
+		t.Errorf("Keys after the fetch = %v, %v", got, err)
+	}
+}
+
+func TestRemoteKeySetBacksOffAfterFailure(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	remote := NewRemoteKeySet(srv.URL, srv.Client())
+
+	for i := 0; i < 5; i++ {
+		if _, err := remote.Keys(context.Background(), "kid-"+string(rune('a'+i))); err == nil {
+			t.Fatal("Keys succeeded with the issuer down")
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d fetches while the issuer is down, want 1", n)
+	}
+
+	// Once the minute is up the issuer is tried again
+	remote.mu.Lock()
+	remote.failedAt = remote.failedAt.Add(-remoteMinRefresh)
+	remote.mu.Unlock()
+	remote.Keys(context.Background(), "kid-a")
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("%d fetches after the backoff, want 2", n)
+	}
+}
+
+func TestNewRequiresIssuer(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("Expected error without issuers")
+	}
+	if _, err := New(Config{Issuers: []string{"plain-name"}}); err == nil {
+		t.Error("Expected error for non-URL issuer without a JWKS file")
+	}
+}
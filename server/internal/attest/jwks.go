@@ -0,0 +1,230 @@
+package attest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// JWK is the subset of RFC 7517 needed for Ed25519 (OKP) and P-256 (EC) keys
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+}
+
+// PublicKey decodes the JWK into an ed25519.PublicKey or *ecdsa.PublicKey
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("decode x: %w", err)
+	}
+
+	switch {
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("ed25519 key is %d bytes", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+
+	case k.Kty == "EC" && k.Crv == "P-256":
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("decode y: %w", err)
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC point is not on P-256")
+		}
+		return pub, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s/%s", k.Kty, k.Crv)
+	}
+}
+
+// KeySet is a parsed JWKS
+type KeySet struct {
+	keys []keyEntry
+}
+
+type keyEntry struct {
+	kid string
+	pub crypto.PublicKey
+}
+
+// ParseJWKS parses a JWKS document. Keys of unsupported types are skipped
+// so a shared JWKS that also carries RSA keys still loads.
+func ParseJWKS(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	ks := &KeySet{}
+	for _, k := range doc.Keys {
+		pub, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		ks.keys = append(ks.keys, keyEntry{kid: k.Kid, pub: pub})
+	}
+	if len(ks.keys) == 0 {
+		return nil, errors.New("JWKS has no usable Ed25519 or P-256 keys")
+	}
+	return ks, nil
+}
+
+// LoadJWKSFile reads and parses a JWKS file from disk
+func LoadJWKSFile(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read JWKS: %w", err)
+	}
+	return ParseJWKS(data)
+}
+
+// Lookup returns the keys matching kid, or every key when kid is empty
+func (ks *KeySet) Lookup(kid string) []crypto.PublicKey {
+	var out []crypto.PublicKey
+	for _, k := range ks.keys {
+		if kid == "" || k.kid == kid {
+			out = append(out, k.pub)
+		}
+	}
+	return out
+}
+
+// KeySource supplies verification keys for one issuer
+type KeySource interface {
+	Keys(ctx context.Context, kid string) ([]crypto.PublicKey, error)
+}
+
+// Keys implements KeySource for a static set
+func (ks *KeySet) Keys(_ context.Context, kid string) ([]crypto.PublicKey, error) {
+	return ks.Lookup(kid), nil
+}
+
+const (
+	remoteCacheTTL     = time.Hour
+	remoteMinRefresh   = time.Minute
+	remoteFetchTimeout = 10 * time.Second
+)
+
+// RemoteKeySet fetches an issuer's JWKS over HTTP and caches it. An
+// unknown kid triggers a refetch, at most once per minute, so issuers can
+// rotate keys without a server restart. One fetch runs at a time, outside
+// the lock, and callers that need it wait for its result; after a failed
+// fetch the issuer is left alone for a minute too.
+type RemoteKeySet struct {
+	URL    string
+	Client *http.Client
+
+	mu        sync.Mutex
+	cached    *KeySet
+	fetchedAt time.Time
+	// failedAt and fetchErr are the last failed fetch, cleared on success
+	failedAt time.Time
+	fetchErr error
+	// fetching is closed when the fetch in flight finishes
+	fetching chan struct{}
+}
+
+// NewRemoteKeySet returns a key source for the JWKS at url
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = &http.Client{Timeout: remoteFetchTimeout}
+	}
+	return &RemoteKeySet{URL: url, Client: client}
+}
+
+func (r *RemoteKeySet) Keys(ctx context.Context, kid string) ([]crypto.PublicKey, error) {
+	for {
+		r.mu.Lock()
+		age := time.Since(r.fetchedAt)
+		if r.cached != nil && age < remoteCacheTTL {
+			if keys := r.cached.Lookup(kid); len(keys) > 0 || age < remoteMinRefresh {
+				r.mu.Unlock()
+				return keys, nil
+			}
+		}
+		if r.fetchErr != nil && time.Since(r.failedAt) < remoteMinRefresh {
+			defer r.mu.Unlock()
+			if r.cached != nil {
+				// Serve stale keys rather than fail every registration
+				return r.cached.Lookup(kid), nil
+			}
+			return nil, r.fetchErr
+		}
+		if wait := r.fetching; wait != nil {
+			r.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		r.fetching = done
+		r.mu.Unlock()
+
+		// Others may be waiting on this fetch, so it outlives ctx
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), remoteFetchTimeout)
+		ks, err := r.fetch(fetchCtx)
+		cancel()
+
+		r.mu.Lock()
+		if err != nil {
+			r.fetchErr, r.failedAt = err, time.Now()
+		} else {
+			r.cached, r.fetchedAt, r.fetchErr = ks, time.Now(), nil
+		}
+		r.fetching = nil
+		close(done)
+		r.mu.Unlock()
+	}
+}
+
+func (r *RemoteKeySet) fetch(ctx context.Context) (*KeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWKS: %s returned %d", r.URL, resp.StatusCode)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+	return ParseJWKS(raw)
+}
@@ -0,0 +1,217 @@
+// Package balance picks which of an agent's instances a lookup sends a
+// human to. Every policy skips instances whose reported calls fill their
+// capacity. least_calls also counts the humans routed to an instance since
+// its last report, which stops a burst of callers piling onto one instance
+// between keepalives; lookups are not calls, though, so they never make an
+// instance full.
+//
+// Only lookups naming the human (?human=, as the PWA sends before every
+// call) are counted, and each human once: directory and status lookups,
+// and a caller retrying, see where a call would go without moving the
+// round-robin or weighing on least_calls.
+package balance
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+)
+
+// AssignWindow is how long a human routed to an instance weighs on it,
+// unless the instance reports its calls sooner
+const AssignWindow = 30 * time.Second
+
+// Balancer holds the per-agent state the policies need. It is safe for
+// concurrent use.
+type Balancer struct {
+	mu     sync.Mutex
+	agents map[string]*agentState
+}
+
+type agentState struct {
+	// current is the smooth weighted round-robin counter of each instance
+	current map[string]int
+	// routed remembers, by human ID, where lookups sent each human since
+	// that instance last reported its calls
+	routed map[string]route
+}
+
+type route struct {
+	instanceID string
+	at         time.Time
+}
+
+// New returns an empty Balancer
+func New() *Balancer {
+	return &Balancer{agents: make(map[string]*agentState)}
+}
+
+// Pick chooses one of instances for humanID under policy. With a humanID
+// the pick is remembered: it counts against the instance, and the human
+// is sent there again while it does. Without one nothing changes. Unknown
+// policies act as least_calls, as does sticky without a humanID. It
+// reports false when every instance is full.
+func (b *Balancer) Pick(agentID, policy, humanID string, instances []discovery.Instance, now time.Time) (discovery.Instance, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.agents[agentID]
+	if state == nil {
+		state = &agentState{current: make(map[string]int), routed: make(map[string]route)}
+		b.agents[agentID] = state
+	}
+	state.prune(instances, now)
+
+	var free []discovery.Instance
+	load := make(map[string]int, len(instances))
+	for _, inst := range instances {
+		load[inst.ID] += inst.ActiveCalls
+		if !inst.Full() {
+			free = append(free, inst)
+		}
+	}
+	for _, r := range state.routed {
+		load[r.instanceID]++
+	}
+	if len(free) == 0 {
+		return discovery.Instance{}, false
+	}
+	// Map order must not leak into which instance wins a tie
+	sort.Slice(free, func(i, j int) bool { return free[i].ID < free[j].ID })
+
+	counted := humanID != ""
+	if r, ok := state.routed[humanID]; ok && counted {
+		for _, inst := range free {
+			if inst.ID == r.instanceID {
+				return inst, true
+			}
+		}
+	}
+
+	var pick discovery.Instance
+	switch {
+	case policy == discovery.BalanceRoundRobin:
+		pick = state.roundRobin(free, counted)
+	case policy == discovery.BalanceSticky && counted:
+		pick = sticky(humanID, free)
+	default:
+		pick = leastCalls(free, load)
+	}
+	if counted {
+		state.routed[humanID] = route{instanceID: pick.ID, at: now}
+	}
+	return pick, true
+}
+
+// Reported clears the humans counted against an instance once it reports
+// its own call count, which already includes their calls
+func (b *Balancer) Reported(agentID, instanceID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if state := b.agents[agentID]; state != nil {
+		for human, r := range state.routed {
+			if r.instanceID == instanceID {
+				delete(state.routed, human)
+			}
+		}
+	}
+}
+
+// Forget drops the state kept for agentID
+func (b *Balancer) Forget(agentID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.agents, agentID)
+}
+
+// prune drops state for instances that are gone and routes older than
+// AssignWindow
+func (s *agentState) prune(instances []discovery.Instance, now time.Time) {
+	live := make(map[string]bool, len(instances))
+	for _, inst := range instances {
+		live[inst.ID] = true
+	}
+	for id := range s.current {
+		if !live[id] {
+			delete(s.current, id)
+		}
+	}
+	cutoff := now.Add(-AssignWindow)
+	for human, r := range s.routed {
+		if !r.at.After(cutoff) || !live[r.instanceID] {
+			delete(s.routed, human)
+		}
+	}
+}
+
+// leastCalls picks the instance with the lowest share of its capacity in
+// use, preferring the one with more room on a tie
+func leastCalls(free []discovery.Instance, load map[string]int) discovery.Instance {
+	best := free[0]
+	for _, inst := range free[1:] {
+		// load/capacity compared without division
+		lhs := load[inst.ID] * slots(best)
+		rhs := load[best.ID] * slots(inst)
+		if lhs < rhs || (lhs == rhs && slots(inst) > slots(best)) {
+			best = inst
+		}
+	}
+	return best
+}
+
+// slots is the capacity least_calls weighs an instance's load against; an
+// instance with no cap counts as one call at a time, though it is never full
+func slots(inst discovery.Instance) int {
+	if inst.Capacity() == 0 {
+		return 1
+	}
+	return inst.Capacity()
+}
+
+// roundRobin is nginx's smooth weighted round-robin: over any run of
+// picks each instance gets its weight's share, evenly interleaved. Unless
+// advance is set it only says which instance is next.
+func (s *agentState) roundRobin(free []discovery.Instance, advance bool) discovery.Instance {
+	total := 0
+	best := -1
+	next := make(map[string]int, len(free))
+	for n, inst := range free {
+		w := inst.EffectiveWeight()
+		next[inst.ID] = s.current[inst.ID] + w
+		total += w
+		if best < 0 || next[inst.ID] > next[free[best].ID] {
+			best = n
+		}
+	}
+	if advance {
+		for id, c := range next {
+			s.current[id] = c
+		}
+		s.current[free[best].ID] -= total
+	}
+	return free[best]
+}
+
+// sticky uses weighted rendezvous hashing: each human ranks the instances
+// the same way every time, so they keep reaching the same one, and when an
+// instance leaves only the humans it served move elsewhere
+func sticky(humanID string, free []discovery.Instance) discovery.Instance {
+	best, bestScore := free[0], math.Inf(-1)
+	for _, inst := range free {
+		h := fnv.New64a()
+		h.Write([]byte(humanID))
+		h.Write([]byte{0})
+		h.Write([]byte(inst.ID))
+		// Uniform in (0, 1)
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score := -float64(inst.EffectiveWeight()) / math.Log(u)
+		if score > bestScore {
+			best, bestScore = inst, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,150 @@
+package balance
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+)
+
+// picks looks up n times as humanID, or as n different humans if empty
+func picks(t *testing.T, b *Balancer, policy, humanID string, instances []discovery.Instance, n int) string {
+	t.Helper()
+	var got []string
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		human := humanID
+		if human == "" {
+			human = fmt.Sprintf("human-%d", i)
+		}
+		inst, ok := b.Pick("orion", policy, human, instances, now)
+		if !ok {
+			t.Fatalf("Pick %d found no free instance", i)
+		}
+		got = append(got, inst.ID)
+	}
+	return strings.Join(got, ",")
+}
+
+func TestLeastCalls(t *testing.T) {
+	b := New()
+	instances := []discovery.Instance{
+		{ID: "a", MaxConcurrentCalls: 4, ActiveCalls: 2},
+		{ID: "b", MaxConcurrentCalls: 2},
+		{ID: "c", MaxConcurrentCalls: 8, ActiveCalls: 2},
+	}
+	// b is empty; then c (2/8) beats a (2/4) and b (1/2), and wins the tie
+	// at half full by having the most room
+	if got := picks(t, b, discovery.BalanceLeastCalls, "", instances, 4); got != "b,c,c,c" {
+		t.Errorf("picks = %s", got)
+	}
+}
+
+func TestPickSkipsFullInstances(t *testing.T) {
+	instances := []discovery.Instance{{ID: "a", MaxConcurrentCalls: 1, ActiveCalls: 1}, {ID: "b", MaxConcurrentCalls: 1}}
+	for _, policy := range []string{discovery.BalanceLeastCalls, discovery.BalanceRoundRobin, discovery.BalanceSticky} {
+		b := New()
+		// Lookups alone never fill b
+		if got := picks(t, b, policy, "alice", instances, 3); got != "b,b,b" {
+			t.Errorf("%s picked %s, want b", policy, got)
+		}
+		instances[1].ActiveCalls = 1
+		if _, ok := b.Pick("orion", policy, "alice", instances, time.Now()); ok {
+			t.Errorf("%s picked an instance when all are full", policy)
+		}
+		instances[1].ActiveCalls = 0
+	}
+
+	// No stated cap: never full
+	uncapped := []discovery.Instance{{ID: "a", ActiveCalls: 50}}
+	if inst, ok := New().Pick("orion", discovery.BalanceLeastCalls, "", uncapped, time.Now()); !ok || inst.ID != "a" {
+		t.Errorf("Uncapped instance not picked: %+v, %v", inst, ok)
+	}
+}
+
+func TestLookupsWeighUntilReported(t *testing.T) {
+	b := New()
+	instances := []discovery.Instance{{ID: "a", MaxConcurrentCalls: 2}, {ID: "b", MaxConcurrentCalls: 2}}
+	if got := picks(t, b, discovery.BalanceLeastCalls, "", instances, 3); got != "a,b,a" {
+		t.Errorf("picks = %s", got)
+	}
+
+	// a's report includes the lookups routed to it
+	b.Reported("orion", "a")
+	if got := picks(t, b, discovery.BalanceLeastCalls, "", instances, 1); got != "a" {
+		t.Errorf("After report picked %s", got)
+	}
+
+	// Lookups that never turned into calls stop counting
+	later := time.Now().Add(AssignWindow + time.Second)
+	b.Pick("orion", discovery.BalanceLeastCalls, "late-1", instances, later)
+	if inst, _ := b.Pick("orion", discovery.BalanceLeastCalls, "late-2", instances, later); inst.ID != "b" {
+		t.Errorf("Expired lookups still weigh: picked %s", inst.ID)
+	}
+}
+
+func TestOnlyCallersAreCounted(t *testing.T) {
+	instances := []discovery.Instance{{ID: "a", MaxConcurrentCalls: 2}, {ID: "b", MaxConcurrentCalls: 2}}
+	for _, policy := range []string{discovery.BalanceLeastCalls, discovery.BalanceRoundRobin} {
+		b := New()
+		// Directory and status lookups name no human and change nothing
+		for i := 0; i < 5; i++ {
+			if inst, _ := b.Pick("orion", policy, "", instances, time.Now()); inst.ID != "a" {
+				t.Errorf("%s: anonymous lookup %d moved on to %s", policy, i, inst.ID)
+			}
+		}
+		// A human looking up again keeps their instance and counts once
+		if got := picks(t, b, policy, "alice", instances, 5); got != "a,a,a,a,a" {
+			t.Errorf("%s: alice got %s", policy, got)
+		}
+		if got := picks(t, b, policy, "bob", instances, 1); got != "b" {
+			t.Errorf("%s: bob got %s after alice", policy, got)
+		}
+	}
+}
+
+func TestRoundRobinWeights(t *testing.T) {
+	b := New()
+	instances := []discovery.Instance{
+		{ID: "a", Weight: 3, MaxConcurrentCalls: 100},
+		{ID: "b", MaxConcurrentCalls: 100},
+	}
+	if got := picks(t, b, discovery.BalanceRoundRobin, "", instances, 8); got != "a,a,b,a,a,a,b,a" {
+		t.Errorf("picks = %s", got)
+	}
+}
+
+func TestStickyByHuman(t *testing.T) {
+	instances := []discovery.Instance{
+		{ID: "a", MaxConcurrentCalls: 1000},
+		{ID: "b", MaxConcurrentCalls: 1000},
+		{ID: "c", MaxConcurrentCalls: 1000},
+	}
+	b := New()
+	first := make(map[string]string)
+	spread := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		human := fmt.Sprintf("human-%d", i)
+		inst, _ := b.Pick("orion", discovery.BalanceSticky, human, instances, time.Now())
+		first[human] = inst.ID
+		spread[inst.ID]++
+		if again, _ := b.Pick("orion", discovery.BalanceSticky, human, instances, time.Now()); again.ID != inst.ID {
+			t.Fatalf("%s moved from %s to %s", human, inst.ID, again.ID)
+		}
+	}
+	for _, inst := range instances {
+		if spread[inst.ID] < 60 {
+			t.Errorf("Instance %s got %d of 300 humans", inst.ID, spread[inst.ID])
+		}
+	}
+
+	// Losing c only moves the humans c served
+	for human, was := range first {
+		inst, _ := b.Pick("orion", discovery.BalanceSticky, human, instances[:2], time.Now())
+		if was != "c" && inst.ID != was {
+			t.Errorf("%s moved from %s to %s when c left", human, was, inst.ID)
+		}
+	}
+}
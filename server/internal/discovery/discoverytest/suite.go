@@ -0,0 +1,384 @@
+// Package discoverytest provides a conformance suite for discovery.Store
+// backends. A backend's tests call Run with a constructor for a fresh,
+// empty store.
+package discoverytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+)
+
+// Factory returns a new, empty store for a single subtest
+type Factory func(t *testing.T) discovery.Store
+
+// Run exercises the Store contract against the backend built by newStore
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s discovery.Store)
+	}{
+		{"RegisterLookup", testRegisterLookup},
+		{"RegisterReplaces", testRegisterReplaces},
+		{"LookupMissing", testLookupMissing},
+		{"ReturnsCopies", testReturnsCopies},
+		{"Profile", testProfile},
+		{"Touch", testTouch},
+		{"TouchMissing", testTouchMissing},
+		{"List", testList},
+		{"ListOnline", testListOnline},
+		{"MarkOffline", testMarkOffline},
+		{"MarkOfflineKeepsFreshAgents", testMarkOfflineKeepsFresh},
+		{"Evict", testEvict},
+		{"EvictKeepsOnlineAgents", testEvictKeepsOnline},
+		{"Deregister", testDeregister},
+		{"DeregisterMissing", testDeregisterMissing},
+		{"Watch", testWatch},
+		{"WatchPresence", testWatchPresence},
+		{"WatchClosesOnCancel", testWatchClosesOnCancel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func newAgent(id string) *discovery.Agent {
+	return &discovery.Agent{
+		ID:       id,
+		Endpoint: "192.168.1.100:9000",
+		Mode:     "direct",
+		Online:   true,
+		LastSeen: time.Now(),
+	}
+}
+
+func mustRegister(t *testing.T, s discovery.Store, agent *discovery.Agent) {
+	t.Helper()
+	if err := s.Register(agent); err != nil {
+		t.Fatalf("Register(%s): %v", agent.ID, err)
+	}
+}
+
+func testRegisterLookup(t *testing.T, s discovery.Store) {
+	mustRegister(t, s, newAgent("orion"))
+
+	found, ok := s.Lookup("orion")
+	if !ok {
+		t.Fatal("Expected to find agent")
+	}
+	if found.ID != "orion" || found.Endpoint != "192.168.1.100:9000" || found.Mode != "direct" {
+		t.Errorf("Unexpected agent: %+v", found)
+	}
+}
+
+func testRegisterReplaces(t *testing.T, s discovery.Store) {
+	mustRegister(t, s, newAgent("orion"))
+
+	replacement := newAgent("orion")
+	replacement.Endpoint = "10.0.0.1:9001"
+	mustRegister(t, s, replacement)
+
+	found, _ := s.Lookup("orion")
+	if found.Endpoint != "10.0.0.1:9001" {
+		t.Errorf("Expected endpoint to be replaced, got %s", found.Endpoint)
+	}
+	if n := len(s.ListOnline()); n != 1 {
+		t.Errorf("Expected 1 online agent after re-register, got %d", n)
+	}
+}
+
+func testLookupMissing(t *testing.T, s discovery.Store) {
+	if _, ok := s.Lookup("non-existent"); ok {
+		t.Error("Expected not to find non-existent agent")
+	}
+}
+
+func testReturnsCopies(t *testing.T, s discovery.Store) {
+	agent := newAgent("orion")
+	mustRegister(t, s, agent)
+	agent.Endpoint = "mutated-after-register"
+
+	found, _ := s.Lookup("orion")
+	found.Endpoint = "mutated-after-lookup"
+
+	again, _ := s.Lookup("orion")
+	if again.Endpoint != "192.168.1.100:9000" {
+		t.Errorf("Store shares memory with callers: endpoint is %s", again.Endpoint)
+	}
+}
+
+func testProfile(t *testing.T, s discovery.Store) {
+	agent := newAgent("orion")
+	agent.Profile = discovery.Profile{
+		DisplayName:        "Orion",
+		Modes:              []string{"voice", "text"},
+		Languages:          []string{"en-US"},
+		MaxConcurrentCalls: 3,
+		Tags:               []string{"astronomy"},
+	}
+	mustRegister(t, s, agent)
+	agent.Tags[0] = "mutated-after-register"
+
+	found, _ := s.Lookup("orion")
+	if found.DisplayName != "Orion" || found.MaxConcurrentCalls != 3 || len(found.Modes) != 2 || found.Languages[0] != "en-US" {
+		t.Fatalf("Profile not stored: %+v", found.Profile)
+	}
+	found.Tags[0] = "mutated-after-lookup"
+
+	again, _ := s.Lookup("orion")
+	if again.Tags[0] != "astronomy" {
+		t.Errorf("Store shares profile slices with callers: tags are %v", again.Tags)
+	}
+}
+
+func testTouch(t *testing.T, s discovery.Store) {
+	agent := newAgent("orion")
+	agent.Online = false
+	agent.LastSeen = time.Now().Add(-time.Hour)
+	mustRegister(t, s, agent)
+
+	if err := s.Touch("orion"); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+
+	found, _ := s.Lookup("orion")
+	if !found.LastSeen.After(agent.LastSeen) {
+		t.Error("Expected LastSeen to be updated")
+	}
+	if !found.Online {
+		t.Error("Expected Touch to mark the agent online")
+	}
+}
+
+func testTouchMissing(t *testing.T, s discovery.Store) {
+	if err := s.Touch("non-existent"); !errors.Is(err, discovery.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func testList(t *testing.T, s discovery.Store) {
+	mustRegister(t, s, newAgent("online"))
+	offline := newAgent("offline")
+	offline.Online = false
+	mustRegister(t, s, offline)
+
+	if n := len(s.List()); n != 2 {
+		t.Errorf("Expected List to include offline agents, got %d", n)
+	}
+}
+
+func testListOnline(t *testing.T, s discovery.Store) {
+	mustRegister(t, s, newAgent("online"))
+
+	offline := newAgent("offline")
+	offline.Online = false
+	mustRegister(t, s, offline)
+
+	online := s.ListOnline()
+	if len(online) != 1 || online[0].ID != "online" {
+		t.Errorf("Expected only the online agent, got %v", ids(online))
+	}
+}
+
+func testMarkOffline(t *testing.T, s discovery.Store) {
+	agent := newAgent("orion")
+	agent.LastSeen = time.Now().Add(-time.Hour)
+	mustRegister(t, s, agent)
+
+	changed, err := s.MarkOffline("orion", time.Now().Add(-time.Minute))
+	if err != nil || !changed {
+		t.Fatalf("MarkOffline = %v, %v; want true, nil", changed, err)
+	}
+	found, _ := s.Lookup("orion")
+	if found.Online {
+		t.Error("Expected agent to be offline")
+	}
+	if n := len(s.ListOnline()); n != 0 {
+		t.Errorf("Expected no online agents, got %d", n)
+	}
+
+	changed, _ = s.MarkOffline("orion", time.Now())
+	if changed {
+		t.Error("Expected MarkOffline on an offline agent to be a no-op")
+	}
+	if _, err := s.MarkOffline("non-existent", time.Now()); !errors.Is(err, discovery.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func testMarkOfflineKeepsFresh(t *testing.T, s discovery.Store) {
+	mustRegister(t, s, newAgent("orion"))
+
+	changed, err := s.MarkOffline("orion", time.Now().Add(-time.Minute))
+	if err != nil || changed {
+		t.Fatalf("MarkOffline = %v, %v; want false, nil", changed, err)
+	}
+	found, _ := s.Lookup("orion")
+	if !found.Online {
+		t.Error("Expected recently seen agent to stay online")
+	}
+}
+
+func testEvict(t *testing.T, s discovery.Store) {
+	agent := newAgent("orion")
+	agent.Online = false
+	agent.LastSeen = time.Now().Add(-48 * time.Hour)
+	mustRegister(t, s, agent)
+
+	removed, err := s.Evict("orion", time.Now().Add(-24*time.Hour))
+	if err != nil || !removed {
+		t.Fatalf("Evict = %v, %v; want true, nil", removed, err)
+	}
+	if _, ok := s.Lookup("orion"); ok {
+		t.Error("Expected evicted agent to be gone")
+	}
+	if _, err := s.Evict("orion", time.Now()); !errors.Is(err, discovery.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func testEvictKeepsOnline(t *testing.T, s discovery.Store) {
+	agent := newAgent("orion")
+	agent.LastSeen = time.Now().Add(-48 * time.Hour)
+	mustRegister(t, s, agent)
+
+	removed, err := s.Evict("orion", time.Now())
+	if err != nil || removed {
+		t.Fatalf("Evict = %v, %v; want false, nil", removed, err)
+	}
+	if _, ok := s.Lookup("orion"); !ok {
+		t.Error("Expected online agent to survive Evict")
+	}
+}
+
+func testDeregister(t *testing.T, s discovery.Store) {
+	mustRegister(t, s, newAgent("orion"))
+
+	if err := s.Deregister("orion"); err != nil {
+		t.Fatalf("Deregister: %v", err)
+	}
+	if _, ok := s.Lookup("orion"); ok {
+		t.Error("Expected agent to be gone after Deregister")
+	}
+	if n := len(s.ListOnline()); n != 0 {
+		t.Errorf("Expected no online agents, got %d", n)
+	}
+}
+
+func testDeregisterMissing(t *testing.T, s discovery.Store) {
+	if err := s.Deregister("non-existent"); !errors.Is(err, discovery.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func testWatch(t *testing.T, s discovery.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := s.Watch(ctx)
+
+	mustRegister(t, s, newAgent("orion"))
+	if err := s.Touch("orion"); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	if err := s.Deregister("orion"); err != nil {
+		t.Fatalf("Deregister: %v", err)
+	}
+
+	expectEvents(t, events, "orion",
+		discovery.EventRegistered,
+		discovery.EventOnline,
+		discovery.EventTouched,
+		discovery.EventOffline,
+		discovery.EventDeregistered,
+	)
+}
+
+func testWatchPresence(t *testing.T, s discovery.Store) {
+	agent := newAgent("orion")
+	agent.LastSeen = time.Now().Add(-time.Hour)
+	mustRegister(t, s, agent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := s.Watch(ctx)
+
+	if _, err := s.MarkOffline("orion", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("MarkOffline: %v", err)
+	}
+	if err := s.Touch("orion"); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	if _, err := s.MarkOffline("orion", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("MarkOffline: %v", err)
+	}
+
+	// The second MarkOffline is a no-op, so it must not emit anything
+	expectEvents(t, events, "orion",
+		discovery.EventOffline,
+		discovery.EventTouched,
+		discovery.EventOnline,
+	)
+	select {
+	case ev := <-events:
+		t.Fatalf("Unexpected %s event", ev.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func testWatchClosesOnCancel(t *testing.T, s discovery.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := s.Watch(ctx)
+	cancel()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("Watch channel not closed after cancel")
+		}
+	}
+}
+
+func expectEvents(t *testing.T, events <-chan discovery.Event, agentID string, want ...discovery.EventType) {
+	t.Helper()
+	for _, typ := range want {
+		ev := nextEvent(t, events)
+		if ev.Type != typ {
+			t.Fatalf("Expected %s event, got %s", typ, ev.Type)
+		}
+		if ev.Agent == nil || ev.Agent.ID != agentID {
+			t.Fatalf("Expected %s event for %s, got %+v", typ, agentID, ev.Agent)
+		}
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan discovery.Event) discovery.Event {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("Watch channel closed unexpectedly")
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	return discovery.Event{}
+}
+
+func ids(agents []*discovery.Agent) []string {
+	out := make([]string, len(agents))
+	for i, a := range agents {
+		out[i] = a.ID
+	}
+	return out
+}
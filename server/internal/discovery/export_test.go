@@ -0,0 +1,8 @@
+package discovery
+
+// SetSnapshotEvery lets tests force frequent log compaction
+func SetSnapshotEvery(s *FileStore, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshotEvery = n
+}
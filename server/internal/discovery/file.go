@@ -0,0 +1,411 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	logFileName      = "agents.log"
+	snapshotFileName = "agents.snapshot"
+
+	// defaultSnapshotEvery is how many log records accumulate before the
+	// log is folded into a fresh snapshot
+	defaultSnapshotEvery = 1000
+)
+
+type logOp string
+
+const (
+	opPut     logOp = "put"
+	opTouch   logOp = "touch"
+	opOffline logOp = "offline"
+	opDel     logOp = "del"
+)
+
+// logRecord is one line of the append-only log. Records are idempotent so
+// replaying a log on top of a newer snapshot is harmless.
+type logRecord struct {
+	Op    logOp     `json:"op"`
+	ID    string    `json:"id,omitempty"`
+	Agent *Agent    `json:"agent,omitempty"`
+	At    time.Time `json:"at,omitempty"`
+}
+
+// FileStore is a durable Store. Every change is appended to a checksummed
+// log and fsynced before it is applied; the log is periodically compacted
+// into a snapshot. Reads are served from an in-memory DiscoveryStore.
+type FileStore struct {
+	mem *DiscoveryStore
+	dir string
+
+	mu            sync.Mutex // serializes writers and guards the fields below
+	log           *os.File
+	records       int
+	snapshotEvery int
+	closed        bool
+}
+
+var _ Store = (*FileStore)(nil)
+
+// OpenFileStore loads the registry persisted in dir, creating the
+// directory if needed. A torn record at the end of the log, left by a
+// crash mid-write, is discarded.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+
+	s := &FileStore{
+		mem:           NewDiscoveryStore(),
+		dir:           dir,
+		snapshotEvery: defaultSnapshotEvery,
+	}
+
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replayLog(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(s.path(logFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open log: %w", err)
+	}
+	s.log = f
+
+	slog.Info("loaded agents", "count", len(s.mem.agents), "dir", dir)
+	return s, nil
+}
+
+func (s *FileStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(s.path(snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var agents []*Agent
+	if err := json.Unmarshal(data, &agents); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	for _, agent := range agents {
+		s.mem.agents[agent.ID] = agent
+	}
+	return nil
+}
+
+// replayLog applies every intact record and truncates anything after the
+// last one, so the next append starts on a clean line.
+func (s *FileStore) replayLog() error {
+	f, err := os.OpenFile(s.path(logFileName), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer f.Close()
+
+	var good int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read log: %w", err)
+		}
+		rec, ok := decodeRecord(line)
+		if !ok {
+			break
+		}
+		s.apply(rec)
+		s.records++
+		good += int64(len(line))
+	}
+
+	if info, err := f.Stat(); err == nil && info.Size() > good {
+		slog.Warn("discarding torn log tail", "bytes", info.Size()-good, "dir", s.dir)
+		if err := f.Truncate(good); err != nil {
+			return fmt.Errorf("truncate log: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("sync log: %w", err)
+		}
+	}
+	return nil
+}
+
+// encodeRecord frames a record as "<crc32> <json>\n"
+func encodeRecord(rec logRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Appendf(nil, "%08x ", crc32.ChecksumIEEE(payload))
+	line = append(line, payload...)
+	return append(line, '\n'), nil
+}
+
+func decodeRecord(line []byte) (logRecord, bool) {
+	var rec logRecord
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if len(line) < 10 || line[8] != ' ' {
+		return rec, false
+	}
+	var sum uint32
+	if _, err := fmt.Sscanf(string(line[:8]), "%08x", &sum); err != nil {
+		return rec, false
+	}
+	payload := line[9:]
+	if crc32.ChecksumIEEE(payload) != sum {
+		return rec, false
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, false
+	}
+	return rec, true
+}
+
+// apply mutates the in-memory state without publishing events
+func (s *FileStore) apply(rec logRecord) {
+	switch rec.Op {
+	case opPut:
+		if rec.Agent != nil {
+			s.mem.agents[rec.Agent.ID] = rec.Agent
+		}
+	case opTouch:
+		if agent, ok := s.mem.agents[rec.ID]; ok {
+			agent.LastSeen = rec.At
+			agent.Online = true
+		}
+	case opOffline:
+		if agent, ok := s.mem.agents[rec.ID]; ok && agent.LastSeen.Before(rec.At) {
+			agent.Online = false
+		}
+	case opDel:
+		delete(s.mem.agents, rec.ID)
+	}
+}
+
+// append durably writes a record. Callers hold s.mu.
+func (s *FileStore) append(rec logRecord) error {
+	if s.closed {
+		return errors.New("discovery: store closed")
+	}
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return fmt.Errorf("encode record: %w", err)
+	}
+	if _, err := s.log.Write(line); err != nil {
+		return fmt.Errorf("append log: %w", err)
+	}
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("sync log: %w", err)
+	}
+	s.records++
+	return nil
+}
+
+// maybeSnapshot compacts the log once it has grown past snapshotEvery.
+// Failure is logged rather than returned: the change itself is already
+// durable in the log. Callers hold s.mu.
+func (s *FileStore) maybeSnapshot() {
+	if s.records < s.snapshotEvery {
+		return
+	}
+	if err := s.snapshot(); err != nil {
+		slog.Error("snapshot failed", "dir", s.dir, "err", err)
+	}
+}
+
+// snapshot writes the full registry to a new snapshot file, atomically
+// swaps it in and starts an empty log. Callers hold s.mu.
+func (s *FileStore) snapshot() error {
+	s.mem.mu.RLock()
+	agents := make([]*Agent, 0, len(s.mem.agents))
+	for _, agent := range s.mem.agents {
+		agents = append(agents, agent.Clone())
+	}
+	s.mem.mu.RUnlock()
+
+	data, err := json.Marshal(agents)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	if err := writeFileSync(s.path(snapshotFileName), data); err != nil {
+		return err
+	}
+
+	// A crash before the truncate only means the old records get replayed
+	// over the new snapshot, which is idempotent.
+	if err := s.log.Truncate(0); err != nil {
+		return fmt.Errorf("truncate log: %w", err)
+	}
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("sync log: %w", err)
+	}
+	s.records = 0
+	return nil
+}
+
+// writeFileSync atomically replaces path with data via a synced temp file
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", tmp, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync %s: %w", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("rename %s: %w", tmp, err)
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func (s *FileStore) Register(agent *Agent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(logRecord{Op: opPut, Agent: agent}); err != nil {
+		return err
+	}
+	s.mem.Register(agent)
+	s.maybeSnapshot()
+	return nil
+}
+
+func (s *FileStore) Lookup(agentID string) (*Agent, bool) {
+	return s.mem.Lookup(agentID)
+}
+
+func (s *FileStore) Touch(agentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.mem.Lookup(agentID); !ok {
+		return ErrNotFound
+	}
+	now := time.Now()
+	if err := s.append(logRecord{Op: opTouch, ID: agentID, At: now}); err != nil {
+		return err
+	}
+	s.mem.touchAt(agentID, now)
+	s.maybeSnapshot()
+	return nil
+}
+
+func (s *FileStore) List() []*Agent {
+	return s.mem.List()
+}
+
+func (s *FileStore) ListOnline() []*Agent {
+	return s.mem.ListOnline()
+}
+
+func (s *FileStore) MarkOffline(agentID string, cutoff time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.mem.Lookup(agentID)
+	if !ok {
+		return false, ErrNotFound
+	}
+	if !agent.Online || !agent.LastSeen.Before(cutoff) {
+		return false, nil
+	}
+	if err := s.append(logRecord{Op: opOffline, ID: agentID, At: cutoff}); err != nil {
+		return false, err
+	}
+	changed, err := s.mem.MarkOffline(agentID, cutoff)
+	s.maybeSnapshot()
+	return changed, err
+}
+
+func (s *FileStore) Evict(agentID string, cutoff time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.mem.Lookup(agentID)
+	if !ok {
+		return false, ErrNotFound
+	}
+	if agent.Online || !agent.LastSeen.Before(cutoff) {
+		return false, nil
+	}
+	if err := s.append(logRecord{Op: opDel, ID: agentID}); err != nil {
+		return false, err
+	}
+	changed, err := s.mem.Evict(agentID, cutoff)
+	s.maybeSnapshot()
+	return changed, err
+}
+
+func (s *FileStore) Deregister(agentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.mem.Lookup(agentID); !ok {
+		return ErrNotFound
+	}
+	if err := s.append(logRecord{Op: opDel, ID: agentID}); err != nil {
+		return err
+	}
+	s.mem.Deregister(agentID)
+	s.maybeSnapshot()
+	return nil
+}
+
+func (s *FileStore) Watch(ctx context.Context) <-chan Event {
+	return s.mem.Watch(ctx)
+}
+
+// Close writes a final snapshot and releases the log file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	err := s.snapshot()
+	s.closed = true
+	if cerr := s.log.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package discovery_test
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+	"github.com/TheOrionAI/botcall-server/internal/discovery/discoverytest"
+)
+
+func openFileStore(t *testing.T, dir string) *discovery.FileStore {
+	t.Helper()
+	s, err := discovery.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	return s
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	discoverytest.Run(t, func(t *testing.T) discovery.Store {
+		s := openFileStore(t, t.TempDir())
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	seen := time.Now().Add(-time.Minute).Round(0)
+
+	s := openFileStore(t, dir)
+	s.Register(&discovery.Agent{ID: "orion", Endpoint: "10.0.0.1:9000", Mode: "direct", Online: true, LastSeen: seen})
+	s.Register(&discovery.Agent{ID: "gone", Endpoint: "10.0.0.2:9000", Online: true, LastSeen: seen})
+	s.Touch("orion")
+	s.Deregister("gone")
+	touched, _ := s.Lookup("orion")
+
+	// Reopen without Close so state comes from the log alone
+	reopened := openFileStore(t, dir)
+	defer reopened.Close()
+
+	found, ok := reopened.Lookup("orion")
+	if !ok {
+		t.Fatal("Expected orion to survive restart")
+	}
+	if found.Endpoint != "10.0.0.1:9000" || found.Mode != "direct" {
+		t.Errorf("Unexpected agent after restart: %+v", found)
+	}
+	if !found.LastSeen.Equal(touched.LastSeen) {
+		t.Errorf("Expected LastSeen %v, got %v", touched.LastSeen, found.LastSeen)
+	}
+	if _, ok := reopened.Lookup("gone"); ok {
+		t.Error("Expected deregistered agent to stay gone")
+	}
+}
+
+func TestFileStoreSnapshotCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	discovery.SetSnapshotEvery(s, 3)
+
+	for i := 0; i < 10; i++ {
+		s.Register(&discovery.Agent{ID: fmt.Sprintf("bot-%d", i), Endpoint: "10.0.0.1:9000", Online: true, LastSeen: time.Now()})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "agents.snapshot")); err != nil {
+		t.Fatalf("Expected a snapshot to be written: %v", err)
+	}
+
+	reopened := openFileStore(t, dir)
+	defer reopened.Close()
+	if n := len(reopened.ListOnline()); n != 10 {
+		t.Errorf("Expected 10 agents after compaction and restart, got %d", n)
+	}
+}
+
+func TestFileStoreDiscardsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	s.Register(&discovery.Agent{ID: "orion", Endpoint: "10.0.0.1:9000", Online: true, LastSeen: time.Now()})
+
+	// Simulate a crash halfway through writing the next record
+	f, err := os.OpenFile(filepath.Join(dir, "agents.log"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`1234abcd {"op":"put","agent":{"agent_id":"half`)
+	f.Close()
+
+	reopened := openFileStore(t, dir)
+	if _, ok := reopened.Lookup("orion"); !ok {
+		t.Fatal("Expected records before the torn write to survive")
+	}
+	if _, ok := reopened.Lookup("half"); ok {
+		t.Fatal("Expected torn record to be discarded")
+	}
+
+	// The log must accept new records cleanly after recovery
+	reopened.Register(&discovery.Agent{ID: "after", Endpoint: "10.0.0.3:9000", Online: true, LastSeen: time.Now()})
+	again := openFileStore(t, dir)
+	defer again.Close()
+	if _, ok := again.Lookup("after"); !ok {
+		t.Fatal("Expected record written after recovery to survive")
+	}
+}
+
+// TestFileStoreCrashRecovery kills a writer process with SIGKILL while it
+// is registering agents, then checks every acknowledged write survived.
+func TestFileStoreCrashRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("spawns a subprocess")
+	}
+	dir := t.TempDir()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFileStoreCrashHelper$")
+	cmd.Env = append(os.Environ(), "BOTCALL_CRASH_DIR="+dir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	acked := -1
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if n, err := strconv.Atoi(scanner.Text()); err == nil {
+			acked = n
+		}
+		if acked >= 200 {
+			break
+		}
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	if acked < 0 {
+		t.Fatal("Helper process acknowledged no writes")
+	}
+
+	s := openFileStore(t, dir)
+	defer s.Close()
+	for i := 0; i <= acked; i++ {
+		if _, ok := s.Lookup(fmt.Sprintf("bot-%d", i)); !ok {
+			t.Fatalf("Acknowledged agent bot-%d lost after crash (acked %d)", i, acked)
+		}
+	}
+}
+
+// TestFileStoreCrashHelper is the writer half of TestFileStoreCrashRecovery
+func TestFileStoreCrashHelper(t *testing.T) {
+	dir := os.Getenv("BOTCALL_CRASH_DIR")
+	if dir == "" {
+		t.Skip("helper process only")
+	}
+	s, err := discovery.OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discovery.SetSnapshotEvery(s, 50)
+	for i := 0; ; i++ {
+		agent := &discovery.Agent{ID: fmt.Sprintf("bot-%d", i), Endpoint: "10.0.0.1:9000", Online: true, LastSeen: time.Now()}
+		if err := s.Register(agent); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println(i)
+	}
+}
@@ -0,0 +1,176 @@
+package discovery
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultInstance names the instance of bots that register without an
+// instance ID, so each such registration replaces the previous one
+const DefaultInstance = "default"
+
+// Limits on instance registrations
+const (
+	MaxInstanceIDLen  = 64
+	MaxInstanceWeight = 1000
+)
+
+// Load-balancing policies choosing among an agent's instances on lookup
+const (
+	// BalanceLeastCalls picks the instance with the fewest calls, relative
+	// to its capacity
+	BalanceLeastCalls = "least_calls"
+	// BalanceRoundRobin rotates through instances in proportion to Weight
+	BalanceRoundRobin = "round_robin"
+	// BalanceSticky sends each human to the same instance while it lives
+	BalanceSticky = "sticky"
+)
+
+// ValidBalance reports whether policy names a load-balancing policy
+func ValidBalance(policy string) bool {
+	switch policy {
+	case BalanceLeastCalls, BalanceRoundRobin, BalanceSticky:
+		return true
+	}
+	return false
+}
+
+// Instance is one replica of a bot registered under a shared agent ID.
+// Each registers and keeps itself alive on its own.
+type Instance struct {
+	ID       string `json:"instance_id"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Mode     string `json:"mode"`
+	// Weight is the instance's share of round_robin picks; zero counts as one
+	Weight int `json:"weight,omitempty"`
+	// MaxConcurrentCalls is how many calls the instance takes at once;
+	// zero means no stated cap
+	MaxConcurrentCalls int `json:"max_concurrent_calls,omitempty"`
+	// ActiveCalls is the call count the instance last reported
+	ActiveCalls int       `json:"active_calls"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// Capacity is the number of calls the instance takes at once, or zero
+// when it states no cap
+func (i Instance) Capacity() int {
+	if i.MaxConcurrentCalls <= 0 {
+		return 0
+	}
+	return i.MaxConcurrentCalls
+}
+
+// Full reports whether the instance's reported calls fill its capacity;
+// an instance with no cap is never full
+func (i Instance) Full() bool {
+	return i.Capacity() > 0 && i.ActiveCalls >= i.Capacity()
+}
+
+// EffectiveWeight is Weight with the zero value counted as one
+func (i Instance) EffectiveWeight() int {
+	if i.Weight <= 0 {
+		return 1
+	}
+	return i.Weight
+}
+
+// Validate checks the limits a registration must respect
+func (i Instance) Validate() error {
+	switch {
+	case len(i.ID) > MaxInstanceIDLen:
+		return fmt.Errorf("instance_id is longer than %d bytes", MaxInstanceIDLen)
+	case i.Weight < 0 || i.Weight > MaxInstanceWeight:
+		return fmt.Errorf("weight must be between 0 and %d", MaxInstanceWeight)
+	case i.ActiveCalls < 0:
+		return fmt.Errorf("active_calls must not be negative")
+	}
+	return nil
+}
+
+// PutInstance adds inst or replaces the instance with its ID, drops
+// instances not seen since cutoff, and points the agent's Endpoint and
+// Mode at inst
+func (a *Agent) PutInstance(inst Instance, cutoff time.Time) {
+	kept := []Instance{inst}
+	for _, other := range a.Instances {
+		if other.ID != inst.ID && !other.LastSeen.Before(cutoff) {
+			kept = append(kept, other)
+		}
+	}
+	a.Instances = kept
+	a.Endpoint = inst.Endpoint
+	a.Mode = inst.Mode
+}
+
+// RemoveInstance drops the instance with the given ID and reports whether
+// it was there. Endpoint and Mode move to the most recently seen of the
+// remaining instances.
+func (a *Agent) RemoveInstance(id string) bool {
+	for n, inst := range a.Instances {
+		if inst.ID != id {
+			continue
+		}
+		a.Instances = append(a.Instances[:n:n], a.Instances[n+1:]...)
+		if latest, ok := latestInstance(a.Instances); ok {
+			a.Endpoint = latest.Endpoint
+			a.Mode = latest.Mode
+		}
+		return true
+	}
+	return false
+}
+
+// LiveInstances returns the instances of an online agent seen since
+// cutoff. An online agent always has one: if every instance is older, for
+// example because its signaling socket keeps it alive, the latest counts.
+// Agents stored before instances existed get a DefaultInstance built from
+// Endpoint and Mode.
+func (a *Agent) LiveInstances(cutoff time.Time) []Instance {
+	if !a.Online {
+		return nil
+	}
+	if len(a.Instances) == 0 {
+		return []Instance{{
+			ID:                 DefaultInstance,
+			Endpoint:           a.Endpoint,
+			Mode:               a.Mode,
+			MaxConcurrentCalls: a.MaxConcurrentCalls,
+			LastSeen:           a.LastSeen,
+		}}
+	}
+
+	var live []Instance
+	for _, inst := range a.Instances {
+		if !inst.LastSeen.Before(cutoff) {
+			live = append(live, inst)
+		}
+	}
+	if len(live) == 0 {
+		latest, _ := latestInstance(a.Instances)
+		live = append(live, latest)
+	}
+	return live
+}
+
+// Capacity is the number of calls the agent's live instances take at
+// once, or zero when any of them states no cap
+func (a *Agent) Capacity(cutoff time.Time) int {
+	total := 0
+	for _, inst := range a.LiveInstances(cutoff) {
+		if inst.Capacity() == 0 {
+			return 0
+		}
+		total += inst.Capacity()
+	}
+	return total
+}
+
+func latestInstance(instances []Instance) (Instance, bool) {
+	var latest Instance
+	for n, inst := range instances {
+		if n == 0 || inst.LastSeen.After(latest.LastSeen) {
+			latest = inst
+		}
+	}
+	return latest, len(instances) > 0
+}
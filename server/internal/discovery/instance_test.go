@@ -0,0 +1,69 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPutInstance(t *testing.T) {
+	now := time.Now()
+	cutoff := now.Add(-time.Minute)
+	a := &Agent{ID: "orion", Online: true}
+	a.PutInstance(Instance{ID: "a", Endpoint: "10.0.0.1:9000", LastSeen: now.Add(-2 * time.Minute)}, cutoff.Add(-time.Hour))
+	a.PutInstance(Instance{ID: "b", Endpoint: "10.0.0.2:9000", LastSeen: now}, cutoff.Add(-time.Hour))
+	if len(a.Instances) != 2 || a.Endpoint != "10.0.0.2:9000" {
+		t.Fatalf("After two instances: %+v", a)
+	}
+
+	// Registering again replaces b and drops a, which missed the cutoff
+	a.PutInstance(Instance{ID: "b", Endpoint: "10.0.0.3:9000", LastSeen: now}, cutoff)
+	if len(a.Instances) != 1 || a.Instances[0].Endpoint != "10.0.0.3:9000" {
+		t.Fatalf("Instances = %+v", a.Instances)
+	}
+
+	c := a.Clone()
+	c.Instances[0].Endpoint = "changed"
+	if a.Instances[0].Endpoint == "changed" {
+		t.Error("Clone shares Instances")
+	}
+}
+
+func TestLiveInstances(t *testing.T) {
+	now := time.Now()
+	cutoff := now.Add(-time.Minute)
+	a := &Agent{ID: "orion", Online: true, Instances: []Instance{
+		{ID: "old", LastSeen: now.Add(-time.Hour)},
+		{ID: "older", LastSeen: now.Add(-2 * time.Hour)},
+	}}
+	// An online agent whose instances all lapsed still has its latest
+	if live := a.LiveInstances(cutoff); len(live) != 1 || live[0].ID != "old" {
+		t.Errorf("LiveInstances = %+v", live)
+	}
+
+	a.Instances = append(a.Instances, Instance{ID: "new", MaxConcurrentCalls: 3, LastSeen: now})
+	if live := a.LiveInstances(cutoff); len(live) != 1 || live[0].ID != "new" {
+		t.Errorf("LiveInstances = %+v", live)
+	}
+	if got := a.Capacity(cutoff); got != 3 {
+		t.Errorf("Capacity = %d, want 3", got)
+	}
+	// One instance without a cap lifts the agent's
+	a.Instances = append(a.Instances, Instance{ID: "open", LastSeen: now})
+	if got := a.Capacity(cutoff); got != 0 {
+		t.Errorf("Capacity with an uncapped instance = %d, want 0", got)
+	}
+	a.Instances = a.Instances[:len(a.Instances)-1]
+
+	if !a.RemoveInstance("new") || a.RemoveInstance("new") {
+		t.Error("RemoveInstance did not report removal once")
+	}
+
+	legacy := &Agent{ID: "vega", Online: true, Endpoint: "10.0.0.9:9000", Mode: "direct"}
+	if live := legacy.LiveInstances(cutoff); len(live) != 1 || live[0].ID != DefaultInstance || live[0].Endpoint != legacy.Endpoint {
+		t.Errorf("Legacy LiveInstances = %+v", live)
+	}
+	legacy.Online = false
+	if live := legacy.LiveInstances(cutoff); live != nil {
+		t.Errorf("Offline LiveInstances = %+v", live)
+	}
+}
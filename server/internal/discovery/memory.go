@@ -0,0 +1,179 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// watchBuffer is the per-subscriber event backlog before events are dropped
+const watchBuffer = 64
+
+// DiscoveryStore holds registered agents in memory
+type DiscoveryStore struct {
+	mu     sync.RWMutex
+	agents map[string]*Agent
+
+	watchMu  sync.Mutex
+	watchers map[chan Event]struct{}
+}
+
+var _ Store = (*DiscoveryStore)(nil)
+
+func NewDiscoveryStore() *DiscoveryStore {
+	return &DiscoveryStore{
+		agents:   make(map[string]*Agent),
+		watchers: make(map[chan Event]struct{}),
+	}
+}
+
+func (s *DiscoveryStore) Register(agent *Agent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wasOnline := false
+	if prev, ok := s.agents[agent.ID]; ok {
+		wasOnline = prev.Online
+	}
+	stored := agent.Clone()
+	s.agents[agent.ID] = stored
+	slog.Info("registered agent", "agent_id", agent.ID, "endpoint", agent.Endpoint)
+	s.publish(EventRegistered, stored)
+	if stored.Online && !wasOnline {
+		s.publish(EventOnline, stored)
+	}
+	return nil
+}
+
+func (s *DiscoveryStore) Lookup(agentID string) (*Agent, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return nil, false
+	}
+	return agent.Clone(), true
+}
+
+func (s *DiscoveryStore) List() []*Agent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]*Agent, 0, len(s.agents))
+	for _, agent := range s.agents {
+		all = append(all, agent.Clone())
+	}
+	return all
+}
+
+func (s *DiscoveryStore) ListOnline() []*Agent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var online []*Agent
+	for _, agent := range s.agents {
+		if agent.Online {
+			online = append(online, agent.Clone())
+		}
+	}
+	return online
+}
+
+func (s *DiscoveryStore) Touch(agentID string) error {
+	return s.touchAt(agentID, time.Now())
+}
+
+func (s *DiscoveryStore) touchAt(agentID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return ErrNotFound
+	}
+	wasOnline := agent.Online
+	agent.LastSeen = at
+	agent.Online = true
+	s.publish(EventTouched, agent)
+	if !wasOnline {
+		s.publish(EventOnline, agent)
+	}
+	return nil
+}
+
+func (s *DiscoveryStore) MarkOffline(agentID string, cutoff time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if !agent.Online || !agent.LastSeen.Before(cutoff) {
+		return false, nil
+	}
+	agent.Online = false
+	s.publish(EventOffline, agent)
+	return true, nil
+}
+
+func (s *DiscoveryStore) Evict(agentID string, cutoff time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if agent.Online || !agent.LastSeen.Before(cutoff) {
+		return false, nil
+	}
+	delete(s.agents, agentID)
+	s.publish(EventEvicted, agent)
+	return true, nil
+}
+
+func (s *DiscoveryStore) Deregister(agentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.agents, agentID)
+	if agent.Online {
+		agent.Online = false
+		s.publish(EventOffline, agent)
+	}
+	s.publish(EventDeregistered, agent)
+	return nil
+}
+
+func (s *DiscoveryStore) Watch(ctx context.Context) <-chan Event {
+	ch := make(chan Event, watchBuffer)
+
+	s.watchMu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.watchMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.watchMu.Lock()
+		delete(s.watchers, ch)
+		close(ch)
+		s.watchMu.Unlock()
+	}()
+	return ch
+}
+
+// publish fans an event out to watchers. Callers hold s.mu so events are
+// delivered in the order the changes were applied.
+func (s *DiscoveryStore) publish(typ EventType, agent *Agent) {
+	ev := Event{Type: typ, Agent: agent.Clone(), At: time.Now()}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for ch := range s.watchers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package discovery_test
+
+import (
+	"testing"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+	"github.com/TheOrionAI/botcall-server/internal/discovery/discoverytest"
+)
+
+func TestDiscoveryStoreConformance(t *testing.T) {
+	discoverytest.Run(t, func(t *testing.T) discovery.Store {
+		return discovery.NewDiscoveryStore()
+	})
+}
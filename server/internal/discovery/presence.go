@@ -0,0 +1,39 @@
+package discovery
+
+import "time"
+
+// Presence states. Bots set available, busy or dnd; the server derives
+// in_call from active calls and offline from Agent.Online.
+const (
+	PresenceAvailable = "available"
+	PresenceBusy      = "busy"
+	PresenceDND       = "dnd"
+	PresenceInCall    = "in_call"
+	PresenceOffline   = "offline"
+)
+
+// MaxStatusMessageLen caps Agent.StatusMessage, in characters
+const MaxStatusMessageLen = 140
+
+// Settable reports whether a bot may set its presence to state
+func Settable(state string) bool {
+	return state == PresenceAvailable || state == PresenceBusy || state == PresenceDND
+}
+
+// PresenceAt returns the agent's presence as of now: offline when it is
+// not online, otherwise the state the bot set, falling back to available
+// once that state expires. It does not know about calls; see in_call.
+func (a *Agent) PresenceAt(now time.Time) string {
+	if !a.Online {
+		return PresenceOffline
+	}
+	if a.Presence == "" || a.PresenceExpired(now) {
+		return PresenceAvailable
+	}
+	return a.Presence
+}
+
+// PresenceExpired reports whether a bot-set presence has lapsed
+func (a *Agent) PresenceExpired(now time.Time) bool {
+	return !a.PresenceExpires.IsZero() && !now.Before(a.PresenceExpires)
+}
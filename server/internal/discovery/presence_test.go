@@ -0,0 +1,39 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPresenceAt(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		agent Agent
+		want  string
+	}{
+		{"offline wins", Agent{Online: false, Presence: PresenceDND}, PresenceOffline},
+		{"unset is available", Agent{Online: true}, PresenceAvailable},
+		{"bot set", Agent{Online: true, Presence: PresenceDND}, PresenceDND},
+		{"not yet expired", Agent{Online: true, Presence: PresenceBusy, PresenceExpires: now.Add(time.Minute)}, PresenceBusy},
+		{"expired", Agent{Online: true, Presence: PresenceBusy, PresenceExpires: now}, PresenceAvailable},
+	}
+	for _, tt := range tests {
+		if got := tt.agent.PresenceAt(now); got != tt.want {
+			t.Errorf("%s: PresenceAt = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSettable(t *testing.T) {
+	for _, state := range []string{PresenceAvailable, PresenceBusy, PresenceDND} {
+		if !Settable(state) {
+			t.Errorf("Settable(%q) = false", state)
+		}
+	}
+	for _, state := range []string{PresenceInCall, PresenceOffline, "away", ""} {
+		if Settable(state) {
+			t.Errorf("Settable(%q) = true", state)
+		}
+	}
+}
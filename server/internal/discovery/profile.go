@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Call modes an agent can accept
+const (
+	CallModeVoice = "voice"
+	CallModeText  = "text"
+	CallModeVideo = "video"
+)
+
+// Limits on profile fields, so a registration cannot bloat the registry
+const (
+	MaxDisplayNameLen = 64
+	MaxDescriptionLen = 512
+	MaxAvatarURLLen   = 2048
+	MaxListLen        = 16
+	MaxListItemLen    = 32
+)
+
+// Profile describes an agent to the humans who call it. Every field is
+// optional; an empty Modes means the agent did not say.
+type Profile struct {
+	DisplayName string `json:"display_name,omitempty"`
+	Description string `json:"description,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	// Modes lists the call modes (voice, text, video) the agent accepts;
+	// not to be confused with Agent.Mode, how it is reached
+	Modes     []string `json:"modes,omitempty"`
+	Languages []string `json:"languages,omitempty"` // BCP 47 tags, e.g. en-US
+	Codecs    []string `json:"codecs,omitempty"`    // e.g. opus, pcmu
+	// MaxConcurrentCalls caps simultaneous calls; zero means no stated cap
+	MaxConcurrentCalls int      `json:"max_concurrent_calls,omitempty"`
+	Tags               []string `json:"tags,omitempty"`
+}
+
+// Clone returns a deep copy of the profile
+func (p Profile) Clone() Profile {
+	p.Modes = cloneStrings(p.Modes)
+	p.Languages = cloneStrings(p.Languages)
+	p.Codecs = cloneStrings(p.Codecs)
+	p.Tags = cloneStrings(p.Tags)
+	return p
+}
+
+// Supports reports whether the agent accepts calls in mode. Agents that
+// list no modes are assumed to accept any.
+func (p *Profile) Supports(mode string) bool {
+	if len(p.Modes) == 0 {
+		return true
+	}
+	for _, m := range p.Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize trims and lowercases list entries and drops duplicates and
+// blanks. Languages keep their case.
+func (p *Profile) Normalize() {
+	p.DisplayName = strings.TrimSpace(p.DisplayName)
+	p.Description = strings.TrimSpace(p.Description)
+	p.AvatarURL = strings.TrimSpace(p.AvatarURL)
+	p.Modes = normalizeList(p.Modes, true)
+	p.Languages = normalizeList(p.Languages, false)
+	p.Codecs = normalizeList(p.Codecs, true)
+	p.Tags = normalizeList(p.Tags, true)
+}
+
+// Validate checks a normalized profile against the field limits
+func (p *Profile) Validate() error {
+	if utf8.RuneCountInString(p.DisplayName) > MaxDisplayNameLen {
+		return fmt.Errorf("display_name is longer than %d characters", MaxDisplayNameLen)
+	}
+	if utf8.RuneCountInString(p.Description) > MaxDescriptionLen {
+		return fmt.Errorf("description is longer than %d characters", MaxDescriptionLen)
+	}
+	if p.AvatarURL != "" {
+		if len(p.AvatarURL) > MaxAvatarURLLen {
+			return fmt.Errorf("avatar_url is longer than %d bytes", MaxAvatarURLLen)
+		}
+		u, err := url.Parse(p.AvatarURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("avatar_url must be an http or https URL")
+		}
+	}
+	for _, m := range p.Modes {
+		if m != CallModeVoice && m != CallModeText && m != CallModeVideo {
+			return fmt.Errorf("unknown mode %q (want voice, text or video)", m)
+		}
+	}
+	lists := []struct {
+		name  string
+		items []string
+	}{
+		{"modes", p.Modes}, {"languages", p.Languages}, {"codecs", p.Codecs}, {"tags", p.Tags},
+	}
+	for _, list := range lists {
+		if len(list.items) > MaxListLen {
+			return fmt.Errorf("%s has more than %d entries", list.name, MaxListLen)
+		}
+		for _, item := range list.items {
+			if utf8.RuneCountInString(item) > MaxListItemLen {
+				return fmt.Errorf("%s entry %q is longer than %d characters", list.name, item, MaxListItemLen)
+			}
+		}
+	}
+	if p.MaxConcurrentCalls < 0 {
+		return fmt.Errorf("max_concurrent_calls must not be negative")
+	}
+	return nil
+}
+
+func normalizeList(items []string, lower bool) []string {
+	var out []string
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if lower {
+			item = strings.ToLower(item)
+		}
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		out = append(out, item)
+	}
+	return out
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s...)
+}
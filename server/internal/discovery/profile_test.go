@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileNormalize(t *testing.T) {
+	p := Profile{
+		DisplayName: "  Orion ",
+		Modes:       []string{"Voice", " text", "voice", ""},
+		Languages:   []string{"en-US", "en-US", "fr"},
+		Tags:        []string{"Space", "space"},
+	}
+	p.Normalize()
+
+	if p.DisplayName != "Orion" {
+		t.Errorf("DisplayName = %q", p.DisplayName)
+	}
+	if strings.Join(p.Modes, ",") != "voice,text" {
+		t.Errorf("Modes = %v", p.Modes)
+	}
+	if strings.Join(p.Languages, ",") != "en-US,fr" {
+		t.Errorf("Languages = %v", p.Languages)
+	}
+	if strings.Join(p.Tags, ",") != "space" {
+		t.Errorf("Tags = %v", p.Tags)
+	}
+}
+
+func TestProfileValidate(t *testing.T) {
+	valid := Profile{
+		DisplayName:        "Orion",
+		AvatarURL:          "https://example.com/orion.png",
+		Modes:              []string{CallModeVoice, CallModeText},
+		MaxConcurrentCalls: 4,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate(valid) = %v", err)
+	}
+
+	tooMany := make([]string, MaxListLen+1)
+	for i := range tooMany {
+		tooMany[i] = string(rune('a' + i))
+	}
+	invalid := map[string]Profile{
+		"long name":      {DisplayName: strings.Repeat("x", MaxDisplayNameLen+1)},
+		"avatar scheme":  {AvatarURL: "javascript:alert(1)"},
+		"avatar no host": {AvatarURL: "https://"},
+		"unknown mode":   {Modes: []string{"fax"}},
+		"too many tags":  {Tags: tooMany},
+		"long tag":       {Tags: []string{strings.Repeat("t", MaxListItemLen+1)}},
+		"negative calls": {MaxConcurrentCalls: -1},
+	}
+	for name, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: Validate accepted %+v", name, p)
+		}
+	}
+}
+
+func TestProfileSupports(t *testing.T) {
+	var unstated Profile
+	if !unstated.Supports(CallModeVideo) {
+		t.Error("Profile without modes should accept any mode")
+	}
+	textOnly := Profile{Modes: []string{CallModeText}}
+	if textOnly.Supports(CallModeVoice) || !textOnly.Supports(CallModeText) {
+		t.Errorf("Supports disagrees with Modes %v", textOnly.Modes)
+	}
+}
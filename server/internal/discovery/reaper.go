@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	// DefaultPresenceTTL is how long an agent stays online without a keepalive
+	DefaultPresenceTTL = 5 * time.Minute
+	// DefaultRetention is how long an offline agent is kept before eviction
+	DefaultRetention = 24 * time.Hour
+)
+
+// Reaper enforces presence on a Store: agents not seen within TTL are
+// marked offline, and offline agents not seen within TTL+Retention are
+// evicted. The resulting EventOffline and EventEvicted events reach every
+// Watch subscriber.
+type Reaper struct {
+	Store     Store
+	TTL       time.Duration
+	Retention time.Duration
+	// Interval between sweeps; defaults to a tenth of TTL
+	Interval time.Duration
+	// Swept, if set, is told what each sweep by Run did
+	Swept func(offline, evicted int)
+	// Gone, if set, is told of each agent Sweep marks offline or evicts
+	Gone func(agentID string)
+}
+
+// NewReaper returns a Reaper for store with the given TTL and retention.
+// Zero values fall back to DefaultPresenceTTL and DefaultRetention.
+func NewReaper(store Store, ttl, retention time.Duration) *Reaper {
+	if ttl <= 0 {
+		ttl = DefaultPresenceTTL
+	}
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &Reaper{
+		Store:     store,
+		TTL:       ttl,
+		Retention: retention,
+		Interval:  ttl / 10,
+	}
+}
+
+// Run sweeps on every Interval until ctx is cancelled
+func (r *Reaper) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = r.TTL / 10
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			offline, evicted := r.Sweep(now)
+			if r.Swept != nil {
+				r.Swept(offline, evicted)
+			}
+		}
+	}
+}
+
+// Sweep applies the TTL and retention rules as of now and reports how many
+// agents went offline and how many were evicted
+func (r *Reaper) Sweep(now time.Time) (offline, evicted int) {
+	offlineCutoff := now.Add(-r.TTL)
+	evictCutoff := offlineCutoff.Add(-r.Retention)
+
+	for _, agent := range r.Store.List() {
+		if agent.Online {
+			if !agent.LastSeen.Before(offlineCutoff) {
+				continue
+			}
+			changed, err := r.Store.MarkOffline(agent.ID, offlineCutoff)
+			if err != nil {
+				slog.Error("reaper: mark offline", "agent_id", agent.ID, "err", err)
+				continue
+			}
+			if changed {
+				offline++
+				slog.Info("agent went offline", "agent_id", agent.ID, "last_seen", agent.LastSeen.Format(time.RFC3339))
+				r.gone(agent.ID)
+			}
+			continue
+		}
+
+		if !agent.LastSeen.Before(evictCutoff) {
+			continue
+		}
+		removed, err := r.Store.Evict(agent.ID, evictCutoff)
+		if err != nil {
+			slog.Error("reaper: evict", "agent_id", agent.ID, "err", err)
+			continue
+		}
+		if removed {
+			evicted++
+			slog.Info("evicted agent", "agent_id", agent.ID)
+			r.gone(agent.ID)
+		}
+	}
+	return offline, evicted
+}
+
+func (r *Reaper) gone(agentID string) {
+	if r.Gone != nil {
+		r.Gone(agentID)
+	}
+}
@@ -0,0 +1,77 @@
+package discovery_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+)
+
+func TestReaperSweep(t *testing.T) {
+	store := discovery.NewDiscoveryStore()
+	now := time.Now()
+
+	store.Register(&discovery.Agent{ID: "fresh", Online: true, LastSeen: now.Add(-time.Minute)})
+	store.Register(&discovery.Agent{ID: "stale", Online: true, LastSeen: now.Add(-10 * time.Minute)})
+	store.Register(&discovery.Agent{ID: "recently-offline", Online: false, LastSeen: now.Add(-time.Hour)})
+	store.Register(&discovery.Agent{ID: "expired", Online: false, LastSeen: now.Add(-48 * time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := store.Watch(ctx)
+
+	reaper := discovery.NewReaper(store, 5*time.Minute, 24*time.Hour)
+	var gone []string
+	reaper.Gone = func(agentID string) { gone = append(gone, agentID) }
+	offline, evicted := reaper.Sweep(now)
+	if offline != 1 || evicted != 1 {
+		t.Fatalf("Sweep = %d offline, %d evicted; want 1, 1", offline, evicted)
+	}
+	sort.Strings(gone)
+	if strings.Join(gone, ",") != "expired,stale" {
+		t.Errorf("Gone told of %v", gone)
+	}
+
+	if a, _ := store.Lookup("fresh"); !a.Online {
+		t.Error("Expected fresh agent to stay online")
+	}
+	if a, _ := store.Lookup("stale"); a.Online {
+		t.Error("Expected stale agent to be marked offline")
+	}
+	if _, ok := store.Lookup("recently-offline"); !ok {
+		t.Error("Expected offline agent within retention to be kept")
+	}
+	if _, ok := store.Lookup("expired"); ok {
+		t.Error("Expected agent past retention to be evicted")
+	}
+
+	got := map[discovery.EventType]string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-events:
+			got[ev.Type] = ev.Agent.ID
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for reaper events")
+		}
+	}
+	if got[discovery.EventOffline] != "stale" || got[discovery.EventEvicted] != "expired" {
+		t.Errorf("Unexpected reaper events: %v", got)
+	}
+}
+
+func TestReaperOfflineSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := openFileStore(t, dir)
+	store.Register(&discovery.Agent{ID: "stale", Online: true, LastSeen: time.Now().Add(-10 * time.Minute)})
+
+	discovery.NewReaper(store, 5*time.Minute, 24*time.Hour).Sweep(time.Now())
+
+	reopened := openFileStore(t, dir)
+	defer reopened.Close()
+	if a, ok := reopened.Lookup("stale"); !ok || a.Online {
+		t.Errorf("Expected stale agent to be persisted as offline, got %+v", a)
+	}
+}
@@ -0,0 +1,141 @@
+// Package discovery holds the agent registry behind the BotCall discovery
+// server. Backends implement Store; DiscoveryStore is the in-memory one.
+package discovery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when an operation targets an unknown agent ID
+var ErrNotFound = errors.New("discovery: agent not found")
+
+// Agent represents a registered bot
+type Agent struct {
+	ID          string    `json:"agent_id"`
+	Endpoint    string    `json:"endpoint"`
+	Mode        string    `json:"mode"` // direct, relay, nat-pending
+	Attestation string    `json:"attestation"`
+	Online      bool      `json:"online"`
+	LastSeen    time.Time `json:"last_seen"`
+
+	// Presence is the state the bot set (available, busy or dnd) with an
+	// optional message; it reverts to available at PresenceExpires. Use
+	// PresenceAt rather than reading it directly.
+	Presence        string    `json:"presence,omitempty"`
+	StatusMessage   string    `json:"status_message,omitempty"`
+	PresenceExpires time.Time `json:"presence_expires,omitempty"`
+
+	// AttestationValid records that Attestation passed verification at
+	// registration; it stops counting once AttestationExpires passes
+	AttestationValid   bool      `json:"attestation_valid"`
+	AttestationExpires time.Time `json:"attestation_expires,omitempty"`
+
+	// Owner binds the ID to its first registrant, see OwnerForKey and
+	// OwnerForIssuer. Strip it with Public before showing agents to clients.
+	Owner string `json:"owner,omitempty"`
+
+	// Instances are the replicas serving this ID. Endpoint and Mode
+	// mirror the one that registered last.
+	Instances []Instance `json:"instances,omitempty"`
+	// Balance is the policy lookups use to pick an instance; empty means
+	// the server default
+	Balance string `json:"balance,omitempty"`
+
+	// Profile is what the agent says about itself at registration
+	Profile
+}
+
+// Clone returns a copy of the agent that is safe to hand to callers
+func (a *Agent) Clone() *Agent {
+	c := *a
+	c.Profile = a.Profile.Clone()
+	if a.Instances != nil {
+		c.Instances = append([]Instance(nil), a.Instances...)
+	}
+	return &c
+}
+
+// Public returns a copy without server-private fields
+func (a *Agent) Public() *Agent {
+	c := a.Clone()
+	c.Owner = ""
+	return c
+}
+
+// OwnerForKey returns the ownership binding for a bot-held registration
+// key. Only a hash is kept, so a leaked registry does not leak keys.
+func OwnerForKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:])
+}
+
+// OwnerForIssuer returns the ownership binding for agents whose ID is
+// vouched for by an attestation issuer
+func OwnerForIssuer(issuer string) string {
+	return "attest:" + issuer
+}
+
+// AttestationCurrent reports whether the agent holds a verified
+// attestation that has not expired as of now
+func (a *Agent) AttestationCurrent(now time.Time) bool {
+	if !a.AttestationValid {
+		return false
+	}
+	return a.AttestationExpires.IsZero() || now.Before(a.AttestationExpires)
+}
+
+// EventType describes what happened to an agent
+type EventType string
+
+// Registry events report what was done to an entry; presence events
+// (EventOnline, EventOffline) report transitions of Agent.Online and are
+// what clients showing live status should follow.
+const (
+	EventRegistered   EventType = "registered"
+	EventTouched      EventType = "touched"
+	EventDeregistered EventType = "deregistered"
+	EventEvicted      EventType = "evicted"
+	EventOnline       EventType = "online"
+	EventOffline      EventType = "offline"
+)
+
+// Event is delivered to Watch subscribers whenever the registry changes
+type Event struct {
+	Type  EventType `json:"type"`
+	Agent *Agent    `json:"agent"`
+	At    time.Time `json:"at"`
+}
+
+// Store is the registry contract every discovery backend implements.
+// Agents returned by a Store are copies; mutating them has no effect
+// until they are passed back to Register.
+//
+// Agent.Online is the single source of truth for presence: Register and
+// Touch set it, and a Reaper clears it once the presence TTL lapses.
+type Store interface {
+	// Register adds the agent or replaces an existing entry with the same ID
+	Register(agent *Agent) error
+	// Lookup returns the agent with the given ID
+	Lookup(agentID string) (*Agent, bool)
+	// Touch marks the agent as seen now. It returns ErrNotFound for unknown IDs.
+	Touch(agentID string) error
+	// List returns every known agent, online or not
+	List() []*Agent
+	// ListOnline returns the agents currently marked online
+	ListOnline() []*Agent
+	// MarkOffline clears Online if the agent has not been seen since
+	// cutoff, reporting whether it changed anything
+	MarkOffline(agentID string, cutoff time.Time) (bool, error)
+	// Evict removes an offline agent not seen since cutoff, reporting
+	// whether it was removed
+	Evict(agentID string, cutoff time.Time) (bool, error)
+	// Deregister removes the agent. It returns ErrNotFound for unknown IDs.
+	Deregister(agentID string) error
+	// Watch streams registry events until ctx is cancelled, then closes
+	// the channel. Slow subscribers miss events rather than block writers.
+	Watch(ctx context.Context) <-chan Event
+}
@@ -0,0 +1,52 @@
+// Package httpstatus lets middleware see the status code a handler wrote
+// without hiding the optional interfaces (flushing, hijacking) that
+// streaming and WebSocket handlers rely on.
+package httpstatus
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// Recorder wraps a ResponseWriter and remembers the status it was sent
+type Recorder struct {
+	http.ResponseWriter
+	// Status is the code written, or 200 if the handler wrote none
+	Status      int
+	wroteHeader bool
+}
+
+// NewRecorder wraps w
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.Status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *Recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *Recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,63 @@
+package httpstatus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecorderStatus(t *testing.T) {
+	// Writing a body without a header means 200
+	r := NewRecorder(httptest.NewRecorder())
+	r.Write([]byte("ok"))
+	r.WriteHeader(http.StatusTeapot)
+	if r.Status != http.StatusOK {
+		t.Errorf("Status after a bare Write = %d", r.Status)
+	}
+
+	// The first status written sticks, as net/http's does
+	w := httptest.NewRecorder()
+	r = NewRecorder(w)
+	r.WriteHeader(http.StatusNotFound)
+	r.WriteHeader(http.StatusInternalServerError)
+	if r.Status != http.StatusNotFound || w.Code != http.StatusNotFound {
+		t.Errorf("Status = %d, sent %d; want 404", r.Status, w.Code)
+	}
+}
+
+func TestRecorderKeepsInterfaces(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := NewRecorder(w)
+	r.Flush()
+	if !w.Flushed {
+		t.Error("Flush did not reach the ResponseWriter")
+	}
+	if r.Unwrap() != w {
+		t.Error("Unwrap lost the ResponseWriter")
+	}
+	// httptest's recorder cannot be hijacked, so neither can its wrapper
+	if _, _, err := r.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("Hijack = %v", err)
+	}
+
+	// Through a real server the connection can be taken over
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, _, err := NewRecorder(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack = %v", err)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n"))
+		conn.Close()
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Hijacked response = %d", resp.StatusCode)
+	}
+}
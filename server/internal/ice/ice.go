@@ -0,0 +1,119 @@
+// Package ice hands out the STUN and TURN servers WebRTC peers should use.
+// TURN credentials follow the TURN REST API scheme: the username is
+// "<expiry unix time>:<user>" and the password is the base64 HMAC-SHA1 of
+// the username under a secret shared with the TURN server, so the TURN
+// server can check them without calling back.
+package ice
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSTUN is offered when no STUN URLs are configured
+const DefaultSTUN = "stun:stun.l.google.com:19302"
+
+// DefaultTTL is how long issued TURN credentials stay valid
+const DefaultTTL = time.Hour
+
+// Config selects the servers to hand out
+type Config struct {
+	STUN []string // stun: URLs
+	TURN []string // turn: and turns: URLs
+	// Secret is shared with the TURN server (coturn's static-auth-secret).
+	// Without it no TURN servers are offered.
+	Secret string
+	TTL    time.Duration
+}
+
+// Server is one entry of an RTCConfiguration's iceServers
+type Server struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// Servers is the response of /v1/ice-servers. ICEServers can be passed
+// straight to RTCPeerConnection; Username, Password, TTL and URIs are the
+// TURN REST API fields for clients that expect them.
+type Servers struct {
+	ICEServers []Server   `json:"ice_servers"`
+	Username   string     `json:"username,omitempty"`
+	Password   string     `json:"password,omitempty"`
+	TTL        int64      `json:"ttl,omitempty"` // seconds
+	URIs       []string   `json:"uris,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
+}
+
+// Provider issues ICE server lists from a Config
+type Provider struct {
+	cfg Config
+}
+
+// New returns a Provider, filling in the default STUN URL and TTL
+func New(cfg Config) *Provider {
+	if len(cfg.STUN) == 0 {
+		cfg.STUN = []string{DefaultSTUN}
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	return &Provider{cfg: cfg}
+}
+
+// TURNEnabled reports whether TURN credentials are issued
+func (p *Provider) TURNEnabled() bool {
+	return len(p.cfg.TURN) > 0 && p.cfg.Secret != ""
+}
+
+// STUN returns the STUN servers alone, for callers not trusted with TURN
+func (p *Provider) STUN() Servers {
+	return Servers{ICEServers: []Server{{URLs: p.cfg.STUN}}}
+}
+
+// Issue returns the ICE servers for user, with TURN credentials valid
+// from now for the configured TTL
+func (p *Provider) Issue(user string, now time.Time) Servers {
+	out := p.STUN()
+	if !p.TURNEnabled() {
+		return out
+	}
+
+	expires := now.Add(p.cfg.TTL)
+	username := Username(expires, user)
+	password := Password(p.cfg.Secret, username)
+
+	out.ICEServers = append(out.ICEServers, Server{
+		URLs:       p.cfg.TURN,
+		Username:   username,
+		Credential: password,
+	})
+	out.Username = username
+	out.Password = password
+	out.TTL = int64(p.cfg.TTL / time.Second)
+	out.URIs = p.cfg.TURN
+	expires = expires.UTC().Truncate(time.Second)
+	out.Expires = &expires
+	return out
+}
+
+// Username builds a TURN REST username. Colons in user are dropped since
+// the TURN server splits on the first one.
+func Username(expires time.Time, user string) string {
+	user = strings.ReplaceAll(user, ":", "")
+	if user == "" {
+		return strconv.FormatInt(expires.Unix(), 10)
+	}
+	return strconv.FormatInt(expires.Unix(), 10) + ":" + user
+}
+
+// Password is the TURN REST credential for username under secret
+func Password(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,55 @@
+package ice_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/ice"
+)
+
+func TestPassword(t *testing.T) {
+	// echo -n "1700000000:alice" | openssl dgst -sha1 -hmac s3cret -binary | base64
+	got := ice.Password("s3cret", "1700000000:alice")
+	if want := "TtElzSjT0GdnTQ9xdcRNxx96yQs="; got != want {
+		t.Fatalf("Password = %q, want %q", got, want)
+	}
+}
+
+func TestIssue(t *testing.T) {
+	p := ice.New(ice.Config{
+		TURN:   []string{"turn:turn.example:3478?transport=udp", "turns:turn.example:5349"},
+		Secret: "s3cret",
+		TTL:    10 * time.Minute,
+	})
+	now := time.Unix(1700000000, 0)
+
+	servers := p.Issue("ali:ce", now)
+	if len(servers.ICEServers) != 2 {
+		t.Fatalf("ICEServers = %+v", servers.ICEServers)
+	}
+	if stun := servers.ICEServers[0]; len(stun.URLs) != 1 || stun.URLs[0] != ice.DefaultSTUN || stun.Username != "" {
+		t.Errorf("STUN entry = %+v", stun)
+	}
+
+	turn := servers.ICEServers[1]
+	if turn.Username != "1700000600:alice" {
+		t.Errorf("Username = %q, want expiry:user with colons dropped", turn.Username)
+	}
+	if turn.Credential != ice.Password("s3cret", turn.Username) {
+		t.Errorf("Credential does not match the HMAC of the username")
+	}
+	if servers.TTL != 600 || servers.Expires == nil || !servers.Expires.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("TTL = %d, Expires = %v", servers.TTL, servers.Expires)
+	}
+}
+
+func TestIssueWithoutSecret(t *testing.T) {
+	p := ice.New(ice.Config{STUN: []string{"stun:stun.example:3478"}, TURN: []string{"turn:turn.example"}})
+	if p.TURNEnabled() {
+		t.Fatal("TURN enabled without a shared secret")
+	}
+	servers := p.Issue("alice", time.Now())
+	if len(servers.ICEServers) != 1 || servers.Username != "" || servers.Expires != nil {
+		t.Fatalf("Issue = %+v, want STUN only", servers)
+	}
+}
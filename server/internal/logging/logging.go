@@ -0,0 +1,111 @@
+// Package logging builds the server's structured logger and threads request
+// IDs through it. Each HTTP request carries an X-Request-ID, taken from the
+// client when it sent a sane one and generated otherwise; it is echoed in
+// the response, forwarded to relayed bots, and attached to every line logged
+// while serving the request, so one call can be followed from the PWA
+// through discovery to the bot's /call.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader carries the request ID between PWA, discovery and bots
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds client-supplied IDs so they cannot bloat the logs
+const maxRequestIDLen = 128
+
+// Formats accepted by New
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New returns a logger writing to w at level (debug, info, warn or error)
+// in format (text or json)
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level %q: want debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("log format %q: want text or json", format)
+	}
+}
+
+// NewRequestID returns a random 128-bit ID in hex
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts the printable, space-free IDs proxies and
+// browsers generate (UUIDs, hex, base64) and nothing that could forge a
+// log line
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+type ctxKey int
+
+const (
+	loggerKey ctxKey = iota
+	requestIDKey
+)
+
+// FromContext returns the request-scoped logger stored by Middleware, or
+// slog.Default() outside a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RequestID returns the ID of the request ctx belongs to, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Middleware gives every request an ID and a logger carrying it. The ID is
+// also set on the request's own headers, so handlers that proxy the request
+// pass it on.
+func Middleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		w.Header().Add("Access-Control-Expose-Headers", RequestIDHeader)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		ctx = context.WithValue(ctx, loggerKey, logger.With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "warn", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept", "agent_id", "orion")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected one JSON line, got %q", buf.String())
+	}
+	if line["msg"] != "kept" || line["agent_id"] != "orion" || line["level"] != "WARN" {
+		t.Errorf("Logged %v", line)
+	}
+
+	if _, err := New(&buf, "loud", "text"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if _, err := New(&buf, "info", "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := New(&buf, "info", "json")
+	var seen, forwarded string
+	handler := Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		forwarded = r.Header.Get(RequestIDHeader)
+		FromContext(r.Context()).Info("handled")
+	}))
+
+	serve := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if id != "" {
+			req.Header.Set(RequestIDHeader, id)
+		}
+		rec := httptest.NewRecorder()
+		buf.Reset()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("pwa-1234")
+	if seen != "pwa-1234" || forwarded != "pwa-1234" || rec.Header().Get(RequestIDHeader) != "pwa-1234" {
+		t.Errorf("Client ID not kept: context %q, header %q, response %q", seen, forwarded, rec.Header().Get(RequestIDHeader))
+	}
+	var line map[string]interface{}
+	json.Unmarshal(buf.Bytes(), &line)
+	if line["request_id"] != "pwa-1234" {
+		t.Errorf("Log line %v lacks the request ID", line)
+	}
+
+	for _, id := range []string{"", "forged\nlevel=ERROR", string(make([]byte, 200))} {
+		rec := serve(id)
+		if seen == id || len(seen) != 32 || forwarded != seen || rec.Header().Get(RequestIDHeader) != seen {
+			t.Errorf("ID %q: expected a generated one, got %q", id, seen)
+		}
+	}
+}
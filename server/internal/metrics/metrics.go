@@ -0,0 +1,189 @@
+// Package metrics exposes the discovery server's Prometheus metrics. Each
+// Metrics has its own registry, so several servers can run in one process.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+	"github.com/TheOrionAI/botcall-server/internal/httpstatus"
+	"github.com/TheOrionAI/botcall-server/internal/turnserver"
+)
+
+const namespace = "botcall"
+
+// Registration results
+const (
+	ResultAccepted = "accepted"
+	ResultRejected = "rejected" // invalid request, attestation or ownership
+	ResultError    = "error"
+)
+
+// Lookup results: whether the agent ID is in the registry
+const (
+	LookupHit  = "hit"
+	LookupMiss = "miss"
+)
+
+// Metrics holds the collectors the server updates as it works
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	Registrations       *prometheus.CounterVec   // by result
+	Lookups             *prometheus.CounterVec   // by result
+	RequestDuration     *prometheus.HistogramVec // by route, method and code
+	WebSockets          *prometheus.GaugeVec     // open sockets by role
+	AttestationFailures *prometheus.CounterVec   // by error code
+	ReaperOffline       prometheus.Counter
+	ReaperEvictions     prometheus.Counter
+}
+
+// New registers the server's metrics, plus gauges of the online agents in
+// store by mode and the Go runtime and process collectors
+func New(store discovery.Store) *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		Registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Agent registrations and keepalives, by result.",
+		}, []string{"result"}),
+		Lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "lookups_total",
+			Help:      "Agent lookups, by whether the agent was known (hit) or not (miss).",
+		}, []string{"result"}),
+		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests, by route pattern, method and status code. Streams are not timed.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		WebSockets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "websockets_active",
+			Help:      "Open WebSocket connections, by role (human, bot or presence).",
+		}, []string{"role"}),
+		AttestationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "attestation_failures_total",
+			Help:      "Attestation tokens that failed verification, by error code.",
+		}, []string{"code"}),
+		ReaperOffline: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reaper_offline_total",
+			Help:      "Agents the reaper marked offline after missing the presence TTL.",
+		}),
+		ReaperEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reaper_evictions_total",
+			Help:      "Offline agents the reaper evicted after the retention window.",
+		}),
+	}
+	m.Registry.MustRegister(
+		m.Registrations,
+		m.Lookups,
+		m.RequestDuration,
+		m.WebSockets,
+		m.AttestationFailures,
+		m.ReaperOffline,
+		m.ReaperEvictions,
+		&agentCollector{store: store},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// ReaperSwept counts what one reaper sweep did; it fits Reaper.Swept
+func (m *Metrics) ReaperSwept(offline, evicted int) {
+	m.ReaperOffline.Add(float64(offline))
+	m.ReaperEvictions.Add(float64(evicted))
+}
+
+// RegisterTURN exports the embedded TURN server's counters
+func (m *Metrics) RegisterTURN(srv *turnserver.Server) {
+	stat := func(name, help string, value func(turnserver.Stats) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "turn",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(srv.Stats())) })
+	}
+	m.Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "turn",
+			Name:      "allocations_active",
+			Help:      "Open TURN allocations.",
+		}, func() float64 { return float64(srv.Stats().ActiveAllocations) }),
+		stat("allocations_total", "TURN allocations created.", func(s turnserver.Stats) int64 { return s.Allocations }),
+		stat("auth_failures_total", "TURN requests with bad or expired credentials.", func(s turnserver.Stats) int64 { return s.AuthFailures }),
+		stat("quota_rejections_total", "TURN allocations refused by the per-user quota.", func(s turnserver.Stats) int64 { return s.QuotaRejections }),
+		stat("quota_exhausted_total", "TURN allocations cut off by the byte quota.", func(s turnserver.Stats) int64 { return s.QuotaExhausted }),
+		stat("relayed_bytes_total", "Bytes relayed by TURN allocations.", func(s turnserver.Stats) int64 { return s.BytesRelayed }),
+	)
+}
+
+// agentCollector counts online agents by mode at scrape time, so the
+// gauge cannot drift from the registry
+type agentCollector struct {
+	store discovery.Store
+}
+
+var agentsOnlineDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "agents_online"),
+	"Agents currently online, by mode.",
+	[]string{"mode"}, nil,
+)
+
+func (c *agentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- agentsOnlineDesc
+}
+
+func (c *agentCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[string]int{"direct": 0, "relay": 0}
+	for _, agent := range c.store.ListOnline() {
+		mode := agent.Mode
+		if mode == "" {
+			mode = "direct"
+		}
+		counts[mode]++
+	}
+	for mode, n := range counts {
+		ch <- prometheus.MustNewConstMetric(agentsOnlineDesc, prometheus.GaugeValue, float64(n), mode)
+	}
+}
+
+// Instrument times every request mux serves under the pattern that
+// matched it. WebSocket upgrades and the routes in streams are long-lived
+// and are left untimed.
+func (m *Metrics) Instrument(mux *http.ServeMux, streams ...string) http.Handler {
+	untimed := make(map[string]bool, len(streams))
+	for _, route := range streams {
+		untimed[route] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" || untimed[route] || r.Header.Get("Upgrade") != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		rec := httpstatus.NewRecorder(w)
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+		m.RequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.Status)).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,366 @@
+// Package probe checks that registered bots actually answer. Every live
+// direct-mode instance has its /health polled on a jittered schedule; after
+// FailureThreshold failures in a row it is degraded until a probe succeeds.
+// Registration alone only proves a bot could reach discovery, not that
+// humans can reach the bot.
+//
+// Endpoints are whatever bots register, so by default the prober refuses
+// to connect to loopback, private and link-local addresses; otherwise any
+// bot could have discovery scan the network it runs in. Such instances
+// stay StateUnknown: a probe the policy refused says nothing about whether
+// humans, who may share the bot's network, can reach it.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+)
+
+// Defaults for zero Config fields
+const (
+	DefaultInterval         = 30 * time.Second
+	DefaultTimeout          = 5 * time.Second
+	DefaultFailureThreshold = 3
+	DefaultHistory          = 10
+	DefaultWorkers          = 16
+)
+
+// Health states
+const (
+	StateUnknown  = "unknown" // not probed yet
+	StateHealthy  = "healthy"
+	StateDegraded = "degraded"
+)
+
+// Config tunes the prober. Zero values take the defaults above; Jitter
+// defaults to a fifth of Interval.
+type Config struct {
+	// Interval between probes of one instance
+	Interval time.Duration
+	// Jitter is the most a probe is delayed at random, so instances that
+	// registered together are not probed in lockstep
+	Jitter  time.Duration
+	Timeout time.Duration
+	// FailureThreshold is how many failures in a row degrade an instance
+	FailureThreshold int
+	// History is how many results are kept per instance
+	History int
+	// Workers caps the probes in flight at once
+	Workers int
+	// PresenceTTL decides which instances are live; see Agent.LiveInstances
+	PresenceTTL time.Duration
+	// AllowPrivate lets the default client probe loopback, private and
+	// link-local addresses, for deployments where bots run on such a
+	// network
+	AllowPrivate bool
+	// Client sends the probes; it should not follow redirects off the bot
+	// and should refuse addresses bots have no business registering
+	Client *http.Client
+}
+
+// ErrAddressNotAllowed fails probes of endpoints that resolve to loopback,
+// private or link-local addresses
+var ErrAddressNotAllowed = errors.New("probe: address not allowed")
+
+// Result is the outcome of one probe
+type Result struct {
+	At        time.Time `json:"at"`
+	OK        bool      `json:"ok"`
+	Status    int       `json:"status,omitempty"` // HTTP status, if any
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	// Refused is set when the endpoint resolved to an address the prober
+	// may not dial; refused probes never count as failures
+	Refused bool `json:"refused,omitempty"`
+}
+
+// Health is what the prober knows about one instance
+type Health struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastOK              time.Time `json:"last_ok,omitempty"`
+	// Probes are the most recent results, oldest first
+	Probes []Result `json:"probes"`
+}
+
+// Prober polls bot health endpoints. It is safe for concurrent use.
+type Prober struct {
+	store discovery.Store
+	cfg   Config
+
+	mu      sync.Mutex
+	targets map[targetKey]*target
+	rand    *rand.Rand
+}
+
+type targetKey struct{ agentID, instanceID string }
+
+type target struct {
+	url      string
+	next     time.Time
+	inflight bool
+	health   Health
+}
+
+// New returns a Prober for the agents in store
+func New(store discovery.Store, cfg Config) *Prober {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.Jitter <= 0 {
+		cfg.Jitter = cfg.Interval / 5
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultFailureThreshold
+	}
+	if cfg.History <= 0 {
+		cfg.History = DefaultHistory
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.PresenceTTL <= 0 {
+		cfg.PresenceTTL = discovery.DefaultPresenceTTL
+	}
+	if cfg.Client == nil {
+		dialer := &net.Dialer{Timeout: cfg.Timeout}
+		if !cfg.AllowPrivate {
+			// Checked on the resolved address, so DNS cannot point a
+			// public-looking name inside
+			dialer.Control = refusePrivate
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = dialer.DialContext
+		cfg.Client = &http.Client{
+			Transport:     transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+	}
+	return &Prober{
+		store:   store,
+		cfg:     cfg,
+		targets: make(map[targetKey]*target),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Run probes until ctx is cancelled, checking for due instances several
+// times per Interval
+func (p *Prober) Run(ctx context.Context) {
+	tick := p.cfg.Interval / 10
+	if tick > time.Second {
+		tick = time.Second
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.Sweep(ctx, now)
+		}
+	}
+}
+
+// Sweep brings the target list in line with the registry and probes every
+// instance that is due as of now on up to Workers goroutines, returning
+// once those probes finish. Each probe has Timeout to answer.
+func (p *Prober) Sweep(ctx context.Context, now time.Time) {
+	due := p.schedule(now)
+
+	type job struct {
+		key targetKey
+		url string
+	}
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < p.cfg.Workers && i < len(due); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				p.record(j.key, j.url, now, p.probe(ctx, j.url))
+			}
+		}()
+	}
+	for key, url := range due {
+		jobs <- job{key, url}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// schedule tracks live direct-mode instances, forgets the rest, and marks
+// and returns the due ones
+func (p *Prober) schedule(now time.Time) map[targetKey]string {
+	cutoff := now.Add(-p.cfg.PresenceTTL)
+	seen := make(map[targetKey]bool)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	due := make(map[targetKey]string)
+	for _, agent := range p.store.ListOnline() {
+		for _, inst := range agent.LiveInstances(cutoff) {
+			url := healthURL(inst)
+			if url == "" {
+				continue
+			}
+			key := targetKey{agent.ID, inst.ID}
+			seen[key] = true
+			t := p.targets[key]
+			if t == nil || t.url != url {
+				// New or moved: start over, first probe within Jitter
+				t = &target{url: url, next: now.Add(p.jitter()), health: Health{State: StateUnknown}}
+				p.targets[key] = t
+			}
+			if !t.inflight && !now.Before(t.next) {
+				t.inflight = true
+				due[key] = url
+			}
+		}
+	}
+	for key := range p.targets {
+		if !seen[key] {
+			delete(p.targets, key)
+		}
+	}
+	return due
+}
+
+func (p *Prober) jitter() time.Duration {
+	return time.Duration(p.rand.Int63n(int64(p.cfg.Jitter)))
+}
+
+// healthURL is where an instance's /health lives, or "" for instances
+// humans do not dial directly
+func healthURL(inst discovery.Instance) string {
+	if inst.Endpoint == "" || (inst.Mode != "" && inst.Mode != "direct") {
+		return ""
+	}
+	base := strings.TrimSuffix(inst.Endpoint, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	return base + "/health"
+}
+
+// refusePrivate is a net.Dialer Control that refuses loopback, private,
+// link-local and unspecified addresses
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return ErrAddressNotAllowed
+	}
+	return nil
+}
+
+func (p *Prober) probe(ctx context.Context, url string) Result {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	res := Result{At: start}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	req.Header.Set("User-Agent", "botcall-prober")
+	resp, err := p.cfg.Client.Do(req)
+	res.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		res.Refused = errors.Is(err, ErrAddressNotAllowed)
+		return res
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	res.Status = resp.StatusCode
+	res.OK = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !res.OK {
+		res.Error = fmt.Sprintf("health returned %d", resp.StatusCode)
+	}
+	return res
+}
+
+// record folds a result into the target's health and schedules its next
+// probe relative to the sweep at now, unless the instance moved or left
+// while the probe ran
+func (p *Prober) record(key targetKey, url string, now time.Time, res Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.targets[key]
+	if t == nil || t.url != url {
+		return
+	}
+	t.inflight = false
+	t.next = now.Add(p.cfg.Interval + p.jitter())
+
+	h := &t.health
+	h.Probes = append(h.Probes, res)
+	if len(h.Probes) > p.cfg.History {
+		h.Probes = h.Probes[len(h.Probes)-p.cfg.History:]
+	}
+	if res.OK {
+		h.ConsecutiveFailures = 0
+		h.LastOK = res.At
+		h.State = StateHealthy
+		return
+	}
+	if res.Refused {
+		// Nothing is known about an endpoint that may not be dialled
+		h.ConsecutiveFailures = 0
+		h.State = StateUnknown
+		return
+	}
+	h.ConsecutiveFailures++
+	if h.ConsecutiveFailures >= p.cfg.FailureThreshold {
+		h.State = StateDegraded
+	}
+}
+
+// Health returns what is known about an instance. Instances that are not
+// probed, such as relay bots, report false.
+func (p *Prober) Health(agentID, instanceID string) (Health, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.targets[targetKey{agentID, instanceID}]
+	if t == nil {
+		return Health{}, false
+	}
+	h := t.health
+	h.Probes = append([]Result(nil), h.Probes...)
+	return h, true
+}
+
+// Degraded reports whether an instance has failed FailureThreshold probes
+// in a row
+func (p *Prober) Degraded(agentID, instanceID string) bool {
+	h, ok := p.Health(agentID, instanceID)
+	return ok && h.State == StateDegraded
+}
@@ -0,0 +1,179 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/discovery"
+)
+
+func TestProbeDegradesAndRecovers(t *testing.T) {
+	var healthy atomic.Bool
+	var hits atomic.Int32
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path != "/health" || !healthy.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer bot.Close()
+
+	store := discovery.NewDiscoveryStore()
+	now := time.Now()
+	store.Register(&discovery.Agent{ID: "orion", Endpoint: bot.URL, Mode: "direct", Online: true, LastSeen: now})
+	store.Register(&discovery.Agent{ID: "vega", Endpoint: "unused", Mode: "relay", Online: true, LastSeen: now})
+
+	p := New(store, Config{Interval: time.Minute, Jitter: time.Nanosecond, FailureThreshold: 2, History: 3, AllowPrivate: true})
+	ctx := context.Background()
+	sweep := func(n int) {
+		for i := 0; i < n; i++ {
+			now = now.Add(2 * time.Minute)
+			p.Sweep(ctx, now)
+		}
+	}
+
+	if _, ok := p.Health("orion", discovery.DefaultInstance); ok {
+		t.Fatal("Health known before the first sweep")
+	}
+	// With 1ns of jitter new instances are probed right away
+	p.Sweep(ctx, now)
+	if _, ok := p.Health("vega", discovery.DefaultInstance); ok {
+		t.Error("Relay bots must not be probed")
+	}
+	if h, _ := p.Health("orion", discovery.DefaultInstance); h.State != StateUnknown || h.ConsecutiveFailures != 1 {
+		t.Errorf("One failure below the threshold: %+v", h)
+	}
+	sweep(1)
+	if !p.Degraded("orion", discovery.DefaultInstance) {
+		t.Error("Expected orion degraded after two failures")
+	}
+
+	healthy.Store(true)
+	sweep(2)
+	h, _ := p.Health("orion", discovery.DefaultInstance)
+	if h.State != StateHealthy || h.ConsecutiveFailures != 0 || h.LastOK.IsZero() {
+		t.Errorf("After recovering: %+v", h)
+	}
+	if len(h.Probes) != 3 || !h.Probes[2].OK || h.Probes[0].OK || h.Probes[0].Status != http.StatusServiceUnavailable {
+		t.Errorf("History = %+v", h.Probes)
+	}
+	if hits.Load() != 4 {
+		t.Errorf("Bot was probed %d times, want 4", hits.Load())
+	}
+}
+
+func TestProbeSchedule(t *testing.T) {
+	var hits atomic.Int32
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits.Add(1) }))
+	defer bot.Close()
+
+	store := discovery.NewDiscoveryStore()
+	now := time.Now()
+	store.Register(&discovery.Agent{ID: "orion", Endpoint: bot.URL, Online: true, LastSeen: now})
+
+	p := New(store, Config{Interval: time.Minute, Jitter: 10 * time.Second, AllowPrivate: true})
+	ctx := context.Background()
+	for at := now; at.Before(now.Add(5 * time.Minute)); at = at.Add(time.Second) {
+		p.Sweep(ctx, at)
+	}
+	// One probe within the first Jitter, then one every 60-70s
+	if n := hits.Load(); n < 4 || n > 5 {
+		t.Errorf("Probed %d times in five minutes", n)
+	}
+
+	// Leaving the registry stops the probes and drops the history
+	store.Deregister("orion")
+	p.Sweep(ctx, now.Add(time.Hour))
+	if _, ok := p.Health("orion", discovery.DefaultInstance); ok {
+		t.Error("Deregistered agent is still tracked")
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer bot.Close()
+	defer close(release)
+
+	store := discovery.NewDiscoveryStore()
+	now := time.Now()
+	store.Register(&discovery.Agent{ID: "orion", Endpoint: bot.URL, Online: true, LastSeen: now})
+
+	p := New(store, Config{Interval: time.Minute, Jitter: time.Nanosecond, Timeout: 50 * time.Millisecond, AllowPrivate: true})
+	p.Sweep(context.Background(), now)
+	h, _ := p.Health("orion", discovery.DefaultInstance)
+	if len(h.Probes) != 1 || h.Probes[0].OK || h.Probes[0].Error == "" {
+		t.Errorf("Timed-out probe = %+v", h.Probes)
+	}
+}
+
+func TestProbeRefusesPrivateAddresses(t *testing.T) {
+	var hits atomic.Int32
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits.Add(1) }))
+	defer bot.Close()
+
+	store := discovery.NewDiscoveryStore()
+	now := time.Now()
+	store.Register(&discovery.Agent{ID: "orion", Endpoint: bot.URL, Online: true, LastSeen: now})
+
+	p := New(store, Config{Interval: time.Minute, Jitter: time.Nanosecond, FailureThreshold: 2})
+	for i := 0; i < 3; i++ {
+		p.Sweep(context.Background(), now.Add(time.Duration(i)*2*time.Minute))
+	}
+	// Refused probes are kept in the history but never degrade the bot
+	h, _ := p.Health("orion", discovery.DefaultInstance)
+	if len(h.Probes) != 3 || h.Probes[0].OK || !h.Probes[0].Refused || hits.Load() != 0 {
+		t.Errorf("Loopback probes = %+v after %d hits", h.Probes, hits.Load())
+	}
+	if h.State != StateUnknown || h.ConsecutiveFailures != 0 || p.Degraded("orion", discovery.DefaultInstance) {
+		t.Errorf("Loopback health = %+v", h)
+	}
+
+	for _, addr := range []string{"127.0.0.1:80", "10.1.2.3:80", "192.168.0.1:80", "169.254.169.254:80", "[::1]:80", "[fe80::1]:80", "0.0.0.0:80"} {
+		if err := refusePrivate("tcp", addr, nil); !errors.Is(err, ErrAddressNotAllowed) {
+			t.Errorf("refusePrivate(%s) = %v", addr, err)
+		}
+	}
+	if err := refusePrivate("tcp", "203.0.113.7:443", nil); err != nil {
+		t.Errorf("refusePrivate(public) = %v", err)
+	}
+}
+
+func TestProbeWorkers(t *testing.T) {
+	var inflight, most atomic.Int32
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer bot.Close()
+
+	store := discovery.NewDiscoveryStore()
+	now := time.Now()
+	for i := 0; i < 6; i++ {
+		store.Register(&discovery.Agent{ID: fmt.Sprintf("bot-%d", i), Endpoint: bot.URL, Online: true, LastSeen: now})
+	}
+
+	p := New(store, Config{Interval: time.Minute, Jitter: time.Nanosecond, Workers: 2, AllowPrivate: true})
+	p.Sweep(context.Background(), now)
+	if n := most.Load(); n != 2 {
+		t.Errorf("%d probes in flight at once, want 2", n)
+	}
+	for i := 0; i < 6; i++ {
+		if h, _ := p.Health(fmt.Sprintf("bot-%d", i), discovery.DefaultInstance); h.State != StateHealthy {
+			t.Errorf("bot-%d = %+v", i, h)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package signaling
+
+import "context"
+
+// watchBuffer is the per-subscriber event backlog before events are dropped
+const watchBuffer = 64
+
+// CallEventType says whether a call began or ended
+type CallEventType string
+
+const (
+	CallStarted CallEventType = "started"
+	CallEnded   CallEventType = "ended"
+)
+
+// CallEvent is delivered to Watch subscribers when a call rings or ends
+type CallEvent struct {
+	Type    CallEventType
+	CallID  string
+	AgentID string
+	// Active is the number of calls the agent has once this event applies
+	Active int
+}
+
+// CallsFor returns the number of calls agentID is ringing or on
+func (h *Hub) CallsFor(agentID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.callsFor(agentID)
+}
+
+func (h *Hub) callsFor(agentID string) int {
+	n := 0
+	for _, call := range h.calls {
+		if call.AgentID == agentID {
+			n++
+		}
+	}
+	return n
+}
+
+// Watch streams call events until ctx is cancelled, then closes the
+// channel. Slow subscribers miss events rather than block calls.
+func (h *Hub) Watch(ctx context.Context) <-chan CallEvent {
+	ch := make(chan CallEvent, watchBuffer)
+
+	h.watchMu.Lock()
+	if h.watchers == nil {
+		h.watchers = make(map[chan CallEvent]struct{})
+	}
+	h.watchers[ch] = struct{}{}
+	h.watchMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.watchMu.Lock()
+		delete(h.watchers, ch)
+		close(ch)
+		h.watchMu.Unlock()
+	}()
+	return ch
+}
+
+// publish fans an event out to watchers. Callers hold h.mu so events are
+// delivered in the order calls changed.
+func (h *Hub) publish(typ CallEventType, call *Call) {
+	ev := CallEvent{Type: typ, CallID: call.ID, AgentID: call.AgentID, Active: h.callsFor(call.AgentID)}
+
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	for ch := range h.watchers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
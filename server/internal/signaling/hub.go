@@ -0,0 +1,463 @@
+// Package signaling relays WebRTC call setup between a human's socket and
+// a bot's socket on the discovery server, so neither side needs to reach
+// the other over plain HTTP before media flows.
+//
+// A bot keeps one socket open per agent ID. A human opens a socket for the
+// agent they want, sends "call", and the hub rings the bot. Once the bot
+// sends "accept", offer/answer/candidate/text frames for that call_id are
+// forwarded verbatim to the other side until either sends "hangup".
+//
+// Bots in relay mode have no reachable endpoint, so the same socket also
+// carries plain HTTP requests to them; see Forward.
+package signaling
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Message types on the signaling socket
+const (
+	TypeCall      = "call"      // human → hub: start a call; hub → bot: incoming call
+	TypeRinging   = "ringing"   // hub → human: the bot has been notified
+	TypeAccept    = "accept"    // bot → human; mode "text" skips SDP negotiation
+	TypeReject    = "reject"    // bot → human
+	TypeOffer     = "offer"     // either way, carries sdp
+	TypeAnswer    = "answer"    // either way, carries sdp
+	TypeCandidate = "candidate" // either way, carries candidate
+	TypeText      = "text"      // either way, carries text
+	TypeHangup    = "hangup"    // either way, or from the hub with a reason
+	TypeError     = "error"     // hub → sender
+	TypePing      = "ping"      // hub → both, heartbeat
+	TypeTunnel    = "tunnel"    // hub → bot, its public tunnel URL
+)
+
+// Hangup and error reasons sent by the hub
+const (
+	ReasonNormal         = "normal"
+	ReasonTimeout        = "timeout"
+	ReasonRejected       = "rejected"
+	ReasonDisconnected   = "disconnected"
+	ReasonBotUnavailable = "bot_unavailable"
+	ReasonBusy           = "busy"
+	ReasonReplaced       = "replaced"
+	ReasonUnknownCall    = "unknown_call"
+	ReasonBadMessage     = "bad_message"
+)
+
+// Message is the envelope of every signaling frame. Frames relayed between
+// peers are forwarded as received, so fields unknown to the hub survive.
+type Message struct {
+	Type      string          `json:"type"`
+	CallID    string          `json:"call_id,omitempty"`
+	AgentID   string          `json:"agent_id,omitempty"`
+	HumanID   string          `json:"human_id,omitempty"`
+	Mode      string          `json:"mode,omitempty"` // voice, text
+	SDP       string          `json:"sdp,omitempty"`
+	Candidate json.RawMessage `json:"candidate,omitempty"`
+	Text      string          `json:"text,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	URL       string          `json:"url,omitempty"`
+	// TraceParent and TraceState are W3C trace context; a human's "call"
+	// may carry them, and the hub passes its own on to the bot
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+}
+
+// messageCarrier exposes a Message's trace fields to propagators
+type messageCarrier struct{ msg *Message }
+
+func (c messageCarrier) Get(key string) string {
+	switch key {
+	case "traceparent":
+		return c.msg.TraceParent
+	case "tracestate":
+		return c.msg.TraceState
+	}
+	return ""
+}
+
+func (c messageCarrier) Set(key, value string) {
+	switch key {
+	case "traceparent":
+		c.msg.TraceParent = value
+	case "tracestate":
+		c.msg.TraceState = value
+	}
+}
+
+func (c messageCarrier) Keys() []string { return []string{"traceparent", "tracestate"} }
+
+const tracerName = "github.com/TheOrionAI/botcall-server/internal/signaling"
+
+var propagator = propagation.TraceContext{}
+
+const (
+	// DefaultRingTimeout bounds the wait for the bot to accept
+	DefaultRingTimeout = 30 * time.Second
+	// DefaultNegotiateTimeout bounds the wait for an SDP answer after accept
+	DefaultNegotiateTimeout = 30 * time.Second
+	// maxHangupReason caps the reason a party may give for hanging up
+	maxHangupReason = 64
+)
+
+type callState int
+
+const (
+	stateRinging callState = iota
+	stateNegotiating
+	stateActive
+)
+
+// Call is a human/bot pairing tracked by the hub
+type Call struct {
+	ID        string
+	AgentID   string
+	HumanID   string
+	StartedAt time.Time
+
+	human *Peer
+	bot   *Peer
+	state callState
+	timer *time.Timer
+	// setup spans ringing and negotiation; nil once the call is up or over
+	setup trace.Span
+}
+
+// Hub pairs human and bot sockets and relays signaling between them
+type Hub struct {
+	RingTimeout      time.Duration
+	NegotiateTimeout time.Duration
+	// Admit, if set, vets each call before the bot is rung; a non-empty
+	// reason hangs the call up with it. calls is how many calls agentID
+	// already has. Admit runs with the hub locked, so that concurrent calls
+	// are vetted one at a time, and must not call back into the hub.
+	Admit func(agentID string, calls int) (reason string)
+
+	mu     sync.Mutex
+	bots   map[string]*Peer
+	calls  map[string]*Call
+	relays pending
+
+	watchMu  sync.Mutex
+	watchers map[chan CallEvent]struct{}
+}
+
+// NewHub returns a hub with default timeouts
+func NewHub() *Hub {
+	return &Hub{
+		RingTimeout:      DefaultRingTimeout,
+		NegotiateTimeout: DefaultNegotiateTimeout,
+		bots:             make(map[string]*Peer),
+		calls:            make(map[string]*Call),
+	}
+}
+
+// BotOnline reports whether agentID has a signaling socket attached
+func (h *Hub) BotOnline(agentID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.bots[agentID]
+	return ok
+}
+
+// HasCall reports whether id is a call the hub is ringing or carrying
+func (h *Hub) HasCall(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.calls[id]
+	return ok
+}
+
+// ActiveCalls returns the number of calls the hub is tracking
+func (h *Hub) ActiveCalls() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.calls)
+}
+
+// Serve reads frames from p until its socket closes, then tears down every
+// call it was part of. Bot peers are attached first if Attach was not
+// already called.
+func (h *Hub) Serve(p *Peer) {
+	h.Attach(p)
+	defer h.detach(p)
+
+	for {
+		data, err := p.read()
+		if err != nil {
+			return
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+			p.Send(Message{Type: TypeError, Reason: ReasonBadMessage})
+			continue
+		}
+		h.handle(p, msg, data)
+	}
+}
+
+// Attach makes a bot peer the one that receives calls for its agent ID,
+// replacing any earlier socket. It is a no-op for human peers.
+func (h *Hub) Attach(p *Peer) {
+	if p.Role != RoleBot {
+		return
+	}
+	h.mu.Lock()
+	old := h.bots[p.AgentID]
+	h.bots[p.AgentID] = p
+	h.mu.Unlock()
+
+	if old == p {
+		return
+	}
+	if old != nil {
+		slog.Info("signaling: new bot socket replaces the old one", "agent_id", p.AgentID)
+		old.SendAndClose(Message{Type: TypeError, Reason: ReasonReplaced})
+	}
+}
+
+func (h *Hub) detach(p *Peer) {
+	h.mu.Lock()
+	if p.Role == RoleBot && h.bots[p.AgentID] == p {
+		delete(h.bots, p.AgentID)
+	}
+	var ended []*Call
+	for _, call := range h.calls {
+		if call.human == p || call.bot == p {
+			ended = append(ended, call)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, call := range ended {
+		h.end(call, ReasonDisconnected, p)
+	}
+	p.Close()
+}
+
+func (h *Hub) handle(p *Peer, msg Message, raw []byte) {
+	switch msg.Type {
+	case TypeCall:
+		if p.Role != RoleHuman {
+			p.Send(Message{Type: TypeError, Reason: ReasonBadMessage})
+			return
+		}
+		h.startCall(p, msg)
+
+	case TypeAccept, TypeReject:
+		if p.Role != RoleBot {
+			p.Send(Message{Type: TypeError, CallID: msg.CallID, Reason: ReasonBadMessage})
+			return
+		}
+		h.answerRing(p, msg, raw)
+
+	case TypeOffer, TypeAnswer, TypeCandidate, TypeText:
+		h.relay(p, msg, raw)
+
+	case TypeResponse:
+		if p.Role != RoleBot {
+			p.Send(Message{Type: TypeError, Reason: ReasonBadMessage})
+			return
+		}
+		h.handleResponse(p, raw)
+
+	case TypeHangup:
+		call := h.callFor(p, msg.CallID)
+		if call == nil {
+			return
+		}
+		// Pass on why, e.g. a bot's "goodbye" or "transferred"
+		reason := msg.Reason
+		if reason == "" || len(reason) > maxHangupReason {
+			reason = ReasonNormal
+		}
+		h.end(call, reason, p)
+
+	default:
+		p.Send(Message{Type: TypeError, CallID: msg.CallID, Reason: ReasonBadMessage})
+	}
+}
+
+func (h *Hub) startCall(human *Peer, msg Message) {
+	ctx := propagator.Extract(context.Background(), messageCarrier{&msg})
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "signaling.setup", trace.WithAttributes(
+		attribute.String("botcall.agent_id", human.AgentID),
+		attribute.String("botcall.human_id", msg.HumanID),
+	))
+	h.mu.Lock()
+	bot, ok := h.bots[human.AgentID]
+	reason := ReasonBotUnavailable
+	if ok && h.Admit != nil {
+		// Vetted and counted under one lock, or two calls could both
+		// take an agent's last free slot
+		if reason = h.Admit(human.AgentID, h.callsFor(human.AgentID)); reason != "" {
+			ok = false
+		}
+	}
+	if !ok {
+		h.mu.Unlock()
+		endSetup(span, reason)
+		human.Send(Message{Type: TypeHangup, AgentID: human.AgentID, Reason: reason})
+		return
+	}
+	call := &Call{
+		ID:        newID("call-"),
+		AgentID:   human.AgentID,
+		HumanID:   msg.HumanID,
+		StartedAt: time.Now(),
+		human:     human,
+		bot:       bot,
+		state:     stateRinging,
+		setup:     span,
+	}
+	span.SetAttributes(attribute.String("botcall.call_id", call.ID))
+	h.calls[call.ID] = call
+	call.timer = time.AfterFunc(h.RingTimeout, func() { h.timeout(call, stateRinging) })
+	h.publish(CallStarted, call)
+	h.mu.Unlock()
+
+	slog.Info("signaling: call started", "call_id", call.ID, "human_id", call.HumanID, "agent_id", call.AgentID)
+	ring := Message{Type: TypeCall, CallID: call.ID, AgentID: call.AgentID, HumanID: call.HumanID, Mode: msg.Mode}
+	propagator.Inject(ctx, messageCarrier{&ring})
+	bot.Send(ring)
+	human.Send(Message{Type: TypeRinging, CallID: call.ID, AgentID: call.AgentID})
+}
+
+func (h *Hub) answerRing(bot *Peer, msg Message, raw []byte) {
+	call := h.callFor(bot, msg.CallID)
+	if call == nil {
+		return
+	}
+
+	if msg.Type == TypeReject {
+		call.human.SendRaw(raw)
+		h.end(call, ReasonRejected, bot)
+		return
+	}
+
+	h.mu.Lock()
+	if call.state != stateRinging {
+		h.mu.Unlock()
+		return
+	}
+	call.timer.Stop()
+	if msg.Mode == "text" {
+		// Text-only bots never answer an offer; the call is up already
+		call.state = stateActive
+		call.endSetup("active")
+	} else {
+		call.setup.AddEvent("accepted")
+		call.state = stateNegotiating
+		call.timer = time.AfterFunc(h.NegotiateTimeout, func() { h.timeout(call, stateNegotiating) })
+	}
+	h.mu.Unlock()
+
+	call.human.SendRaw(raw)
+}
+
+// relay forwards a frame to the other side of the call
+func (h *Hub) relay(from *Peer, msg Message, raw []byte) {
+	call := h.callFor(from, msg.CallID)
+	if call == nil {
+		return
+	}
+
+	h.mu.Lock()
+	if call.state == stateRinging {
+		h.mu.Unlock()
+		from.Send(Message{Type: TypeError, CallID: call.ID, Reason: "not_accepted"})
+		return
+	}
+	if msg.Type == TypeAnswer && call.state == stateNegotiating {
+		call.state = stateActive
+		call.timer.Stop()
+		call.endSetup("active")
+	}
+	h.mu.Unlock()
+
+	h.other(call, from).SendRaw(raw)
+}
+
+// callFor returns the call with id if p is a party to it
+func (h *Hub) callFor(p *Peer, id string) *Call {
+	h.mu.Lock()
+	call, ok := h.calls[id]
+	h.mu.Unlock()
+
+	if !ok || (call.human != p && call.bot != p) {
+		p.Send(Message{Type: TypeError, CallID: id, Reason: ReasonUnknownCall})
+		return nil
+	}
+	return call
+}
+
+func (h *Hub) other(call *Call, p *Peer) *Peer {
+	if call.human == p {
+		return call.bot
+	}
+	return call.human
+}
+
+func (h *Hub) timeout(call *Call, state callState) {
+	h.mu.Lock()
+	current, ok := h.calls[call.ID]
+	stale := !ok || current.state != state
+	h.mu.Unlock()
+	if stale {
+		return
+	}
+	h.end(call, ReasonTimeout, nil)
+}
+
+// end removes the call and tells the parties other than by why it ended
+func (h *Hub) end(call *Call, reason string, by *Peer) {
+	h.mu.Lock()
+	if _, ok := h.calls[call.ID]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.calls, call.ID)
+	call.timer.Stop()
+	call.endSetup(reason)
+	h.publish(CallEnded, call)
+	h.mu.Unlock()
+
+	bye := Message{Type: TypeHangup, CallID: call.ID, AgentID: call.AgentID, Reason: reason}
+	for _, p := range []*Peer{call.human, call.bot} {
+		if p != by {
+			p.Send(bye)
+		}
+	}
+	slog.Info("signaling: call ended", "call_id", call.ID, "reason", reason)
+}
+
+// endSetup closes the setup span with its outcome: "active", or the reason
+// the call ended before it got there. Callers hold h.mu.
+func (call *Call) endSetup(outcome string) {
+	if call.setup != nil {
+		endSetup(call.setup, outcome)
+		call.setup = nil
+	}
+}
+
+func endSetup(span trace.Span, outcome string) {
+	span.SetAttributes(attribute.String("botcall.outcome", outcome))
+	span.End()
+}
+
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
@@ -0,0 +1,420 @@
+package signaling_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/TheOrionAI/botcall-server/internal/signaling"
+)
+
+// newHubServer serves the hub at /?agent=<id>&role=<role>
+func newHubServer(t *testing.T, hub *signaling.Hub) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		q := r.URL.Query()
+		hub.Serve(signaling.NewPeer(conn, signaling.Role(q.Get("role")), q.Get("agent")))
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+type client struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dial(t *testing.T, base, agentID string, role signaling.Role) *client {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(base+"/?agent="+agentID+"&role="+string(role), nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", role, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn}
+}
+
+func (c *client) send(v interface{}) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(v); err != nil {
+		c.t.Fatalf("send: %v", err)
+	}
+}
+
+func (c *client) recv() map[string]interface{} {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg map[string]interface{}
+	if err := c.conn.ReadJSON(&msg); err != nil {
+		c.t.Fatalf("recv: %v", err)
+	}
+	return msg
+}
+
+func (c *client) expect(typ string) map[string]interface{} {
+	c.t.Helper()
+	msg := c.recv()
+	if msg["type"] != typ {
+		c.t.Fatalf("got %v, want type %q", msg, typ)
+	}
+	return msg
+}
+
+// waitBot waits until the hub has attached the bot socket
+func waitBot(t *testing.T, hub *signaling.Hub, agentID string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !hub.BotOnline(agentID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("bot %s never attached", agentID)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// ring places a call from human to bot and returns its call ID
+func ring(t *testing.T, human, bot *client) string {
+	t.Helper()
+	human.send(signaling.Message{Type: signaling.TypeCall, HumanID: "alice", Mode: "voice"})
+	incoming := bot.expect(signaling.TypeCall)
+	if incoming["human_id"] != "alice" || incoming["mode"] != "voice" {
+		t.Fatalf("incoming call = %v", incoming)
+	}
+	ringing := human.expect(signaling.TypeRinging)
+	if ringing["call_id"] != incoming["call_id"] {
+		t.Fatalf("ringing %v does not match call %v", ringing, incoming)
+	}
+	return incoming["call_id"].(string)
+}
+
+func TestCallSetupAndRelay(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	callID := ring(t, human, bot)
+
+	bot.send(signaling.Message{Type: signaling.TypeAccept, CallID: callID})
+	human.expect(signaling.TypeAccept)
+
+	// Relayed frames arrive verbatim, including fields the hub ignores
+	human.send(map[string]interface{}{"type": "offer", "call_id": callID, "sdp": "v=0 offer", "extra": 1})
+	offer := bot.expect(signaling.TypeOffer)
+	if offer["sdp"] != "v=0 offer" || offer["extra"] != float64(1) {
+		t.Fatalf("offer = %v", offer)
+	}
+
+	bot.send(signaling.Message{Type: signaling.TypeAnswer, CallID: callID, SDP: "v=0 answer"})
+	if answer := human.expect(signaling.TypeAnswer); answer["sdp"] != "v=0 answer" {
+		t.Fatalf("answer = %v", answer)
+	}
+
+	candidate := json.RawMessage(`{"candidate":"candidate:1 1 udp 1 10.0.0.1 5000 typ host","sdpMid":"0"}`)
+	bot.send(signaling.Message{Type: signaling.TypeCandidate, CallID: callID, Candidate: candidate})
+	got := human.expect(signaling.TypeCandidate)
+	if c, _ := got["candidate"].(map[string]interface{}); c["sdpMid"] != "0" {
+		t.Fatalf("candidate = %v", got)
+	}
+
+	human.send(signaling.Message{Type: signaling.TypeHangup, CallID: callID})
+	if bye := bot.expect(signaling.TypeHangup); bye["reason"] != signaling.ReasonNormal {
+		t.Fatalf("hangup = %v", bye)
+	}
+	if n := hub.ActiveCalls(); n != 0 {
+		t.Fatalf("ActiveCalls = %d after hangup", n)
+	}
+}
+
+func TestHangupCarriesReason(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+	callID := ring(t, human, bot)
+	bot.send(signaling.Message{Type: signaling.TypeAccept, CallID: callID, Mode: "text"})
+	human.expect(signaling.TypeAccept)
+
+	bot.send(signaling.Message{Type: signaling.TypeHangup, CallID: callID, Reason: "goodbye"})
+	if bye := human.expect(signaling.TypeHangup); bye["reason"] != "goodbye" || bye["call_id"] != callID {
+		t.Fatalf("hangup = %v", bye)
+	}
+
+	// Overlong reasons are replaced
+	callID = ring(t, human, bot)
+	bot.send(signaling.Message{Type: signaling.TypeAccept, CallID: callID, Mode: "text"})
+	human.expect(signaling.TypeAccept)
+	human.send(signaling.Message{Type: signaling.TypeHangup, CallID: callID, Reason: strings.Repeat("x", 65)})
+	if bye := bot.expect(signaling.TypeHangup); bye["reason"] != signaling.ReasonNormal {
+		t.Fatalf("hangup = %v", bye)
+	}
+}
+
+func TestCallCarriesTraceContext(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	const traceID, humanSpan = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	human.send(signaling.Message{Type: signaling.TypeCall, HumanID: "alice", TraceParent: "00-" + traceID + "-" + humanSpan + "-01"})
+	incoming := bot.expect(signaling.TypeCall)
+	parent, _ := incoming["traceparent"].(string)
+	if !strings.HasPrefix(parent, "00-"+traceID+"-") || strings.Contains(parent, humanSpan) {
+		t.Fatalf("Bot got traceparent %q, want the hub's span in the human's trace", parent)
+	}
+	human.expect(signaling.TypeRinging)
+	bot.send(signaling.Message{Type: signaling.TypeAccept, CallID: incoming["call_id"].(string), Mode: "text"})
+	human.expect(signaling.TypeAccept)
+
+	ended := spans.Ended()
+	if len(ended) != 1 || ended[0].Name() != "signaling.setup" {
+		t.Fatalf("Ended spans = %v", ended)
+	}
+	setup := ended[0]
+	if setup.Parent().SpanID().String() != humanSpan || !strings.Contains(parent, setup.SpanContext().SpanID().String()) {
+		t.Errorf("Setup span %v is not between the human and the bot", setup.SpanContext())
+	}
+	want := attribute.String("botcall.outcome", "active")
+	found := false
+	for _, attr := range setup.Attributes() {
+		found = found || attr == want
+	}
+	if !found {
+		t.Errorf("Setup span attributes = %v", setup.Attributes())
+	}
+}
+
+func TestTextOnlyAcceptSkipsNegotiation(t *testing.T) {
+	hub := signaling.NewHub()
+	hub.NegotiateTimeout = 50 * time.Millisecond
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	callID := ring(t, human, bot)
+	bot.send(signaling.Message{Type: signaling.TypeAccept, CallID: callID, Mode: "text"})
+	human.expect(signaling.TypeAccept)
+
+	time.Sleep(100 * time.Millisecond)
+	human.send(signaling.Message{Type: signaling.TypeText, CallID: callID, Text: "hi"})
+	if msg := bot.expect(signaling.TypeText); msg["text"] != "hi" {
+		t.Fatalf("text = %v", msg)
+	}
+}
+
+func TestCallBotUnavailable(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+	human := dial(t, base, "nobody", signaling.RoleHuman)
+
+	human.send(signaling.Message{Type: signaling.TypeCall, HumanID: "alice"})
+	if bye := human.expect(signaling.TypeHangup); bye["reason"] != signaling.ReasonBotUnavailable {
+		t.Fatalf("hangup = %v", bye)
+	}
+}
+
+func TestCallRejected(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	callID := ring(t, human, bot)
+	bot.send(signaling.Message{Type: signaling.TypeReject, CallID: callID, Reason: "busy"})
+	if msg := human.expect(signaling.TypeReject); msg["reason"] != "busy" {
+		t.Fatalf("reject = %v", msg)
+	}
+	if bye := human.expect(signaling.TypeHangup); bye["reason"] != signaling.ReasonRejected {
+		t.Fatalf("hangup = %v", bye)
+	}
+}
+
+func TestRingTimeout(t *testing.T) {
+	hub := signaling.NewHub()
+	hub.RingTimeout = 50 * time.Millisecond
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	ring(t, human, bot)
+	if bye := human.expect(signaling.TypeHangup); bye["reason"] != signaling.ReasonTimeout {
+		t.Fatalf("human hangup = %v", bye)
+	}
+	if bye := bot.expect(signaling.TypeHangup); bye["reason"] != signaling.ReasonTimeout {
+		t.Fatalf("bot hangup = %v", bye)
+	}
+}
+
+func TestNegotiateTimeout(t *testing.T) {
+	hub := signaling.NewHub()
+	hub.NegotiateTimeout = 50 * time.Millisecond
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	callID := ring(t, human, bot)
+	bot.send(signaling.Message{Type: signaling.TypeAccept, CallID: callID})
+	human.expect(signaling.TypeAccept)
+
+	// No answer ever comes
+	if bye := human.expect(signaling.TypeHangup); bye["reason"] != signaling.ReasonTimeout {
+		t.Fatalf("hangup = %v", bye)
+	}
+}
+
+func TestDisconnectEndsCall(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	callID := ring(t, human, bot)
+	human.conn.Close()
+
+	bye := bot.expect(signaling.TypeHangup)
+	if bye["call_id"] != callID || bye["reason"] != signaling.ReasonDisconnected {
+		t.Fatalf("hangup = %v", bye)
+	}
+}
+
+func TestRelayRequiresMembership(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+	intruder := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	callID := ring(t, human, bot)
+	intruder.send(signaling.Message{Type: signaling.TypeHangup, CallID: callID})
+	if msg := intruder.expect(signaling.TypeError); msg["reason"] != signaling.ReasonUnknownCall {
+		t.Fatalf("error = %v", msg)
+	}
+	if n := hub.ActiveCalls(); n != 1 {
+		t.Fatalf("ActiveCalls = %d, intruder ended the call", n)
+	}
+}
+
+func TestBotSocketReplaced(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+	old := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	dial(t, base, "bot-1", signaling.RoleBot)
+
+	if msg := old.expect(signaling.TypeError); msg["reason"] != signaling.ReasonReplaced {
+		t.Fatalf("error = %v", msg)
+	}
+	if !hub.BotOnline("bot-1") {
+		t.Fatal("replacement bot socket was detached")
+	}
+}
+
+func TestWatchCallEvents(t *testing.T) {
+	hub := signaling.NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := hub.Watch(ctx)
+
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	callID := ring(t, human, bot)
+	if ev := <-events; ev.Type != signaling.CallStarted || ev.CallID != callID || ev.Active != 1 {
+		t.Fatalf("event = %+v", ev)
+	}
+	if n := hub.CallsFor("bot-1"); n != 1 {
+		t.Fatalf("CallsFor = %d", n)
+	}
+
+	human.send(signaling.Message{Type: signaling.TypeHangup, CallID: callID})
+	if ev := <-events; ev.Type != signaling.CallEnded || ev.AgentID != "bot-1" || ev.Active != 0 {
+		t.Fatalf("event = %+v", ev)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestAdmitRefusesCall(t *testing.T) {
+	hub := signaling.NewHub()
+	hub.Admit = func(agentID string, calls int) string {
+		if agentID == "bot-1" {
+			return signaling.ReasonBusy
+		}
+		return ""
+	}
+	base := newHubServer(t, hub)
+	dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	human := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	human.send(signaling.Message{Type: signaling.TypeCall, HumanID: "alice"})
+	if bye := human.expect(signaling.TypeHangup); bye["reason"] != signaling.ReasonBusy {
+		t.Fatalf("hangup = %v", bye)
+	}
+	if n := hub.ActiveCalls(); n != 0 {
+		t.Fatalf("ActiveCalls = %d after refusal", n)
+	}
+}
+
+func TestAdmitSeesConcurrentCalls(t *testing.T) {
+	hub := signaling.NewHub()
+	hub.Admit = func(agentID string, calls int) string {
+		// Widen the window a racing call would slip through
+		time.Sleep(20 * time.Millisecond)
+		if calls >= 1 {
+			return signaling.ReasonBusy
+		}
+		return ""
+	}
+	base := newHubServer(t, hub)
+	dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+	alice := dial(t, base, "bot-1", signaling.RoleHuman)
+	bob := dial(t, base, "bot-1", signaling.RoleHuman)
+
+	alice.send(signaling.Message{Type: signaling.TypeCall, HumanID: "alice"})
+	bob.send(signaling.Message{Type: signaling.TypeCall, HumanID: "bob"})
+	first, second := alice.recv()["type"], bob.recv()["type"]
+	if (first == signaling.TypeRinging) == (second == signaling.TypeRinging) {
+		t.Fatalf("Calls got %v and %v, want one ringing and one hangup", first, second)
+	}
+	if n := hub.ActiveCalls(); n != 1 {
+		t.Fatalf("ActiveCalls = %d", n)
+	}
+}
@@ -0,0 +1,130 @@
+package signaling
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Role says which side of a call a socket speaks for
+type Role string
+
+const (
+	RoleHuman Role = "human"
+	RoleBot   Role = "bot"
+)
+
+const (
+	sendBuffer   = 64
+	writeTimeout = 10 * time.Second
+	maxFrameSize = 64 << 10
+)
+
+// Peer is one signaling WebSocket. All writes go through Send so the hub,
+// presence updates and heartbeats can share the connection safely.
+type Peer struct {
+	Role    Role
+	AgentID string
+
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPeer wraps conn and starts its writer
+func NewPeer(conn *websocket.Conn, role Role, agentID string) *Peer {
+	p := &Peer{
+		Role:    role,
+		AgentID: agentID,
+		conn:    conn,
+		send:    make(chan []byte, sendBuffer),
+		done:    make(chan struct{}),
+	}
+	// Bots answer relayed requests with whole bodies in one frame
+	if role == RoleBot {
+		conn.SetReadLimit(maxBotFrameSize)
+	} else {
+		conn.SetReadLimit(maxFrameSize)
+	}
+	go p.writeLoop()
+	return p
+}
+
+// Send queues v as a JSON text frame. A peer that falls too far behind is
+// disconnected rather than allowed to stall the hub.
+func (p *Peer) Send(v interface{}) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("signaling: marshal", "type", fmt.Sprintf("%T", v), "err", err)
+		return false
+	}
+	return p.SendRaw(data)
+}
+
+// SendRaw queues an already encoded frame
+func (p *Peer) SendRaw(data []byte) bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	select {
+	case p.send <- data:
+		return true
+	default:
+		slog.Warn("signaling: socket is not keeping up, closing", "role", p.Role, "agent_id", p.AgentID)
+		p.Close()
+		return false
+	}
+}
+
+// SendAndClose queues v as the last frame and closes the socket once it
+// has been written
+func (p *Peer) SendAndClose(v interface{}) {
+	if !p.Send(v) || !p.SendRaw(nil) {
+		p.Close()
+	}
+}
+
+// Done is closed once the peer is closed
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+// Close shuts the socket down; safe to call more than once
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.conn.Close()
+	})
+}
+
+func (p *Peer) writeLoop() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case data := <-p.send:
+			if data == nil {
+				p.Close()
+				return
+			}
+			p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := p.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				p.Close()
+				return
+			}
+		}
+	}
+}
+
+// read returns the next frame from the socket
+func (p *Peer) read() ([]byte, error) {
+	_, data, err := p.conn.ReadMessage()
+	return data, err
+}
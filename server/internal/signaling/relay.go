@@ -0,0 +1,134 @@
+package signaling
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Relay frames carry HTTP requests to bots that cannot accept inbound
+// connections. The hub sends a "request" down the bot's socket and the bot
+// answers with a "response" carrying the same request_id.
+const (
+	TypeRequest  = "request"  // hub → bot
+	TypeResponse = "response" // bot → hub
+)
+
+// MaxRelayBodySize bounds the HTTP bodies relayed over a bot socket, in
+// either direction. A body travels base64 in a single frame, so bot
+// sockets read frames up to maxBotFrameSize.
+const MaxRelayBodySize = 1 << 20
+
+// maxBotFrameSize fits a RelayResponse carrying MaxRelayBodySize, with
+// maxFrameSize to spare for its status and headers
+var maxBotFrameSize = int64(base64.StdEncoding.EncodedLen(MaxRelayBodySize) + maxFrameSize)
+
+// ErrBotUnavailable is returned by Forward when no bot socket is attached
+var ErrBotUnavailable = errors.New("signaling: bot has no relay socket")
+
+// RelayRequest is an HTTP request forwarded to a bot over its socket.
+// Body is base64 in JSON, so any payload survives the trip.
+type RelayRequest struct {
+	Type      string      `json:"type"` // always "request"
+	RequestID string      `json:"request_id"`
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Header    http.Header `json:"header,omitempty"`
+	Body      []byte      `json:"body,omitempty"`
+}
+
+// RelayResponse is the bot's answer to a RelayRequest
+type RelayResponse struct {
+	Type      string      `json:"type"` // always "response"
+	RequestID string      `json:"request_id"`
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header,omitempty"`
+	Body      []byte      `json:"body,omitempty"`
+}
+
+// pending tracks relay requests awaiting a response, per bot peer
+type pending struct {
+	mu      sync.Mutex
+	waiters map[string]waiter
+}
+
+type waiter struct {
+	bot *Peer
+	ch  chan *RelayResponse
+}
+
+func (p *pending) add(id string, bot *Peer) chan *RelayResponse {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.waiters == nil {
+		p.waiters = make(map[string]waiter)
+	}
+	ch := make(chan *RelayResponse, 1)
+	p.waiters[id] = waiter{bot: bot, ch: ch}
+	return ch
+}
+
+func (p *pending) remove(id string) {
+	p.mu.Lock()
+	delete(p.waiters, id)
+	p.mu.Unlock()
+}
+
+// resolve hands resp to its waiter if it came from the peer that was asked
+func (p *pending) resolve(from *Peer, resp *RelayResponse) bool {
+	p.mu.Lock()
+	w, ok := p.waiters[resp.RequestID]
+	if ok && w.bot == from {
+		delete(p.waiters, resp.RequestID)
+	}
+	p.mu.Unlock()
+
+	if !ok || w.bot != from {
+		return false
+	}
+	w.ch <- resp
+	return true
+}
+
+// Forward sends req to the bot attached for agentID and waits for its
+// response, until ctx is done or the bot's socket closes
+func (h *Hub) Forward(ctx context.Context, agentID string, req *RelayRequest) (*RelayResponse, error) {
+	h.mu.Lock()
+	bot, ok := h.bots[agentID]
+	h.mu.Unlock()
+	if !ok {
+		return nil, ErrBotUnavailable
+	}
+
+	req.Type = TypeRequest
+	req.RequestID = newID("req-")
+	ch := h.relays.add(req.RequestID, bot)
+	defer h.relays.remove(req.RequestID)
+
+	if !bot.Send(req) {
+		return nil, ErrBotUnavailable
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-bot.Done():
+		return nil, ErrBotUnavailable
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (h *Hub) handleResponse(from *Peer, raw []byte) {
+	var resp RelayResponse
+	if err := json.Unmarshal(raw, &resp); err != nil || resp.RequestID == "" {
+		from.Send(Message{Type: TypeError, Reason: ReasonBadMessage})
+		return
+	}
+	if !h.relays.resolve(from, &resp) {
+		from.Send(Message{Type: TypeError, Reason: "unknown_request"})
+	}
+}
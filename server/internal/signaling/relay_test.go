@@ -0,0 +1,103 @@
+package signaling_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TheOrionAI/botcall-server/internal/signaling"
+)
+
+func TestForward(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+
+	type result struct {
+		resp *signaling.RelayResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := hub.Forward(context.Background(), "bot-1", &signaling.RelayRequest{
+			Method: http.MethodPost,
+			Path:   "/call",
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   []byte(`{"human_id":"alice"}`),
+		})
+		done <- result{resp, err}
+	}()
+
+	var req signaling.RelayRequest
+	bot.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := bot.conn.ReadJSON(&req); err != nil {
+		t.Fatalf("bot read: %v", err)
+	}
+	if req.Type != signaling.TypeRequest || req.Path != "/call" || string(req.Body) != `{"human_id":"alice"}` {
+		t.Fatalf("bot got %+v", req)
+	}
+
+	bot.send(signaling.RelayResponse{
+		Type:      signaling.TypeResponse,
+		RequestID: req.RequestID,
+		Status:    http.StatusCreated,
+		Body:      []byte(`{"status":"accepted"}`),
+	})
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("Forward: %v", r.err)
+	}
+	if r.resp.Status != http.StatusCreated || string(r.resp.Body) != `{"status":"accepted"}` {
+		t.Fatalf("response = %+v", r.resp)
+	}
+}
+
+func TestForwardWithoutBot(t *testing.T) {
+	hub := signaling.NewHub()
+	_, err := hub.Forward(context.Background(), "nobody", &signaling.RelayRequest{Method: http.MethodGet, Path: "/health"})
+	if !errors.Is(err, signaling.ErrBotUnavailable) {
+		t.Fatalf("err = %v, want ErrBotUnavailable", err)
+	}
+}
+
+func TestForwardBotDisconnects(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+	bot := dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := hub.Forward(context.Background(), "bot-1", &signaling.RelayRequest{Method: http.MethodGet, Path: "/health"})
+		done <- err
+	}()
+	bot.expect(signaling.TypeRequest)
+	bot.conn.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, signaling.ErrBotUnavailable) {
+			t.Fatalf("err = %v, want ErrBotUnavailable", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Forward did not return after the bot went away")
+	}
+}
+
+func TestForwardTimeout(t *testing.T) {
+	hub := signaling.NewHub()
+	base := newHubServer(t, hub)
+	dial(t, base, "bot-1", signaling.RoleBot)
+	waitBot(t, hub, "bot-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := hub.Forward(ctx, "bot-1", &signaling.RelayRequest{Method: http.MethodGet, Path: "/health"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+}
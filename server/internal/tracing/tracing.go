@@ -0,0 +1,175 @@
+// Package tracing wires the server into OpenTelemetry. Spans follow a call
+// from the PWA's lookup through registration, relayed /call requests and
+// signaling; W3C traceparent headers (and the traceparent field of
+// signaling messages) carry the context between processes. With no
+// exporter configured the spans are no-ops, but incoming trace context is
+// still passed on, so a traced PWA and bot stay in one trace.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/TheOrionAI/botcall-server/internal/httpstatus"
+	"github.com/TheOrionAI/botcall-server/internal/logging"
+)
+
+// Name identifies the server's tracer
+const Name = "github.com/TheOrionAI/botcall-server"
+
+// Exporters accepted by Setup
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout" // one JSON span per line on stdout
+	ExporterFile   = "file"   // the same, appended to Config.File
+	ExporterOTLP   = "otlp"   // OTLP over HTTP to Config.Endpoint
+)
+
+// Propagator reads and writes W3C trace context and baggage
+var Propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Config selects where spans go
+type Config struct {
+	Exporter string
+	// File is appended to by the file exporter
+	File string
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://localhost:4318;
+	// empty falls back to OTEL_EXPORTER_OTLP_ENDPOINT and then localhost
+	Endpoint string
+	// SampleRatio is the share of new traces recorded; traces started
+	// upstream follow the caller's sampling decision
+	SampleRatio float64
+	ServiceName string
+	Version     string
+}
+
+// Setup installs the global tracer provider and propagator for cfg. The
+// returned function flushes buffered spans and closes the exporter.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(Propagator)
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("the file exporter needs a file")
+		}
+		file, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open trace file: %w", err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q (want none, stdout, file or otlp)", cfg.Exporter)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, fmt.Errorf("trace exporter: %w", err)
+	}
+
+	name := cfg.ServiceName
+	if name == "" {
+		name = "botcall-server"
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(name),
+			semconv.ServiceVersion(cfg.Version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}, nil
+}
+
+// Start begins a span on the server's tracer
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(Name).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, on span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace context of ctx into outgoing headers
+func Inject(ctx context.Context, header http.Header) {
+	Propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Instrument gives every request mux serves a server span named after its
+// method and route, continuing any trace context the client sent. WebSocket
+// upgrades and the routes in streams live for minutes, so they get only the
+// incoming context; their handlers span the parts worth timing.
+func Instrument(mux *http.ServeMux, next http.Handler, streams ...string) http.Handler {
+	untraced := make(map[string]bool, len(streams))
+	for _, route := range streams {
+		untraced[route] = true
+	}
+	tracer := otel.Tracer(Name)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		_, route := mux.Handler(r)
+		if route == "" || untraced[route] || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				attribute.String("botcall.request_id", logging.RequestID(ctx)),
+			))
+		defer span.End()
+
+		rec := httpstatus.NewRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.Status))
+		if rec.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status))
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestFileExporter(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterFile, File: path, SampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var forwarded http.Header
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/lookup/", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "balance.pick")
+		span.End()
+		forwarded = http.Header{}
+		Inject(r.Context(), forwarded)
+	})
+	handler := Instrument(mux, mux)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/v1/lookup/orion", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	names := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var span struct {
+			Name        string
+			SpanContext struct{ TraceID string }
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			t.Fatalf("Bad span line %q: %v", scanner.Text(), err)
+		}
+		names[span.Name] = span.SpanContext.TraceID
+	}
+	for _, name := range []string{"GET /v1/lookup/", "balance.pick"} {
+		if names[name] != traceID {
+			t.Errorf("Span %q in trace %q, want %s (spans: %v)", name, names[name], traceID, names)
+		}
+	}
+	if got := forwarded.Get("traceparent"); len(got) != 55 || got[3:35] != traceID {
+		t.Errorf("Forwarded traceparent = %q", got)
+	}
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{Exporter: "zipkin"}); err == nil {
+		t.Error("Expected an error for an unknown exporter")
+	}
+	if _, err := Setup(context.Background(), Config{Exporter: ExporterFile}); err == nil {
+		t.Error("Expected an error for the file exporter without a file")
+	}
+}
@@ -0,0 +1,45 @@
+package turnserver
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQuotaReservesSlots(t *testing.T) {
+	s := &Server{cfg: Config{MaxUserAllocations: 3}, users: make(map[string]int), reserved: make(map[string][]time.Time)}
+
+	// Checks racing ahead of their allocations cannot overbook the user
+	var granted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.allowAllocation("1700000000:alice", "", nil) {
+				granted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := granted.Load(); n != 3 {
+		t.Fatalf("%d of 20 concurrent checks granted, want 3", n)
+	}
+
+	// An allocation takes over its reservation rather than adding to it
+	s.allocationCreated("1700000000:alice")
+	if s.allowAllocation("1700000000:alice", "", nil) {
+		t.Fatal("Allocation was counted next to its reservation")
+	}
+
+	// Slots whose allocations never came lapse
+	s.mu.Lock()
+	for i := range s.reserved["alice"] {
+		s.reserved["alice"][i] = s.reserved["alice"][i].Add(-reservationTTL)
+	}
+	s.mu.Unlock()
+	if !s.allowAllocation("1700000000:alice", "", nil) {
+		t.Error("Lapsed reservations still hold the quota")
+	}
+}
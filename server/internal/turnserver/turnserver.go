@@ -0,0 +1,371 @@
+// Package turnserver runs an embedded STUN/TURN server, so a single
+// botcall-server box can also be the media fallback for peers that cannot
+// reach each other directly. It accepts the TURN REST credentials issued
+// by package ice and enforces per-user allocation and per-allocation byte
+// quotas.
+package turnserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v4"
+)
+
+const (
+	// DefaultRealm is used when Config.Realm is empty
+	DefaultRealm = "botcall"
+	// DefaultMaxUserAllocations caps concurrent allocations per user
+	DefaultMaxUserAllocations = 10
+	// DefaultMaxAllocationBytes caps the traffic relayed by one allocation
+	DefaultMaxAllocationBytes = 1 << 30
+
+	// reservationTTL is how long a slot the quota check granted is held
+	// for an allocation. pion creates the allocation right after the
+	// check but reports only successes, so a slot whose allocation failed
+	// lapses after this.
+	reservationTTL = 10 * time.Second
+)
+
+// ErrQuotaExceeded ends an allocation that has relayed its byte quota
+var ErrQuotaExceeded = errors.New("turnserver: allocation byte quota exceeded")
+
+// Config selects where the server listens and how much it relays
+type Config struct {
+	// ListenAddr is the host:port for STUN/TURN, e.g. ":3478"
+	ListenAddr string
+	UDP        bool
+	TCP        bool
+	// PublicIP is the address advertised in relay candidates
+	PublicIP net.IP
+	Realm    string
+	// Secret is the TURN REST shared secret credentials are signed with
+	Secret string
+
+	// RelayMinPort and RelayMaxPort bound the relay ports; zero lets the
+	// system choose
+	RelayMinPort uint16
+	RelayMaxPort uint16
+
+	// MaxUserAllocations caps concurrent allocations per TURN REST user;
+	// negative disables the cap
+	MaxUserAllocations int
+	// MaxAllocationBytes caps the bytes one allocation relays in both
+	// directions; negative disables the cap
+	MaxAllocationBytes int64
+}
+
+// Stats are the server's counters
+type Stats struct {
+	ActiveAllocations int64 `json:"active_allocations"`
+	Allocations       int64 `json:"allocations_total"`
+	AuthFailures      int64 `json:"auth_failures_total"`
+	QuotaRejections   int64 `json:"quota_rejections_total"`
+	QuotaExhausted    int64 `json:"quota_exhausted_total"`
+	BytesRelayed      int64 `json:"bytes_relayed_total"`
+}
+
+// Server is a running STUN/TURN server
+type Server struct {
+	cfg  Config
+	turn *turn.Server
+
+	mu       sync.Mutex
+	users    map[string]int         // active allocations per user
+	reserved map[string][]time.Time // slots granted, allocation pending
+
+	active, allocations, authFailures atomic.Int64
+	quotaRejections, quotaExhausted   atomic.Int64
+	bytesRelayed                      atomic.Int64
+}
+
+// Start opens the listeners and begins serving
+func Start(cfg Config) (*Server, error) {
+	if cfg.ListenAddr == "" {
+		return nil, errors.New("turnserver: no listen address")
+	}
+	if cfg.PublicIP == nil {
+		return nil, errors.New("turnserver: public IP is required for relay candidates")
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("turnserver: shared secret is required")
+	}
+	if !cfg.UDP && !cfg.TCP {
+		cfg.UDP = true
+	}
+	if cfg.Realm == "" {
+		cfg.Realm = DefaultRealm
+	}
+	if cfg.MaxUserAllocations == 0 {
+		cfg.MaxUserAllocations = DefaultMaxUserAllocations
+	}
+	if cfg.MaxAllocationBytes == 0 {
+		cfg.MaxAllocationBytes = DefaultMaxAllocationBytes
+	}
+
+	s := &Server{cfg: cfg, users: make(map[string]int), reserved: make(map[string][]time.Time)}
+	loggers := logging.NewDefaultLoggerFactory()
+	loggers.DefaultLogLevel = logging.LogLevelWarn
+
+	serverCfg := turn.ServerConfig{
+		Realm:         cfg.Realm,
+		LoggerFactory: loggers,
+		AuthHandler:   s.authHandler(turn.LongTermTURNRESTAuthHandler(cfg.Secret, loggers.NewLogger("turn"))),
+		QuotaHandler:  s.allowAllocation,
+		EventHandler: turn.EventHandler{
+			OnAuth: func(_, _ net.Addr, _, _, _, _ string, ok bool) {
+				if !ok {
+					s.authFailures.Add(1)
+				}
+			},
+			OnAllocationCreated: func(_, _ net.Addr, _, username, _ string, _ net.Addr, _ int) {
+				s.allocationCreated(username)
+			},
+			OnAllocationDeleted: func(_, _ net.Addr, _, username, _ string) {
+				s.allocationDeleted(username)
+			},
+		},
+	}
+
+	var closers []func() error
+	cleanup := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	if cfg.UDP {
+		conn, err := net.ListenPacket("udp", cfg.ListenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("turnserver: listen udp: %w", err)
+		}
+		closers = append(closers, conn.Close)
+		// Port 0 picks a free port; TCP then shares it
+		cfg.ListenAddr = conn.LocalAddr().String()
+		serverCfg.PacketConnConfigs = append(serverCfg.PacketConnConfigs, turn.PacketConnConfig{
+			PacketConn:            conn,
+			RelayAddressGenerator: s.relayGenerator(),
+		})
+	}
+	if cfg.TCP {
+		ln, err := net.Listen("tcp", cfg.ListenAddr)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("turnserver: listen tcp: %w", err)
+		}
+		closers = append(closers, ln.Close)
+		cfg.ListenAddr = ln.Addr().String()
+		serverCfg.ListenerConfigs = append(serverCfg.ListenerConfigs, turn.ListenerConfig{
+			Listener:              ln,
+			RelayAddressGenerator: s.relayGenerator(),
+		})
+	}
+
+	srv, err := turn.NewServer(serverCfg)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("turnserver: %w", err)
+	}
+	s.cfg.ListenAddr = cfg.ListenAddr
+	s.turn = srv
+	return s, nil
+}
+
+// Close stops the listeners and tears down every allocation
+func (s *Server) Close() error {
+	return s.turn.Close()
+}
+
+// Stats returns a snapshot of the counters
+func (s *Server) Stats() Stats {
+	return Stats{
+		ActiveAllocations: s.active.Load(),
+		Allocations:       s.allocations.Load(),
+		AuthFailures:      s.authFailures.Load(),
+		QuotaRejections:   s.quotaRejections.Load(),
+		QuotaExhausted:    s.quotaExhausted.Load(),
+		BytesRelayed:      s.bytesRelayed.Load(),
+	}
+}
+
+// Addr is the address the server listens on
+func (s *Server) Addr() string {
+	return s.cfg.ListenAddr
+}
+
+// URLs are the stun: and turn: URLs clients should be given
+func (s *Server) URLs() (stunURLs, turnURLs []string) {
+	_, port, _ := net.SplitHostPort(s.cfg.ListenAddr)
+	host := net.JoinHostPort(s.cfg.PublicIP.String(), port)
+	if s.cfg.UDP {
+		stunURLs = append(stunURLs, "stun:"+host)
+		turnURLs = append(turnURLs, "turn:"+host+"?transport=udp")
+	}
+	if s.cfg.TCP {
+		turnURLs = append(turnURLs, "turn:"+host+"?transport=tcp")
+	}
+	return stunURLs, turnURLs
+}
+
+// authHandler counts unknown or expired usernames; wrong passwords are
+// counted by OnAuth
+func (s *Server) authHandler(next turn.AuthHandler) turn.AuthHandler {
+	return func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+		key, ok := next(username, realm, srcAddr)
+		if !ok {
+			s.authFailures.Add(1)
+		}
+		return key, ok
+	}
+}
+
+// user is the TURN REST user part of "<expiry>:<user>"
+func user(username string) string {
+	_, u, _ := strings.Cut(username, ":")
+	return u
+}
+
+// allowAllocation reserves one of the user's slots, so concurrent
+// requests cannot all pass the check before any allocation is counted
+func (s *Server) allowAllocation(username, _ string, _ net.Addr) bool {
+	if s.cfg.MaxUserAllocations < 0 {
+		return true
+	}
+	u := user(username)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := s.pending(u, now)
+	if s.users[u]+len(pending) >= s.cfg.MaxUserAllocations {
+		s.quotaRejections.Add(1)
+		return false
+	}
+	s.reserved[u] = append(pending, now)
+	return true
+}
+
+// pending drops the user's lapsed reservations and returns the rest,
+// oldest first. The caller holds s.mu.
+func (s *Server) pending(u string, now time.Time) []time.Time {
+	live := s.reserved[u]
+	for len(live) > 0 && now.Sub(live[0]) >= reservationTTL {
+		live = live[1:]
+	}
+	if len(live) == 0 {
+		delete(s.reserved, u)
+		return nil
+	}
+	s.reserved[u] = live
+	return live
+}
+
+// allocationCreated turns the user's oldest reservation into an
+// allocation
+func (s *Server) allocationCreated(username string) {
+	u := user(username)
+	s.mu.Lock()
+	if pending := s.pending(u, time.Now()); len(pending) > 0 {
+		if s.reserved[u] = pending[1:]; len(pending) == 1 {
+			delete(s.reserved, u)
+		}
+	}
+	s.users[u]++
+	s.mu.Unlock()
+	s.active.Add(1)
+	s.allocations.Add(1)
+}
+
+func (s *Server) allocationDeleted(username string) {
+	s.mu.Lock()
+	u := user(username)
+	if s.users[u]--; s.users[u] <= 0 {
+		delete(s.users, u)
+	}
+	s.mu.Unlock()
+	s.active.Add(-1)
+}
+
+func (s *Server) relayGenerator() turn.RelayAddressGenerator {
+	var gen turn.RelayAddressGenerator
+	if s.cfg.RelayMinPort != 0 && s.cfg.RelayMaxPort != 0 {
+		gen = &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: s.cfg.PublicIP,
+			Address:      "0.0.0.0",
+			MinPort:      s.cfg.RelayMinPort,
+			MaxPort:      s.cfg.RelayMaxPort,
+		}
+	} else {
+		gen = &turn.RelayAddressGeneratorStatic{
+			RelayAddress: s.cfg.PublicIP,
+			Address:      "0.0.0.0",
+		}
+	}
+	return &quotaGenerator{RelayAddressGenerator: gen, server: s}
+}
+
+// quotaGenerator wraps relay sockets so each allocation's traffic is
+// counted and capped
+type quotaGenerator struct {
+	turn.RelayAddressGenerator
+	server *Server
+}
+
+func (g *quotaGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := g.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &quotaConn{PacketConn: conn, server: g.server}, addr, nil
+}
+
+// quotaConn counts the bytes an allocation relays and fails once it has
+// used its quota, which ends the allocation
+type quotaConn struct {
+	net.PacketConn
+	server *Server
+	used   atomic.Int64
+	over   atomic.Bool
+}
+
+func (c *quotaConn) account(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	c.server.bytesRelayed.Add(int64(n))
+	limit := c.server.cfg.MaxAllocationBytes
+	if limit < 0 || c.used.Add(int64(n)) <= limit {
+		return nil
+	}
+	if c.over.CompareAndSwap(false, true) {
+		c.server.quotaExhausted.Add(1)
+	}
+	return ErrQuotaExceeded
+}
+
+func (c *quotaConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if c.over.Load() {
+		return 0, nil, ErrQuotaExceeded
+	}
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil {
+		err = c.account(n)
+	}
+	return n, addr, err
+}
+
+func (c *quotaConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if c.over.Load() {
+		return 0, ErrQuotaExceeded
+	}
+	n, err := c.PacketConn.WriteTo(p, addr)
+	if err == nil {
+		err = c.account(n)
+	}
+	return n, err
+}
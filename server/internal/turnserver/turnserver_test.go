@@ -0,0 +1,247 @@
+package turnserver_test
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v4"
+
+	"github.com/TheOrionAI/botcall-server/internal/ice"
+	"github.com/TheOrionAI/botcall-server/internal/turnserver"
+)
+
+const secret = "s3cret"
+
+func start(t *testing.T, cfg turnserver.Config) *turnserver.Server {
+	t.Helper()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.PublicIP = net.ParseIP("127.0.0.1")
+	cfg.Secret = secret
+	srv, err := turnserver.Start(cfg)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// dial returns a TURN client for user with credentials signed by key
+func dial(t *testing.T, srv *turnserver.Server, user, key string) *turn.Client {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return newClient(t, srv, user, key, conn)
+}
+
+// dialTCP is dial over a TCP connection of its own
+func dialTCP(t *testing.T, srv *turnserver.Server, user, key string) *turn.Client {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return newClient(t, srv, user, key, turn.NewSTUNConn(conn))
+}
+
+func newClient(t *testing.T, srv *turnserver.Server, user, key string, conn net.PacketConn) *turn.Client {
+	t.Helper()
+	username := ice.Username(time.Now().Add(time.Hour), user)
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: srv.Addr(),
+		TURNServerAddr: srv.Addr(),
+		Username:       username,
+		Password:       ice.Password(key, username),
+		Realm:          turnserver.DefaultRealm,
+		Conn:           conn,
+		RTO:            100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestBindingAndAllocation(t *testing.T) {
+	srv := start(t, turnserver.Config{})
+	client := dial(t, srv, "alice", secret)
+
+	mapped, err := client.SendBindingRequest()
+	if err != nil {
+		t.Fatalf("binding: %v", err)
+	}
+	if !strings.HasPrefix(mapped.String(), "127.0.0.1:") {
+		t.Fatalf("mapped address = %v", mapped)
+	}
+
+	relay, err := client.Allocate()
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	defer relay.Close()
+
+	// A peer sends through the relay and the client receives it
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	if _, err := relay.WriteTo([]byte("ping"), peer.LocalAddr()); err != nil {
+		t.Fatalf("relay write: %v", err)
+	}
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := peer.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("peer read = %q, %v", buf[:n], err)
+	}
+	if _, err := peer.WriteTo([]byte("pong"), from); err != nil {
+		t.Fatal(err)
+	}
+	relay.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, _, err = relay.ReadFrom(buf); err != nil || string(buf[:n]) != "pong" {
+		t.Fatalf("relay read = %q, %v", buf[:n], err)
+	}
+
+	stats := srv.Stats()
+	if stats.ActiveAllocations != 1 || stats.Allocations != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats.BytesRelayed < 8 {
+		t.Fatalf("BytesRelayed = %d, want >= 8", stats.BytesRelayed)
+	}
+}
+
+func TestBadCredentials(t *testing.T) {
+	srv := start(t, turnserver.Config{})
+	client := dial(t, srv, "mallory", "wrong")
+
+	if _, err := client.Allocate(); err == nil {
+		t.Fatal("allocation with a forged password succeeded")
+	}
+	if stats := srv.Stats(); stats.AuthFailures == 0 || stats.Allocations != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestUserAllocationQuota(t *testing.T) {
+	srv := start(t, turnserver.Config{MaxUserAllocations: 1})
+
+	relay, err := dial(t, srv, "alice", secret).Allocate()
+	if err != nil {
+		t.Fatalf("first allocation: %v", err)
+	}
+	defer relay.Close()
+
+	if _, err := dial(t, srv, "alice", secret).Allocate(); err == nil {
+		t.Fatal("second allocation for the same user succeeded")
+	}
+	if stats := srv.Stats(); stats.QuotaRejections != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// Other users have their own quota
+	other, err := dial(t, srv, "bob", secret).Allocate()
+	if err != nil {
+		t.Fatalf("other user: %v", err)
+	}
+	other.Close()
+}
+
+func TestConcurrentAllocationsShareQuota(t *testing.T) {
+	// Each TCP connection is served on its own goroutine
+	srv := start(t, turnserver.Config{TCP: true, MaxUserAllocations: 2})
+	const callers = 8
+	clients := make([]*turn.Client, callers)
+	for i := range clients {
+		clients[i] = dialTCP(t, srv, "alice", secret)
+	}
+
+	var mu sync.Mutex
+	var relays []net.PacketConn
+	var wg sync.WaitGroup
+	ready := make(chan struct{})
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *turn.Client) {
+			defer wg.Done()
+			<-ready
+			if relay, err := client.Allocate(); err == nil {
+				mu.Lock()
+				relays = append(relays, relay)
+				mu.Unlock()
+			}
+		}(client)
+	}
+	close(ready)
+	wg.Wait()
+	for _, relay := range relays {
+		defer relay.Close()
+	}
+
+	if len(relays) != 2 {
+		t.Errorf("%d concurrent allocations granted, want 2", len(relays))
+	}
+	if stats := srv.Stats(); stats.QuotaRejections != callers-2 || stats.ActiveAllocations != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestURLs(t *testing.T) {
+	srv := start(t, turnserver.Config{UDP: true, TCP: true})
+	_, port, _ := net.SplitHostPort(srv.Addr())
+
+	stunURLs, turnURLs := srv.URLs()
+	if len(stunURLs) != 1 || stunURLs[0] != "stun:127.0.0.1:"+port {
+		t.Fatalf("stun = %v", stunURLs)
+	}
+	want := []string{
+		"turn:127.0.0.1:" + port + "?transport=udp",
+		"turn:127.0.0.1:" + port + "?transport=tcp",
+	}
+	if len(turnURLs) != 2 || turnURLs[0] != want[0] || turnURLs[1] != want[1] {
+		t.Fatalf("turn = %v, want %v", turnURLs, want)
+	}
+}
+
+func TestAllocationByteQuota(t *testing.T) {
+	srv := start(t, turnserver.Config{MaxAllocationBytes: 4})
+	relay, err := dial(t, srv, "alice", secret).Allocate()
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	defer relay.Close()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	relay.WriteTo([]byte("ping"), peer.LocalAddr())
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, from, err := peer.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("peer read: %v", err)
+	}
+
+	// The reply takes the allocation over quota and is dropped
+	peer.WriteTo([]byte("pong"), from)
+	relay.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if n, _, err := relay.ReadFrom(buf); err == nil {
+		t.Fatalf("relayed %q past the quota", buf[:n])
+	}
+	if stats := srv.Stats(); stats.QuotaExhausted != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}